@@ -4,21 +4,35 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/netip"
 	"os"
 	"path/filepath"
 
 	"github.com/Datazen-Protocol/pdp-server/pkg/api"
+	"github.com/Datazen-Protocol/pdp-server/pkg/auth"
 	myBlobstore "github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/capacity"
+	"github.com/Datazen-Protocol/pdp-server/pkg/car"
 	"github.com/Datazen-Protocol/pdp-server/pkg/config"
+	"github.com/Datazen-Protocol/pdp-server/pkg/events"
+	"github.com/Datazen-Protocol/pdp-server/pkg/gateway"
+	"github.com/Datazen-Protocol/pdp-server/pkg/gc"
+	"github.com/Datazen-Protocol/pdp-server/pkg/idempotency"
+	"github.com/Datazen-Protocol/pdp-server/pkg/importer"
 	"github.com/Datazen-Protocol/pdp-server/pkg/piece"
 	"github.com/Datazen-Protocol/pdp-server/pkg/piri"
 	"github.com/Datazen-Protocol/pdp-server/pkg/proofset"
+	"github.com/Datazen-Protocol/pdp-server/pkg/ratelimit"
+	"github.com/Datazen-Protocol/pdp-server/pkg/resumable"
+	"github.com/Datazen-Protocol/pdp-server/pkg/scrub"
 	"github.com/Datazen-Protocol/pdp-server/pkg/service"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"github.com/Datazen-Protocol/pdp-server/pkg/upload"
 	"github.com/Datazen-Protocol/pdp-server/pkg/wallet"
 	"github.com/Datazen-Protocol/pdp-server/pkg/watcher"
+	"github.com/Datazen-Protocol/pdp-server/pkg/webhook"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/glebarez/sqlite"
-	"github.com/storacha/piri/pkg/store/blobstore"
 	"gorm.io/gorm"
 
 	"github.com/Datazen-Protocol/pdp-server/pkg/models"
@@ -42,8 +56,16 @@ func main() {
 		log.Fatalf("Failed to start PDP server: %v", err)
 	}
 
+	// Client addresses come from the connection unless it is from a trusted proxy
+	pdpServer.Echo.IPExtractor, err = api.IPExtractor(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+
 	// Register routes
-	api.RegisterRoutes(pdpServer.Echo, pdpServer)
+	if err := api.RegisterRoutes(pdpServer.Echo, pdpServer); err != nil {
+		log.Fatalf("Failed to register routes: %v", err)
+	}
 
 	// Start HTTP server
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
@@ -74,25 +96,87 @@ func initializePDPServer(ctx context.Context, config *config.Config) (*api.PDPSe
 	}
 
 	// Auto-migrate our database schema
-	if err := db.AutoMigrate(&models.ParkedPiece{}, &models.ParkedPieceRef{}, &models.PDPPieceRef{}, &models.MessageWaitsEth{}, &models.PDPProofSet{}, &models.PDPProofSetCreate{}); err != nil {
+	if err := db.AutoMigrate(&models.ParkedPiece{}, &models.ParkedPieceRef{}, &models.PDPPieceRef{}, &models.MessageWaitsEth{}, &models.PDPProofSet{}, &models.PDPProofSetCreate{}, &models.Piece{},
+		&models.PieceRoot{}, &models.PiecePreparation{}, &models.Blob{}, &models.BlobRef{}, &models.BlobEncryption{}, &models.CarRoot{}, &models.CarBlock{}, &models.UploadSession{}, &models.ImportJob{}, &models.GCRun{}, &models.ScrubAlert{},
+		&models.Reservation{}, &models.APIToken{}, &models.Owner{}, &models.IdempotencyRecord{}, &models.Event{},
+		&models.WebhookSubscription{}, &models.WebhookDelivery{}, &models.WebhookDeadLetter{}); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
 
 	log.Printf("Using isolated database at: %s", dbPath)
 
 	// Initialize blob store
-	blobStore, err := myBlobstore.NewFileBlobstore(filepath.Join(dataDir, "blobs"))
+	blobStore, err := newBlobstore(ctx, config, dataDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create blob store: %v", err)
 	}
 
-	// Initialize Piri FsBlobstore for uploads (used by upload service)
-	blobRoot := filepath.Join(dataDir, "blobs")
+	// Optionally keep recently read blobs on fast local disk, with the backend as the cold tier
+	var tieredStore *myBlobstore.TieredBlobstore
+	if tiering := config.Storage.Tiering; tiering.Enabled {
+		hotDir := tiering.HotDir
+		if hotDir == "" {
+			hotDir = filepath.Join(dataDir, "hot")
+		}
+		hotStore, err := myBlobstore.NewFileBlobstore(hotDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create hot tier: %v", err)
+		}
+		tieredStore, err = myBlobstore.NewTieredBlobstore(ctx, hotStore, blobStore,
+			myBlobstore.TierLimits{Capacity: tiering.HotCapacity, HighWatermark: tiering.HotHighWatermark, LowWatermark: tiering.HotLowWatermark},
+			myBlobstore.TierLimits{Capacity: tiering.ColdCapacity, HighWatermark: tiering.ColdHighWatermark},
+			tiering.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create tiered blob store: %v", err)
+		}
+		blobStore = tieredStore
+	}
+
+	// Blobs stored before blobs were reference counted are kept rather than collected, and are
+	// recorded before encryption starts tracking which blobs are encrypted
+	if _, err := myBlobstore.AdoptUntracked(ctx, blobStore, db); err != nil {
+		return nil, fmt.Errorf("failed to adopt existing blobs: %v", err)
+	}
+
+	// Optionally encrypt blobs at rest, outermost so the hot tier is encrypted too
+	var encryptedStore *myBlobstore.EncryptedBlobstore
+	if encryption := config.Storage.Encryption; encryption.Enabled {
+		keys, err := newKeyProvider(encryption, dataDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load encryption keys: %v", err)
+		}
+		encryptedStore, err = myBlobstore.NewEncryptedBlobstore(ctx, blobStore, keys, db, encryption.ReencryptInterval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create encrypted blob store: %v", err)
+		}
+		blobStore = encryptedStore
+	}
+
+	// Pieces, uploads and proof set roots share one content-addressed store with refcounts
+	refStore := myBlobstore.NewRefStore(blobStore, db)
 	blobTmp := filepath.Join(dataDir, "tmp")
-	piriBlobStore, err := blobstore.NewFsBlobstore(blobRoot, blobTmp)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create fs blob store: %v", err)
+
+	// Space is reserved against the blob filesystem; remote backends are bounded by the limit only
+	capacityOpts := capacity.Options{
+		TmpPath:      blobTmp,
+		Limit:        config.Capacity.Limit,
+		MinFree:      config.Capacity.MinFree,
+		DefaultQuota: config.Capacity.DefaultQuota,
+		Quotas:       config.Capacity.Quotas,
+	}
+	if config.Storage.Backend == "file" {
+		capacityOpts.BlobPath = filepath.Join(dataDir, "blobs")
 	}
+	capacityMgr := capacity.NewManager(db, capacityOpts)
+
+	// Tenants only see what they own; data from before tenants existed goes to the default tenant
+	owners := tenant.NewOwnerStore(db)
+	if err := adoptUnowned(ctx, db, owners); err != nil {
+		return nil, err
+	}
+
+	// Lifecycle events are logged for GET /events, where clients resume after the last one they saw
+	eventLog := events.NewLog(db, owners, config.Events.Retention)
 
 	// Wallet setup
 	wm, err := wallet.NewWalletManager(dataDir)
@@ -121,19 +205,171 @@ func initializePDPServer(ctx context.Context, config *config.Config) (*api.PDPSe
 
 	// Wire services with our isolated database but Piri's PDP service
 	piriService := piriServer.GetPDPService()
-	proofSetSvc := proofset.NewProofSetService(piriService, db, common.HexToAddress(addr.Hex())) // Use our isolated DB
-	simpleProofSvc := proofset.NewSimpleProofSetService(db)                                      // Simple proof set service for our isolated DB
+	proofSetSvc := proofset.NewProofSetService(piriService, db, owners, common.HexToAddress(addr.Hex())) // Use our isolated DB
+	simpleProofSvc := proofset.NewSimpleProofSetService(db, owners)                                      // Simple proof set service for our isolated DB
 	adapter := service.NewPiriServiceAdapter(piriService)
-	pieceSvc := piece.NewPieceService(adapter, blobStore, db) // Use our isolated DB
+	pieceSvc := piece.NewPieceService(adapter, refStore, capacityMgr, owners, eventLog, config.Capacity.ReservationTTL, db) // Use our isolated DB
+	uploadSvc := upload.NewUploadService(pieceSvc, refStore, owners, dataDir)
+	carSvc := car.NewCarService(pieceSvc, blobStore, owners, db)
+	gatewayHandler, err := gateway.NewHandler(carSvc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init gateway: %v", err)
+	}
+
+	// Resumable uploads keep partial data in the tmp dir
+	resumableSvc, err := resumable.NewResumableService(pieceSvc, capacityMgr, owners, db, blobTmp, config.Uploads.SessionTTL, config.Uploads.MaxLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init resumable uploads: %v", err)
+	}
+	janitor := resumable.NewJanitor(resumableSvc, config.Uploads.JanitorInterval)
+
+	// Local imports are confined to the configured import roots
+	var importSvc *importer.ImportService
+	if len(config.Imports.Roots) > 0 {
+		importSvc, err = importer.NewImportService(pieceSvc, db, config.Imports.Roots)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init imports: %v", err)
+		}
+	}
+
+	// Garbage collection of unreferenced blobs, abandoned pieces and stale tmp files
+	collector := gc.NewCollector(db, blobStore, refStore, pieceSvc, blobTmp, config.GC.Interval, config.GC.GracePeriod, config.GC.PieceTTL, config.GC.DryRun)
+
+	// Integrity scrubbing recomputes CommP over stored pieces in the background
+	scrubber := scrub.NewScrubber(db, blobStore, eventLog, config.Scrub.Interval, config.Scrub.ReverifyAfter, config.Scrub.BytesPerSecond)
 
 	// Initialize transaction watcher
 	piriDB := piriServer.GetDB() // Get Piri's database for checking transaction status
-	txWatcher := watcher.NewTransactionWatcher(db, piriDB)
+	txWatcher := watcher.NewTransactionWatcher(db, piriDB, eventLog)
+
+	// Scoped API tokens, issued and revoked through the admin API
+	tokenSvc := auth.NewTokenService(db)
+	if config.Server.RequireAuth && config.Server.AdminToken == "" {
+		log.Printf("Warning: authentication is required but no admin token is set, so no API tokens can be issued")
+	}
+	if !config.Server.RequireAuth {
+		log.Printf("Warning: authentication is not required, so anyone can read and upload as the default tenant")
+	}
+	var ucanVerifier *auth.UCANVerifier
+	if config.UCAN.Enabled {
+		ucanVerifier, err = auth.NewUCANVerifier(config.UCAN.ServiceDID, config.UCAN.ServiceKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init UCAN authorization: %v", err)
+		}
+		log.Printf("Accepting UCANs delegated by %s", config.UCAN.ServiceDID)
+	}
+	limiter := ratelimit.NewLimiter(ratelimit.Options{
+		RequestsPerSecond:    config.Limits.RequestsPerSecond,
+		Burst:                config.Limits.Burst,
+		UploadBytesPerSecond: config.Limits.UploadBytesPerSecond,
+		MaxConcurrentUploads: config.Limits.MaxConcurrentUploads,
+		MaxBodySize:          config.Limits.MaxBodySize,
+		MaxUploadSize:        config.Limits.MaxUploadSize,
+		BodySizes:            config.Limits.BodySizes,
+	})
+
+	// Retried mutating requests with an Idempotency-Key get their first response
+	idempotencyStore := idempotency.NewStore(db, config.Idempotency.TTL)
+
+	// Lifecycle events are also delivered to webhook subscriptions, with retries. Subscribers
+	// are only reached at public addresses and in the allowed networks.
+	allowedNetworks := make([]netip.Prefix, 0, len(config.Webhooks.AllowedNetworks))
+	for _, cidr := range config.Webhooks.AllowedNetworks {
+		network, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook allowed network %q: %v", cidr, err)
+		}
+		allowedNetworks = append(allowedNetworks, network)
+	}
+	webhookSvc := webhook.NewWebhookService(db, eventLog, webhook.Options{
+		MaxAttempts:     config.Webhooks.MaxAttempts,
+		InitialBackoff:  config.Webhooks.InitialBackoff,
+		MaxBackoff:      config.Webhooks.MaxBackoff,
+		Timeout:         config.Webhooks.Timeout,
+		Retention:       config.Webhooks.Retention,
+		AllowedNetworks: allowedNetworks,
+	})
 
 	log.Printf("Initialized services with isolated database and transaction watcher")
 
 	// Create PDP server
-	pdpServer := api.NewPDPServer(piriServer, piriBlobStore, dataDir, proofSetSvc, simpleProofSvc, pieceSvc, txWatcher)
+	pdpServer := api.NewPDPServer(api.ServerDeps{
+		PiriServer:        piriServer,
+		UploadSvc:         uploadSvc,
+		ProofSetSvc:       proofSetSvc,
+		SimpleProofSvc:    simpleProofSvc,
+		PieceSvc:          pieceSvc,
+		CarSvc:            carSvc,
+		Gateway:           gatewayHandler,
+		ResumableSvc:      resumableSvc,
+		Janitor:           janitor,
+		ImportSvc:         importSvc,
+		Collector:         collector,
+		Scrubber:          scrubber,
+		TieredStore:       tieredStore,
+		EncryptedStore:    encryptedStore,
+		Capacity:          capacityMgr,
+		TokenSvc:          tokenSvc,
+		UCANVerifier:      ucanVerifier,
+		AdminToken:        config.Server.AdminToken,
+		RequireAuth:       config.Server.RequireAuth,
+		Limiter:           limiter,
+		Idempotency:       idempotencyStore,
+		Events:            eventLog,
+		WebhookSvc:        webhookSvc,
+		ValidateResponses: config.Server.ValidateResponses,
+		TxWatcher:         txWatcher,
+	})
 
 	return pdpServer, nil
 }
+
+// newBlobstore opens the configured blobstore backend
+func newBlobstore(ctx context.Context, config *config.Config, dataDir string) (myBlobstore.Blobstore, error) {
+	switch config.Storage.Backend {
+	case "file":
+		return myBlobstore.NewFileBlobstore(filepath.Join(dataDir, "blobs"))
+	case "s3":
+		s3 := config.Storage.S3
+		s3Store, err := myBlobstore.NewS3Blobstore(ctx, myBlobstore.S3Options{
+			Endpoint:  s3.Endpoint,
+			Region:    s3.Region,
+			Bucket:    s3.Bucket,
+			Prefix:    s3.Prefix,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+			Insecure:  s3.Insecure,
+			PartSize:  s3.PartSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Using S3 blob store at %s/%s", s3.Endpoint, s3.Bucket)
+		return s3Store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", config.Storage.Backend)
+	}
+}
+
+// newKeyProvider loads encryption keys from the key file, or from the local KMS when none is set
+func newKeyProvider(encryption config.EncryptionConfig, dataDir string) (myBlobstore.KeyProvider, error) {
+	if encryption.KeyFile != "" {
+		return myBlobstore.LoadKeyFile(encryption.KeyFile)
+	}
+	kmsDir := encryption.KMSDir
+	if kmsDir == "" {
+		kmsDir = filepath.Join(dataDir, "kms")
+	}
+	return myBlobstore.OpenLocalKMS(kmsDir)
+}
+
+// adoptUnowned assigns proof sets that no tenant owns to the default tenant, so proof sets
+// created before tenants existed stay reachable. Pieces and upload sessions are only recorded
+// since tenants exist and always have an owner.
+func adoptUnowned(ctx context.Context, db *gorm.DB, owners *tenant.OwnerStore) error {
+	var ids []string
+	if err := db.WithContext(ctx).Model(&models.PDPProofSet{}).Pluck("id", &ids).Error; err != nil {
+		return fmt.Errorf("failed to list %s records: %w", tenant.KindProofSet, err)
+	}
+	return owners.Adopt(ctx, tenant.KindProofSet, ids)
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// createImportRequest lists the files and directories to import
+type createImportRequest struct {
+	Paths []string `json:"paths" validate:"required,min=1"`
+}
+
+// triggerGCRequest asks for a garbage collection run that only reports
+type triggerGCRequest struct {
+	DryRun bool `json:"dry_run"`
+}
+
+// handleCreateImport queues an import of local files from the configured import roots
+func (s *PDPServer) handleCreateImport(c echo.Context) error {
+	if s.importSvc == nil {
+		return unavailable("Import service not available")
+	}
+
+	var req createImportRequest
+	if err := c.Bind(&req); err != nil {
+		return invalidRequest("Invalid request body")
+	}
+
+	job, err := s.importSvc.CreateJob(c.Request().Context(), req.Paths)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// handleListImports lists import jobs
+func (s *PDPServer) handleListImports(c echo.Context) error {
+	if s.importSvc == nil {
+		return unavailable("Import service not available")
+	}
+
+	jobs, err := s.importSvc.ListJobs(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"imports": jobs,
+	})
+}
+
+// handleGetImport reports the progress and resulting pieces of an import job
+func (s *PDPServer) handleGetImport(c echo.Context) error {
+	if s.importSvc == nil {
+		return unavailable("Import service not available")
+	}
+
+	job, err := s.importSvc.GetJob(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// handleTriggerGC starts a garbage collection run, optionally as a dry run that only reports
+func (s *PDPServer) handleTriggerGC(c echo.Context) error {
+	if s.collector == nil {
+		return unavailable("Garbage collector not available")
+	}
+
+	var req triggerGCRequest
+	if c.Request().ContentLength > 0 {
+		if err := c.Bind(&req); err != nil {
+			return invalidRequest("Invalid request body")
+		}
+	}
+
+	run, err := s.collector.Trigger(c.Request().Context(), req.DryRun)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusAccepted, run)
+}
+
+// handleListGCRuns lists recent garbage collection runs
+func (s *PDPServer) handleListGCRuns(c echo.Context) error {
+	if s.collector == nil {
+		return unavailable("Garbage collector not available")
+	}
+
+	runs, err := s.collector.ListRuns(c.Request().Context(), 50)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"runs": runs,
+	})
+}
+
+// handleGetGCRun reports the outcome of a garbage collection run, including what it collected
+func (s *PDPServer) handleGetGCRun(c echo.Context) error {
+	if s.collector == nil {
+		return unavailable("Garbage collector not available")
+	}
+
+	run, err := s.collector.GetRun(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, run)
+}
+
+// handleGetScrubStatus reports scrubber progress, corruption totals and recent alerts
+func (s *PDPServer) handleGetScrubStatus(c echo.Context) error {
+	if s.scrubber == nil {
+		return unavailable("Integrity scrubber not available")
+	}
+
+	status, err := s.scrubber.GetStatus(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// handleTriggerScrub starts a scrub pass over due pieces without waiting for the next interval
+func (s *PDPServer) handleTriggerScrub(c echo.Context) error {
+	if s.scrubber == nil {
+		return unavailable("Integrity scrubber not available")
+	}
+
+	if err := s.scrubber.Trigger(); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// handleGetStorageStatus reports how full each storage tier is
+func (s *PDPServer) handleGetStorageStatus(c echo.Context) error {
+	if s.tieredStore == nil {
+		return unavailable("Storage tiering not enabled")
+	}
+
+	return c.JSON(http.StatusOK, s.tieredStore.GetStatus())
+}
+
+// handleGetEncryptionStatus reports the current key and re-encryption progress
+func (s *PDPServer) handleGetEncryptionStatus(c echo.Context) error {
+	if s.encryptedStore == nil {
+		return unavailable("At-rest encryption not enabled")
+	}
+
+	return c.JSON(http.StatusOK, s.encryptedStore.GetStatus())
+}
+
+// handleRotateEncryptionKey makes a new key current and starts re-encrypting existing blobs
+func (s *PDPServer) handleRotateEncryptionKey(c echo.Context) error {
+	if s.encryptedStore == nil {
+		return unavailable("At-rest encryption not enabled")
+	}
+
+	if err := s.encryptedStore.Rotate(); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusAccepted, s.encryptedStore.GetStatus())
+}
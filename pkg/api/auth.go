@@ -0,0 +1,176 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/auth"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"github.com/labstack/echo/v4"
+)
+
+// publicPaths are served without authentication
+var publicPaths = map[string]bool{
+	"/health":       true,
+	"/openapi.json": true,
+}
+
+var (
+	errTokenRequired = apperr.New(apperr.Unauthorized, "TOKEN_REQUIRED", "bearer token required")
+	errAdminRequired = apperr.New(apperr.Unauthorized, "ADMIN_TOKEN_REQUIRED", "admin token required")
+	errAdminDisabled = apperr.New(apperr.Forbidden, "ADMIN_DISABLED", "admin API is disabled")
+	errMissingScope  = apperr.New(apperr.Forbidden, "MISSING_SCOPE", "missing scope")
+)
+
+// anonymousScopes are granted to requests without a token when authentication is not
+// required. Spending from the server wallet, driving proof sets and managing webhooks always
+// need a token.
+var anonymousScopes = []string{auth.ScopeRead, auth.ScopeUpload}
+
+// authenticate resolves the principal a request acts for from its bearer token, which is an
+// API token, a UCAN or the admin token. The tenant always comes from the credential; only the
+// admin token may act for the tenant in the X-Tenant-ID header, with every scope. Without a
+// token the request is refused when authentication is required, and otherwise acts
+// anonymously with anonymousScopes for the default tenant.
+func (s *PDPServer) authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if publicPaths[c.Path()] {
+			return next(c)
+		}
+
+		req := c.Request()
+		ctx := req.Context()
+
+		var principal *auth.Principal
+		token, hasToken := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		switch {
+		case s.bearsAdminToken(req):
+			principal = &auth.Principal{ID: "admin", Tenant: req.Header.Get("X-Tenant-ID"), Scopes: auth.AllScopes, Admin: true}
+		case hasToken && s.ucanVerifier != nil && !auth.IsAPIToken(token):
+			var err error
+			principal, err = s.ucanVerifier.Authenticate(ctx, token)
+			if err != nil {
+				c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				return err
+			}
+		case hasToken && s.tokenSvc != nil:
+			var err error
+			principal, err = s.tokenSvc.Authenticate(ctx, token)
+			if err != nil {
+				if errors.Is(err, auth.ErrInvalidToken) {
+					c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				}
+				return err
+			}
+		case s.requireAuth:
+			c.Response().Header().Set("WWW-Authenticate", "Bearer")
+			return errTokenRequired
+		default:
+			principal = &auth.Principal{ID: "anonymous", Tenant: tenant.Default, Scopes: anonymousScopes}
+		}
+		if principal.Tenant == "" {
+			principal.Tenant = tenant.Default
+		}
+
+		ctx = auth.WithPrincipal(ctx, principal)
+		if principal.Admin {
+			ctx = tenant.WithAllTenants(ctx, principal.Tenant)
+		} else {
+			ctx = tenant.WithTenant(ctx, principal.Tenant)
+		}
+		c.SetRequest(req.WithContext(ctx))
+		return next(c)
+	}
+}
+
+// bearsAdminToken reports whether a request carries the configured admin token
+func (s *PDPServer) bearsAdminToken(req *http.Request) bool {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return ok && s.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+// requireScope restricts a route to principals granted all of the given scopes
+func requireScope(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal := auth.FromContext(c.Request().Context())
+			if principal == nil {
+				return errTokenRequired
+			}
+			for _, scope := range scopes {
+				if !principal.HasScope(scope) {
+					return fmt.Errorf("%w: token lacks the %s scope", errMissingScope, scope)
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// requireAdmin restricts a route to requests bearing the configured admin token
+func (s *PDPServer) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.adminToken == "" {
+			return errAdminDisabled
+		}
+
+		principal := auth.FromContext(c.Request().Context())
+		if principal == nil || !principal.Admin {
+			return errAdminRequired
+		}
+
+		return next(c)
+	}
+}
+
+// handleIssueToken issues a scoped API token; the secret is only ever returned here
+func (s *PDPServer) handleIssueToken(c echo.Context) error {
+	if s.tokenSvc == nil {
+		return unavailable("Token service not available")
+	}
+
+	var req auth.IssueRequest
+	if err := c.Bind(&req); err != nil {
+		return invalidRequest("Invalid request body")
+	}
+
+	token, err := s.tokenSvc.Issue(c.Request().Context(), &req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, token)
+}
+
+// handleListTokens lists issued tokens without their secrets
+func (s *PDPServer) handleListTokens(c echo.Context) error {
+	if s.tokenSvc == nil {
+		return unavailable("Token service not available")
+	}
+
+	tokens, err := s.tokenSvc.List(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tokens": tokens,
+	})
+}
+
+// handleRevokeToken revokes a token; revoking an already revoked token succeeds
+func (s *PDPServer) handleRevokeToken(c echo.Context) error {
+	if s.tokenSvc == nil {
+		return unavailable("Token service not available")
+	}
+
+	if err := s.tokenSvc.Revoke(c.Request().Context(), c.Param("id")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/labstack/echo/v4"
+)
+
+// kindStatus maps each kind of error to the HTTP status it is reported with
+var kindStatus = map[apperr.Kind]int{
+	apperr.Internal:      http.StatusInternalServerError,
+	apperr.Invalid:       http.StatusBadRequest,
+	apperr.Unauthorized:  http.StatusUnauthorized,
+	apperr.Forbidden:     http.StatusForbidden,
+	apperr.NotFound:      http.StatusNotFound,
+	apperr.Conflict:      http.StatusConflict,
+	apperr.InvalidState:  http.StatusConflict,
+	apperr.Gone:          http.StatusGone,
+	apperr.TooLarge:      http.StatusRequestEntityTooLarge,
+	apperr.Unprocessable: http.StatusUnprocessableEntity,
+	apperr.RateLimited:   http.StatusTooManyRequests,
+	apperr.StorageFull:   http.StatusInsufficientStorage,
+	apperr.ChainError:    http.StatusBadGateway,
+	apperr.Unavailable:   http.StatusServiceUnavailable,
+}
+
+// statusCodes are the codes of errors raised by Echo itself, such as unknown routes
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "INVALID_REQUEST",
+	http.StatusUnauthorized:          "UNAUTHORIZED",
+	http.StatusForbidden:             "FORBIDDEN",
+	http.StatusNotFound:              "ROUTE_NOT_FOUND",
+	http.StatusMethodNotAllowed:      "METHOD_NOT_ALLOWED",
+	http.StatusRequestEntityTooLarge: "BODY_TOO_LARGE",
+	http.StatusUnsupportedMediaType:  "UNSUPPORTED_MEDIA_TYPE",
+	http.StatusTooManyRequests:       "RATE_LIMITED",
+	http.StatusServiceUnavailable:    "SERVICE_UNAVAILABLE",
+}
+
+// ErrorBody is the body of every error response
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes an error. Code is stable and meant for programs, Message is meant
+// for people and may change. RequestID identifies the request in the server logs.
+type ErrorDetail struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// handleError reports errors returned by handlers and middleware. Typed errors are reported
+// with the status of their kind and their code; other errors are internal, so they are logged
+// and reported without details.
+func (s *PDPServer) handleError(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	detail := ErrorDetail{
+		Code:      "INTERNAL_ERROR",
+		Message:   "Internal server error",
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	}
+
+	var httpErr *echo.HTTPError
+	var maxBytesErr *http.MaxBytesError
+	if typed := apperr.As(err); typed != nil && typed.Kind != apperr.Internal {
+		status = kindStatus[typed.Kind]
+		detail.Code = typed.Code
+		detail.Message = err.Error()
+	} else if errors.As(err, &httpErr) {
+		status = httpErr.Code
+		detail.Code = statusCodes[status]
+		if detail.Code == "" {
+			detail.Code = "HTTP_ERROR"
+		}
+		detail.Message = fmt.Sprint(httpErr.Message)
+	} else if errors.As(err, &maxBytesErr) {
+		status = http.StatusRequestEntityTooLarge
+		detail.Code = "BODY_TOO_LARGE"
+		detail.Message = fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit)
+	} else {
+		log.Printf("Request %s %s %s failed: %v", detail.RequestID, c.Request().Method, c.Request().URL.Path, err)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = c.JSON(status, ErrorBody{Error: detail})
+	}
+	if err != nil {
+		log.Printf("Failed to send error response for request %s: %v", detail.RequestID, err)
+	}
+}
+
+// invalidRequest reports a malformed request, such as an unparsable body or parameter
+func invalidRequest(message string) error {
+	return apperr.New(apperr.Invalid, "INVALID_REQUEST", message)
+}
+
+// unavailable reports a service that is not configured or not running
+func unavailable(message string) error {
+	return apperr.New(apperr.Unavailable, "SERVICE_UNAVAILABLE", message)
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/events"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	eventBatch        = 100              // Events read from the log at once
+	heartbeatInterval = 15 * time.Second // Idle streams send a comment or ping this often
+	eventWriteTimeout = 10 * time.Second // Clients not reading for this long are dropped
+)
+
+// eventUpgrader upgrades GET /events to a WebSocket; its default origin check refuses pages
+// served from other origins
+var eventUpgrader = websocket.Upgrader{}
+
+// handleEvents streams lifecycle events as Server-Sent Events, or over a WebSocket when the
+// request asks for an upgrade. Streams start with the events after the Last-Event-ID header
+// or last_event_id parameter, or with the next event published when neither is given.
+func (s *PDPServer) handleEvents(c echo.Context) error {
+	if s.events == nil {
+		return unavailable("Event stream not available")
+	}
+
+	filter, err := eventFilter(c)
+	if err != nil {
+		return err
+	}
+	lastID := c.Request().Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.QueryParam("last_event_id")
+	}
+
+	// Subscribe first, so that no event published while the stream starts is missed
+	ctx := c.Request().Context()
+	notify, cancel := s.events.Subscribe()
+	defer cancel()
+
+	var after uint64
+	if lastID != "" {
+		after, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			return invalidRequest("Invalid last event ID")
+		}
+	} else if after, err = s.events.Latest(ctx); err != nil {
+		return err
+	}
+
+	if websocket.IsWebSocketUpgrade(c.Request()) {
+		return s.streamWebSocket(c, notify, after, filter)
+	}
+	return s.streamSSE(c, notify, after, filter)
+}
+
+// eventFilter reads the type, piece_id and proof_set_id query parameters of GET /events
+func eventFilter(c echo.Context) (events.Filter, error) {
+	var filter events.Filter
+	if types := c.QueryParam("type"); types != "" {
+		for _, typ := range strings.Split(types, ",") {
+			known := false
+			for _, t := range events.Types {
+				known = known || t == typ
+			}
+			if !known {
+				return filter, invalidRequest(fmt.Sprintf("Unknown event type %q, expected one of %s", typ, strings.Join(events.Types, ", ")))
+			}
+			filter.Types = append(filter.Types, typ)
+		}
+	}
+	filter.PieceID = strings.ToLower(c.QueryParam("piece_id"))
+	if proofSetID := c.QueryParam("proof_set_id"); proofSetID != "" {
+		id, err := strconv.ParseInt(proofSetID, 10, 64)
+		if err != nil {
+			return filter, invalidRequest("Invalid proof set ID")
+		}
+		filter.ProofSetID = id
+	}
+	return filter, nil
+}
+
+// streamSSE sends events as Server-Sent Events, with their IDs for the client to resume from
+func (s *PDPServer) streamSSE(c echo.Context, notify <-chan struct{}, after uint64, filter events.Filter) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no") // Keep reverse proxies from holding events back
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	send := func(event *events.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	}
+	heartbeat := func() error {
+		if _, err := fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	}
+
+	if err := s.followEvents(c.Request().Context(), notify, after, filter, send, heartbeat); err != nil {
+		log.Printf("Event stream ended: %v", err)
+	}
+	return nil
+}
+
+// streamWebSocket sends events as JSON text messages over a WebSocket. Clients send nothing;
+// they resume after a dropped connection by passing the last ID they saw as last_event_id.
+func (s *PDPServer) streamWebSocket(c echo.Context, notify <-chan struct{}, after uint64, filter events.Filter) error {
+	conn, err := eventUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// The upgrader already replied with an error
+		return nil
+	}
+	defer conn.Close()
+
+	// Reading notices the client closing the connection
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event *events.Event) error {
+		conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		return conn.WriteJSON(event)
+	}
+	heartbeat := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteTimeout))
+	}
+
+	err = s.followEvents(ctx, notify, after, filter, send, heartbeat)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Event stream ended: %v", err)
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(eventWriteTimeout))
+	return nil
+}
+
+// followEvents sends the events after an ID, then each event as it is published, until the
+// context ends or sending fails. Idle streams get a heartbeat.
+func (s *PDPServer) followEvents(ctx context.Context, notify <-chan struct{}, after uint64, filter events.Filter, send func(*events.Event) error, heartbeat func() error) error {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			batch, err := s.events.Since(ctx, after, filter, eventBatch)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			for _, event := range batch {
+				if err := send(event); err != nil {
+					return err
+				}
+				after = event.ID
+			}
+			if len(batch) < eventBatch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notify:
+		case <-ticker.C:
+			if err := heartbeat(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/auth"
+	"github.com/Datazen-Protocol/pdp-server/pkg/idempotency"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"github.com/labstack/echo/v4"
+)
+
+// maxRememberedResponse bounds the response bodies remembered for idempotency keys; requests
+// with larger responses run again when retried
+const maxRememberedResponse = 1 << 20
+
+// idempotentMethods are the methods of the routes that honour Idempotency-Key
+var idempotentMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// uploadFingerprintHeaders identify the body of an upload, which is not read in advance
+var uploadFingerprintHeaders = []string{echo.HeaderContentType, "Upload-Offset", "Upload-Length"}
+
+// transactionRoutes send a transaction, which may have gone out even when they fail, so their
+// keys are kept rather than freed to run them again
+var transactionRoutes = map[string]bool{
+	"POST /proofsets": true,
+	"POST /pieces/:pieceID/proofset/:proofSetID": true,
+}
+
+// unrememberedHeaders are set for each response by other middleware, so replays carry their own
+var unrememberedHeaders = map[string]bool{
+	echo.HeaderXRequestID:      true,
+	echo.HeaderContentLength:   true,
+	"X-Ratelimit-Limit":        true,
+	"X-Ratelimit-Remaining":    true,
+	"X-Ratelimit-Reset":        true,
+	echo.HeaderRetryAfter:      true,
+	echo.HeaderVary:            true,
+	echo.HeaderWWWAuthenticate: true,
+}
+
+// idempotent runs mutating requests sent with an Idempotency-Key once per key. Retries of a
+// request get the response of its first run with an Idempotent-Replayed header; a key sent
+// with another request fails. Handlers run to completion when the client goes away. Server
+// errors are not remembered, so that retrying them runs the request again, except on routes
+// that send a transaction.
+func (s *PDPServer) idempotent(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		key := req.Header.Get("Idempotency-Key")
+		if s.idempotency == nil || key == "" || !idempotentMethods[req.Method] {
+			return next(c)
+		}
+
+		ctx := req.Context()
+		// Keys and their requests outlive clients that went away
+		detached := context.WithoutCancel(ctx)
+		sendsTransaction := transactionRoutes[req.Method+" "+c.Path()]
+		client := "anonymous"
+		if principal := auth.FromContext(ctx); principal != nil {
+			client = principal.ID
+		}
+		scope := client + "/" + tenant.FromContext(ctx)
+
+		fingerprint, err := requestFingerprint(c)
+		if err != nil {
+			return err
+		}
+		remembered, err := s.idempotency.Begin(ctx, scope, key, req.Method, req.URL.Path, fingerprint)
+		if errors.Is(err, idempotency.ErrKeyInUse) {
+			c.Response().Header().Set(echo.HeaderRetryAfter, "1")
+		}
+		if err != nil {
+			return err
+		}
+		if remembered != nil {
+			return replay(c, remembered)
+		}
+
+		c.SetRequest(req.WithContext(detached))
+		recorder := &responseRecorder{ResponseWriter: c.Response().Writer, limit: maxRememberedResponse}
+		c.Response().Writer = recorder
+		completed := false
+		defer func() {
+			// Free the key if the handler panicked, or the response is not remembered, unless a
+			// transaction may have been sent
+			if !completed && !sendsTransaction {
+				if err := s.idempotency.Release(detached, scope, key); err != nil {
+					log.Printf("Failed to release idempotency key: %v", err)
+				}
+			}
+		}()
+
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+
+		status := c.Response().Status
+		if status == http.StatusTooManyRequests {
+			// Refused before it ran, so nothing was sent
+			sendsTransaction = false
+			return nil
+		}
+		if status >= http.StatusInternalServerError && !sendsTransaction {
+			return nil
+		}
+		if recorder.truncated {
+			if sendsTransaction {
+				log.Printf("Warning: response to %s %s is too large to remember; its idempotency key stays in use", req.Method, req.URL.Path)
+			}
+			return nil
+		}
+		header := make(http.Header)
+		for name, values := range c.Response().Header() {
+			if !unrememberedHeaders[name] {
+				header[name] = values
+			}
+		}
+		response := &idempotency.Response{Status: status, Header: header, Body: recorder.body.Bytes()}
+		if err := s.idempotency.Complete(detached, scope, key, response); err != nil {
+			log.Printf("Failed to remember response for idempotency key: %v", err)
+			return nil
+		}
+		completed = true
+		return nil
+	}
+}
+
+// requestFingerprint identifies a request by its method, path, query and body. The bodies of
+// uploads are streamed rather than read in advance, so they are identified by their headers.
+func requestFingerprint(c echo.Context) (string, error) {
+	req := c.Request()
+	h := sha256.New()
+	io.WriteString(h, req.Method+"\n"+req.URL.Path+"\n"+req.URL.RawQuery+"\n")
+
+	if uploadRoutes[req.Method+" "+c.Path()] {
+		for _, name := range uploadFingerprintHeaders {
+			io.WriteString(h, name+": "+req.Header.Get(name)+"\n")
+		}
+		io.WriteString(h, "Length: "+strconv.FormatInt(req.ContentLength, 10)+"\n")
+	} else if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return "", err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replay sends a remembered response again
+func replay(c echo.Context, response *idempotency.Response) error {
+	header := c.Response().Header()
+	for name, values := range response.Header {
+		header[name] = values
+	}
+	header.Set("Idempotent-Replayed", "true")
+	c.Response().WriteHeader(response.Status)
+	if len(response.Body) == 0 || c.Request().Method == http.MethodHead {
+		return nil
+	}
+	_, err := c.Response().Write(response.Body)
+	return err
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/auth"
+	"github.com/labstack/echo/v4"
+)
+
+// uploadRoutes carry piece data: they take the upload size limit, are throttled to the upload
+// bandwidth and count against concurrent uploads
+var uploadRoutes = map[string]bool{
+	"POST /upload":                      true,
+	"PUT /pieces":                       true,
+	"PUT /pieces/:pieceID":              true,
+	"PATCH /uploads/:uploadID":          true,
+	"POST /car":                         true,
+	"PUT /pdp/piece/upload/:uploadUUID": true,
+}
+
+var (
+	errRateLimited    = apperr.New(apperr.RateLimited, "RATE_LIMITED", "rate limit exceeded")
+	errTooManyUploads = apperr.New(apperr.RateLimited, "TOO_MANY_UPLOADS", "too many concurrent uploads")
+	errBodyTooLarge   = apperr.New(apperr.TooLarge, "BODY_TOO_LARGE", "request body too large")
+)
+
+// IPExtractor returns how client IP addresses are found. Without trusted proxies it is the
+// address of the connection, so clients cannot pick the address they are limited by. Requests
+// from a trusted proxy, given as CIDRs, are attributed to the address it forwarded them for.
+func IPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, cidr := range trustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("bad trusted proxy %q: %w", cidr, err)
+		}
+		options = append(options, echo.TrustIPRange(network))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+// throttle limits the request rate per IP address before requests are authenticated, so
+// requests with invalid tokens are throttled too. The admin token is not limited.
+func (s *PDPServer) throttle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.limiter == nil || publicPaths[c.Path()] || s.bearsAdminToken(c.Request()) {
+			return next(c)
+		}
+		if err := s.allow(c, "ip:"+c.RealIP()); err != nil {
+			return err
+		}
+		return next(c)
+	}
+}
+
+// limit enforces per-client request rates, body sizes, upload bandwidth and concurrent
+// uploads. Clients are told their limits in X-RateLimit-* headers, and refused requests get
+// 429 with Retry-After. Requests with an API token or UCAN are limited per token, on top of
+// the per IP address limit every request passed in throttle; the admin token is not limited.
+func (s *PDPServer) limit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.limiter == nil || publicPaths[c.Path()] {
+			return next(c)
+		}
+		req := c.Request()
+		principal := auth.FromContext(req.Context())
+		if principal != nil && principal.Admin {
+			return next(c)
+		}
+		key := "ip:" + c.RealIP()
+		if principal != nil && principal.ID != "anonymous" {
+			key = "client:" + principal.ID
+			if err := s.allow(c, key); err != nil {
+				return err
+			}
+		}
+
+		route := req.Method + " " + c.Path()
+		upload := uploadRoutes[route]
+		if max := s.limiter.BodyLimit(route, upload); max > 0 {
+			if req.ContentLength > max {
+				return fmt.Errorf("%w: request body exceeds %d bytes", errBodyTooLarge, max)
+			}
+			req.Body = http.MaxBytesReader(c.Response(), req.Body, max)
+		}
+		if !upload {
+			return next(c)
+		}
+
+		release, ok := s.limiter.AcquireUpload(key)
+		if !ok {
+			c.Response().Header().Set("Retry-After", "1")
+			return errTooManyUploads
+		}
+		defer release()
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{s.limiter.Reader(req.Context(), key, req.Body), req.Body}
+		return next(c)
+	}
+}
+
+// allow counts a request against a client's rate limit, telling the client its limit
+func (s *PDPServer) allow(c echo.Context, key string) error {
+	decision := s.limiter.Allow(key)
+	if decision.Limit > 0 {
+		header := c.Response().Header()
+		header.Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		header.Set("X-RateLimit-Reset", strconv.Itoa(seconds(decision.Reset)))
+	}
+	if !decision.Allowed {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(seconds(decision.RetryAfter)))
+		return errRateLimited
+	}
+	return nil
+}
+
+// seconds rounds a duration up to whole seconds, as rate limit headers carry
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
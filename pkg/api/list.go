@@ -0,0 +1,53 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/listing"
+	"github.com/labstack/echo/v4"
+)
+
+// listQuery reads the paging, sorting and filtering query parameters of a list route
+func listQuery(c echo.Context) (listing.Query, error) {
+	q := listing.Query{
+		Cursor: c.QueryParam("cursor"),
+		Sort:   c.QueryParam("sort"),
+		Status: c.QueryParam("status"),
+		Tenant: c.QueryParam("tenant"),
+	}
+
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, invalidRequest("Invalid limit")
+		}
+		q.Limit = n
+	}
+	if proofSetID := c.QueryParam("proof_set_id"); proofSetID != "" {
+		id, err := strconv.ParseInt(proofSetID, 10, 64)
+		if err != nil {
+			return q, invalidRequest("Invalid proof set ID")
+		}
+		q.ProofSetID = id
+	}
+	if createdAfter := c.QueryParam("created_after"); createdAfter != "" {
+		t, err := time.Parse(time.RFC3339, createdAfter)
+		if err != nil {
+			return q, invalidRequest("Invalid created_after, expected an RFC 3339 time")
+		}
+		q.CreatedAfter = t
+	}
+
+	return q, nil
+}
+
+// listResponse is the body of a list route: a page of items under key, and the cursor of the
+// next page unless this is the last one
+func listResponse(key string, items interface{}, next string) map[string]interface{} {
+	body := map[string]interface{}{key: items}
+	if next != "" {
+		body["next_cursor"] = next
+	}
+	return body
+}
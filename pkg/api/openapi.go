@@ -0,0 +1,666 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/auth"
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/capacity"
+	"github.com/Datazen-Protocol/pdp-server/pkg/car"
+	"github.com/Datazen-Protocol/pdp-server/pkg/gc"
+	"github.com/Datazen-Protocol/pdp-server/pkg/idempotency"
+	"github.com/Datazen-Protocol/pdp-server/pkg/importer"
+	"github.com/Datazen-Protocol/pdp-server/pkg/listing"
+	"github.com/Datazen-Protocol/pdp-server/pkg/piece"
+	"github.com/Datazen-Protocol/pdp-server/pkg/proofset"
+	"github.com/Datazen-Protocol/pdp-server/pkg/resumable"
+	"github.com/Datazen-Protocol/pdp-server/pkg/scrub"
+	"github.com/Datazen-Protocol/pdp-server/pkg/upload"
+	"github.com/Datazen-Protocol/pdp-server/pkg/webhook"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3gen"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/labstack/echo/v4"
+)
+
+// operation documents a route. Request and response bodies are given as values of the Go types
+// the handlers bind and return, and their schemas are generated from those types.
+type operation struct {
+	summary   string
+	params    []*openapi3.Parameter // Header and query parameters; path parameters are derived from the route
+	ints      []string              // Path parameters that are integers
+	body      interface{}           // JSON request body
+	optional  bool                  // The JSON request body may be omitted
+	upload    []string              // Content types of a raw data request body
+	responses map[int]interface{}   // Response bodies by status; nil for none
+	public    bool                  // Served without authentication
+}
+
+// fields describes a JSON object the handler builds as a map. Every field is required but
+// those whose example values are nil pointers.
+type fields map[string]interface{}
+
+// binary describes a response body of raw data in the given content types
+type binary []string
+
+var (
+	pieceUploadTypes = []string{"multipart/form-data", "application/octet-stream"}
+	transactionInfo  = fields{
+		"piece_id":              "",
+		"status":                "",
+		"transaction_hash":      "",
+		"transaction_timestamp": time.Time{},
+		"error_message":         "",
+	}
+	nextCursor = (*string)(nil)
+
+	// Filters of list routes
+	createdAfter   = query("created_after", openapi3.NewDateTimeSchema())
+	tenantFilter   = query("tenant", openapi3.NewStringSchema())
+	proofSetFilter = query("proof_set_id", integer())
+)
+
+// operations documents every route registered by RegisterRoutes, keyed by method and route path
+var operations = map[string]operation{
+	"GET /health": {
+		summary:   "Report that the server is up",
+		responses: map[int]interface{}{200: fields{"status": ""}},
+		public:    true,
+	},
+	"GET /openapi.json": {
+		summary:   "Get this OpenAPI document",
+		responses: map[int]interface{}{200: map[string]interface{}{}},
+		public:    true,
+	},
+	"GET /status": {
+		summary: "Report server status, capacity and the tenant's usage",
+		responses: map[int]interface{}{200: fields{
+			"status":   "",
+			"service":  "",
+			"capacity": (*capacity.Status)(nil),
+			"tenant":   (*capacity.TenantUsage)(nil),
+		}},
+	},
+
+	"POST /upload": {
+		summary:   "Upload a file as a piece",
+		upload:    []string{"multipart/form-data"},
+		responses: map[int]interface{}{200: upload.UploadResult{}},
+	},
+	"GET /files": {
+		summary:   "List uploaded files",
+		params:    listParams(upload.Listing, createdAfter, tenantFilter),
+		responses: map[int]interface{}{200: fields{"files": []*upload.UploadResult{}, "next_cursor": nextCursor}},
+	},
+	"DELETE /files/:cid": {
+		summary:   "Forget an uploaded file",
+		responses: map[int]interface{}{204: nil},
+	},
+
+	"POST /proofsets": {
+		summary:   "Create a proof set",
+		body:      proofset.CreateProofSetRequest{},
+		responses: map[int]interface{}{201: proofset.ProofSetResponse{}},
+	},
+	"GET /proofsets": {
+		summary:   "List proof sets",
+		params:    listParams(proofset.Listing, statusFilter("pending", "created"), createdAfter, tenantFilter),
+		responses: map[int]interface{}{200: fields{"proof_sets": []*proofset.ProofSetInfo{}, "next_cursor": nextCursor}},
+	},
+	"GET /proofsets/:id": {
+		summary:   "Get a proof set",
+		ints:      []string{"id"},
+		responses: map[int]interface{}{200: proofset.ProofSetResponse{}},
+	},
+	"POST /proofsets/:id/roots": {
+		summary:   "Add roots to a proof set",
+		ints:      []string{"id"},
+		body:      []proofset.AddRootRequest{},
+		responses: map[int]interface{}{200: fields{"message": ""}},
+	},
+	"GET /proofsets/:id/roots": {
+		summary:   "List the roots of a proof set",
+		ints:      []string{"id"},
+		params:    listParams(proofset.RootListing, statusFilter("pending", "confirmed", "failed")),
+		responses: map[int]interface{}{200: fields{"roots": []map[string]interface{}{}, "next_cursor": nextCursor}},
+	},
+	"GET /proofsets/:id/status": {
+		summary:   "Report the status of a proof set",
+		ints:      []string{"id"},
+		responses: map[int]interface{}{200: map[string]interface{}{}},
+	},
+	"POST /proofsets/:id/prove": {
+		summary:   "Trigger proving of a proof set",
+		ints:      []string{"id"},
+		responses: map[int]interface{}{200: fields{"proofSetID": int64(0), "status": "", "message": ""}},
+	},
+	"GET /proofsets/:id/prove/status": {
+		summary:   "Report the proving status of a proof set",
+		ints:      []string{"id"},
+		responses: map[int]interface{}{200: fields{"proofSetID": int64(0), "status": "", "lastProof": "", "nextProof": ""}},
+	},
+
+	"POST /pieces": {
+		summary:   "Prepare a piece for upload",
+		body:      piece.PrepareRequest{},
+		responses: map[int]interface{}{200: piece.PieceInfo{}, 201: piece.PieceInfo{}},
+	},
+	"GET /pieces": {
+		summary: "List pieces",
+		params: listParams(piece.Listing,
+			statusFilter("prepared", "uploaded", "pending_confirmation", "added_to_proofset", "transaction_failed", "error"),
+			proofSetFilter, createdAfter, tenantFilter),
+		responses: map[int]interface{}{200: fields{"pieces": []*piece.PieceInfo{}, "next_cursor": nextCursor}},
+	},
+	"PUT /pieces": {
+		summary:   "Upload piece data",
+		upload:    pieceUploadTypes,
+		responses: map[int]interface{}{200: piece.PieceInfo{}},
+	},
+	"PUT /pieces/:pieceID": {
+		summary:   "Upload the data of a prepared piece",
+		upload:    pieceUploadTypes,
+		responses: map[int]interface{}{200: piece.PieceInfo{}},
+	},
+	"GET /pieces/:pieceID": {
+		summary:   "Get a piece",
+		responses: map[int]interface{}{200: piece.PieceInfo{}},
+	},
+	"DELETE /pieces/:pieceID": {
+		summary:   "Delete a piece",
+		responses: map[int]interface{}{204: nil},
+	},
+	"POST /pieces/:pieceID/proofset/:proofSetID": {
+		summary:   "Add a piece to a proof set",
+		ints:      []string{"proofSetID"},
+		responses: map[int]interface{}{200: fields{"message": ""}},
+	},
+	"GET /pieces/:pieceID/transaction/status": {
+		summary:   "Report the proof set transaction of a piece",
+		responses: map[int]interface{}{200: transactionInfo},
+	},
+	"POST /pieces/:pieceID/transaction/monitor": {
+		summary:   "Check the proof set transaction of a piece now",
+		responses: map[int]interface{}{200: transactionInfo},
+	},
+	"GET /events": {
+		summary: "Stream piece and proof set lifecycle events, or upgrade to a WebSocket carrying them",
+		params: []*openapi3.Parameter{
+			header("Last-Event-ID", false, integer().WithMin(0)),
+			query("last_event_id", integer().WithMin(0)),
+			query("type", openapi3.NewStringSchema()),
+			query("piece_id", openapi3.NewStringSchema()),
+			proofSetFilter,
+		},
+		responses: map[int]interface{}{200: binary{"text/event-stream"}, 101: nil},
+	},
+	"POST /webhooks": {
+		summary:   "Subscribe a URL to lifecycle events",
+		body:      webhook.SubscriptionRequest{},
+		responses: map[int]interface{}{201: webhook.SubscriptionInfo{}},
+	},
+	"GET /webhooks": {
+		summary:   "List webhook subscriptions",
+		responses: map[int]interface{}{200: fields{"webhooks": []*webhook.SubscriptionInfo{}}},
+	},
+	"GET /webhooks/:id": {
+		summary:   "Get a webhook subscription",
+		responses: map[int]interface{}{200: webhook.SubscriptionInfo{}},
+	},
+	"PATCH /webhooks/:id": {
+		summary:   "Change a webhook subscription",
+		body:      webhook.UpdateRequest{},
+		responses: map[int]interface{}{200: webhook.SubscriptionInfo{}},
+	},
+	"DELETE /webhooks/:id": {
+		summary:   "Delete a webhook subscription and its delivery log",
+		responses: map[int]interface{}{204: nil},
+	},
+	"GET /webhooks/:id/deliveries": {
+		summary:   "List the delivery log of a webhook subscription",
+		params:    listParams(webhook.DeliveryListing, statusFilter("pending", "delivered", "dead"), createdAfter),
+		responses: map[int]interface{}{200: fields{"deliveries": []*webhook.DeliveryInfo{}, "next_cursor": nextCursor}},
+	},
+	"PUT /pdp/piece/upload/:uploadUUID": {
+		summary:   "Upload piece data to Piri",
+		upload:    []string{"application/octet-stream"},
+		responses: map[int]interface{}{204: nil},
+	},
+
+	"POST /uploads": {
+		summary:   "Create a resumable upload session",
+		params:    []*openapi3.Parameter{header("Upload-Length", false, integer())},
+		body:      createUploadRequest{},
+		optional:  true,
+		responses: map[int]interface{}{201: resumable.SessionInfo{}},
+	},
+	"HEAD /uploads/:uploadID": {
+		summary:   "Report the progress of a resumable upload",
+		responses: map[int]interface{}{200: nil},
+	},
+	"PATCH /uploads/:uploadID": {
+		summary:   "Append a chunk to a resumable upload",
+		params:    []*openapi3.Parameter{header("Upload-Offset", true, integer())},
+		upload:    []string{"application/offset+octet-stream", "application/octet-stream"},
+		responses: map[int]interface{}{204: nil},
+	},
+	"POST /uploads/:uploadID/finalize": {
+		summary:   "Turn a completed upload into a piece",
+		responses: map[int]interface{}{200: piece.PieceInfo{}},
+	},
+
+	"POST /car": {
+		summary:   "Ingest a CAR as a piece",
+		upload:    pieceUploadTypes,
+		responses: map[int]interface{}{201: car.CarInfo{}},
+	},
+	"GET /car/:pieceCID": {
+		summary:   "Get an ingested CAR",
+		responses: map[int]interface{}{200: car.CarInfo{}},
+	},
+	"GET /ipfs/*": {
+		summary:   "Get blocks of ingested CARs from the trustless gateway",
+		responses: map[int]interface{}{200: binary{"application/vnd.ipld.raw", "application/vnd.ipld.car"}},
+	},
+	"HEAD /ipfs/*": {
+		summary:   "Check for blocks of ingested CARs in the trustless gateway",
+		responses: map[int]interface{}{200: nil},
+	},
+
+	"POST /admin/tokens": {
+		summary:   "Issue an API token",
+		body:      auth.IssueRequest{},
+		responses: map[int]interface{}{201: auth.TokenInfo{}},
+	},
+	"GET /admin/tokens": {
+		summary:   "List API tokens",
+		responses: map[int]interface{}{200: fields{"tokens": []*auth.TokenInfo{}}},
+	},
+	"DELETE /admin/tokens/:id": {
+		summary:   "Revoke an API token",
+		responses: map[int]interface{}{204: nil},
+	},
+	"POST /admin/imports": {
+		summary:   "Queue an import of local files",
+		body:      createImportRequest{},
+		responses: map[int]interface{}{202: importer.JobInfo{}},
+	},
+	"GET /admin/imports": {
+		summary:   "List import jobs",
+		responses: map[int]interface{}{200: fields{"imports": []*importer.JobInfo{}}},
+	},
+	"GET /admin/imports/:id": {
+		summary:   "Get an import job",
+		responses: map[int]interface{}{200: importer.JobInfo{}},
+	},
+	"POST /admin/gc": {
+		summary:   "Start a garbage collection run",
+		body:      triggerGCRequest{},
+		optional:  true,
+		responses: map[int]interface{}{202: gc.RunInfo{}},
+	},
+	"GET /admin/gc": {
+		summary:   "List garbage collection runs",
+		responses: map[int]interface{}{200: fields{"runs": []*gc.RunInfo{}}},
+	},
+	"GET /admin/gc/:id": {
+		summary:   "Get a garbage collection run",
+		responses: map[int]interface{}{200: gc.RunInfo{}},
+	},
+	"GET /admin/scrub": {
+		summary:   "Report integrity scrubber progress",
+		responses: map[int]interface{}{200: scrub.Status{}},
+	},
+	"POST /admin/scrub": {
+		summary:   "Start a scrub pass",
+		responses: map[int]interface{}{202: nil},
+	},
+	"GET /admin/storage": {
+		summary:   "Report storage tier usage",
+		responses: map[int]interface{}{200: blobstore.TierStatus{}},
+	},
+	"GET /admin/encryption": {
+		summary:   "Report at-rest encryption",
+		responses: map[int]interface{}{200: blobstore.EncryptionStatus{}},
+	},
+	"POST /admin/encryption/rotate": {
+		summary:   "Rotate the at-rest encryption key",
+		responses: map[int]interface{}{202: blobstore.EncryptionStatus{}},
+	},
+}
+
+// apiSpec is the OpenAPI document of the registered routes, with the route of each operation
+// for validating requests and responses against it
+type apiSpec struct {
+	doc    *openapi3.T
+	json   []byte
+	routes map[string]*routers.Route // Keyed by method and Echo route path
+}
+
+// buildSpec generates the OpenAPI document for the registered routes. It fails when a route
+// is not documented in operations, or an operation has no route, so the two cannot drift apart.
+func buildSpec(registered []*echo.Route) (*apiSpec, error) {
+	doc := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:       "PDP Server API",
+			Description: "Stores pieces and manages proof sets for Proof of Data Possession. See docs/API.md.",
+			Version:     "1.0.0",
+		},
+		Paths: openapi3.NewPaths(),
+		Components: &openapi3.Components{
+			Schemas: openapi3.Schemas{},
+			SecuritySchemes: openapi3.SecuritySchemes{
+				"bearer": &openapi3.SecuritySchemeRef{Value: openapi3.NewJWTSecurityScheme().
+					WithBearerFormat("API token, UCAN or admin token")},
+			},
+		},
+		Security: openapi3.SecurityRequirements{{"bearer": []string{}}},
+	}
+
+	errorSchema, err := generateSchema(ErrorBody{}, false)
+	if err != nil {
+		return nil, err
+	}
+	doc.Components.Schemas["Error"] = errorSchema
+	errorRef := openapi3.NewSchemaRef("#/components/schemas/Error", errorSchema.Value)
+
+	spec := &apiSpec{doc: doc, routes: make(map[string]*routers.Route)}
+	var missing []string
+	for _, r := range registered {
+		if r.Method == echo.RouteNotFound {
+			continue
+		}
+		key := r.Method + " " + r.Path
+		if _, ok := spec.routes[key]; ok {
+			continue
+		}
+		op, ok := operations[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+
+		path := specPath(r.Path)
+		item := doc.Paths.Value(path)
+		if item == nil {
+			item = &openapi3.PathItem{}
+			doc.Paths.Set(path, item)
+		}
+		specOp, err := op.build(r.Path, errorRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to document %s: %w", key, err)
+		}
+		if idempotentMethods[r.Method] {
+			specOp.AddParameter(header("Idempotency-Key", false,
+				openapi3.NewStringSchema().WithMaxLength(idempotency.MaxKeyLength)))
+		}
+		item.SetOperation(r.Method, specOp)
+		spec.routes[key] = &routers.Route{
+			Spec:      doc,
+			Path:      path,
+			PathItem:  item,
+			Method:    r.Method,
+			Operation: specOp,
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("routes missing from the API spec: %s", strings.Join(missing, ", "))
+	}
+
+	var stale []string
+	for key := range operations {
+		if _, ok := spec.routes[key]; !ok {
+			stale = append(stale, key)
+		}
+	}
+	if len(stale) > 0 {
+		sort.Strings(stale)
+		return nil, fmt.Errorf("API spec documents unregistered routes: %s", strings.Join(stale, ", "))
+	}
+
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid API spec: %w", err)
+	}
+	spec.json, err = json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode API spec: %w", err)
+	}
+	return spec, nil
+}
+
+// build generates the OpenAPI operation for the route at the given path. Errors are described
+// by errorRef.
+func (op operation) build(routePath string, errorRef *openapi3.SchemaRef) (*openapi3.Operation, error) {
+	specOp := openapi3.NewOperation()
+	specOp.Summary = op.summary
+	if op.public {
+		specOp.Security = &openapi3.SecurityRequirements{}
+	}
+
+	for _, segment := range strings.Split(routePath, "/") {
+		name, ok := pathParam(segment)
+		if !ok {
+			continue
+		}
+		schema := openapi3.NewStringSchema()
+		for _, n := range op.ints {
+			if n == name {
+				schema = integer()
+			}
+		}
+		specOp.AddParameter(openapi3.NewPathParameter(name).WithSchema(schema))
+	}
+	for _, p := range op.params {
+		specOp.AddParameter(p)
+	}
+
+	switch {
+	case op.body != nil:
+		schema, err := generateSchema(op.body, true)
+		if err != nil {
+			return nil, err
+		}
+		body := openapi3.NewRequestBody().WithJSONSchemaRef(schema).WithRequired(!op.optional)
+		specOp.RequestBody = &openapi3.RequestBodyRef{Value: body}
+	case op.upload != nil:
+		content := openapi3.Content{}
+		for _, contentType := range op.upload {
+			schema := openapi3.NewStringSchema().WithFormat("binary")
+			if contentType == "multipart/form-data" {
+				schema = openapi3.NewObjectSchema().
+					WithProperty("file", openapi3.NewStringSchema().WithFormat("binary"))
+				schema.Required = []string{"file"}
+			}
+			content[contentType] = openapi3.NewMediaType().WithSchema(schema)
+		}
+		body := openapi3.NewRequestBody().WithContent(content).WithRequired(true)
+		specOp.RequestBody = &openapi3.RequestBodyRef{Value: body}
+	}
+
+	for status, value := range op.responses {
+		response := openapi3.NewResponse().WithDescription(http.StatusText(status))
+		switch value := value.(type) {
+		case nil:
+		case binary:
+			content := openapi3.Content{}
+			for _, contentType := range value {
+				content[contentType] = openapi3.NewMediaType().
+					WithSchema(openapi3.NewStringSchema().WithFormat("binary"))
+			}
+			response.WithContent(content)
+		default:
+			schema, err := generateSchema(value, false)
+			if err != nil {
+				return nil, err
+			}
+			response.WithContent(openapi3.NewContentWithJSONSchemaRef(schema))
+		}
+		specOp.AddResponse(status, response)
+	}
+	specOp.Responses.Set("default", &openapi3.ResponseRef{Value: openapi3.NewResponse().
+		WithDescription("Error").
+		WithContent(openapi3.NewContentWithJSONSchemaRef(errorRef))})
+	return specOp, nil
+}
+
+// generateSchema generates the schema of a Go value as encoding/json encodes it. Fields of
+// requests are required when tagged `validate:"required"`; fields of responses are required
+// unless tagged omitempty. Responses may carry null for nil slices and maps.
+func generateSchema(value interface{}, request bool) (*openapi3.SchemaRef, error) {
+	if f, ok := value.(fields); ok {
+		schema := openapi3.NewObjectSchema()
+		names := make([]string, 0, len(f))
+		for name := range f {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ref, err := generateSchema(f[name], request)
+			if err != nil {
+				return nil, err
+			}
+			schema.WithPropertyRef(name, ref)
+			if v := reflect.ValueOf(f[name]); v.Kind() != reflect.Ptr || !v.IsNil() {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		return schema.NewRef(), nil
+	}
+
+	customize := func(name string, t reflect.Type, tag reflect.StructTag, schema *openapi3.Schema) error {
+		if !request && (t.Kind() == reflect.Slice || t.Kind() == reflect.Map) {
+			schema.Nullable = true
+		}
+		if request {
+			applyValidateTag(tag.Get("validate"), schema)
+		}
+		if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+			return nil
+		}
+		for _, field := range reflect.VisibleFields(t) {
+			jsonName, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if jsonName == "" || jsonName == "-" || !field.IsExported() {
+				continue
+			}
+			required := !strings.Contains(options, "omitempty")
+			if request {
+				required = hasRule(field.Tag.Get("validate"), "required")
+			}
+			if required {
+				schema.Required = append(schema.Required, jsonName)
+			}
+		}
+		return nil
+	}
+	return openapi3gen.NewSchemaRefForValue(value, nil, openapi3gen.SchemaCustomizer(customize))
+}
+
+// applyValidateTag carries the min rule of a validate tag over to a request schema
+func applyValidateTag(tag string, schema *openapi3.Schema) {
+	for _, rule := range strings.Split(tag, ",") {
+		arg, ok := strings.CutPrefix(rule, "min=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case schema.Type.Is("array"):
+			schema.MinItems = n
+		case schema.Type.Is("string"):
+			schema.MinLength = n
+		case schema.Type.Is("integer"), schema.Type.Is("number"):
+			min := float64(n)
+			schema.Min = &min
+		}
+	}
+}
+
+// hasRule reports whether a validate tag contains a rule
+func hasRule(tag, rule string) bool {
+	for _, r := range strings.Split(tag, ",") {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// specPath converts an Echo route path to an OpenAPI path, e.g. /pieces/:pieceID to
+// /pieces/{pieceID}. Wildcards become a {path} parameter.
+func specPath(routePath string) string {
+	segments := strings.Split(routePath, "/")
+	for i, segment := range segments {
+		if name, ok := pathParam(segment); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// pathParam returns the name of the path parameter a route path segment declares, if any
+func pathParam(segment string) (string, bool) {
+	if segment == "*" {
+		return "path", true
+	}
+	return strings.CutPrefix(segment, ":")
+}
+
+// header documents a request header
+func header(name string, required bool, schema *openapi3.Schema) *openapi3.Parameter {
+	return openapi3.NewHeaderParameter(name).WithRequired(required).WithSchema(schema)
+}
+
+// query documents an optional query parameter
+func query(name string, schema *openapi3.Schema) *openapi3.Parameter {
+	return openapi3.NewQueryParameter(name).WithSchema(schema)
+}
+
+// listParams documents the paging and sorting parameters of a list route paged by l, followed
+// by its filters
+func listParams(l listing.Listing, filters ...*openapi3.Parameter) []*openapi3.Parameter {
+	var sorts []interface{}
+	for _, name := range l.Sorts() {
+		sorts = append(sorts, name)
+	}
+	params := []*openapi3.Parameter{
+		query("limit", integer().WithMin(1).WithMax(listing.MaxLimit)),
+		query("cursor", openapi3.NewStringSchema()),
+		query("sort", openapi3.NewStringSchema().WithEnum(sorts...)),
+	}
+	return append(params, filters...)
+}
+
+// statusFilter documents the status filter of a list route
+func statusFilter(statuses ...string) *openapi3.Parameter {
+	values := make([]interface{}, len(statuses))
+	for i, status := range statuses {
+		values[i] = status
+	}
+	return query("status", openapi3.NewStringSchema().WithEnum(values...))
+}
+
+// integer is the schema of an integer parameter
+func integer() *openapi3.Schema {
+	return openapi3.NewInt64Schema()
+}
+
+// handleOpenAPI serves the OpenAPI document
+func (s *PDPServer) handleOpenAPI(c echo.Context) error {
+	if s.spec == nil {
+		return unavailable("API spec not available")
+	}
+	return c.JSONBlob(http.StatusOK, s.spec.json)
+}
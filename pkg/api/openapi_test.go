@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/auth"
+	"github.com/Datazen-Protocol/pdp-server/pkg/capacity"
+	"github.com/Datazen-Protocol/pdp-server/pkg/events"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/piece"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"github.com/Datazen-Protocol/pdp-server/pkg/webhook"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/glebarez/sqlite"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const testAdminToken = "test-admin-token"
+
+// newTestServer registers the routes of a server backed by a fresh database on a bare Echo
+func newTestServer(t *testing.T) (*echo.Echo, *PDPServer) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Piece{}, &models.PieceRoot{}, &models.PiecePreparation{}, &models.Blob{}, &models.Reservation{},
+		&models.APIToken{}, &models.Owner{}, &models.Event{}, &models.WebhookSubscription{}, &models.WebhookDelivery{}); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+
+	owners := tenant.NewOwnerStore(db)
+	eventLog := events.NewLog(db, owners, time.Hour)
+	capacityMgr := capacity.NewManager(db, capacity.Options{TmpPath: t.TempDir()})
+	s := NewPDPServer(ServerDeps{
+		PieceSvc:    piece.NewPieceService(nil, nil, capacityMgr, owners, eventLog, time.Hour, db),
+		Capacity:    capacityMgr,
+		TokenSvc:    auth.NewTokenService(db),
+		AdminToken:  testAdminToken,
+		RequireAuth: true,
+		Events:      eventLog,
+		WebhookSvc:  webhook.NewWebhookService(db, eventLog, webhook.Options{}),
+	})
+	e := echo.New()
+	if err := RegisterRoutes(e, s); err != nil {
+		t.Fatalf("RegisterRoutes: %v", err)
+	}
+	return e, s
+}
+
+func TestSpecDocumentsEveryRoute(t *testing.T) {
+	e, s := newTestServer(t)
+
+	for _, r := range e.Routes() {
+		if r.Method == echo.RouteNotFound {
+			continue
+		}
+		item := s.spec.doc.Paths.Value(specPath(r.Path))
+		if item == nil || item.GetOperation(r.Method) == nil {
+			t.Errorf("%s %s has no operation in the API spec", r.Method, r.Path)
+		}
+		if _, ok := operations[r.Method+" "+r.Path]; !ok {
+			t.Errorf("%s %s is not documented in operations", r.Method, r.Path)
+		}
+	}
+
+	undocumented := echo.New()
+	undocumented.GET("/undocumented", func(c echo.Context) error { return nil })
+	if _, err := buildSpec(undocumented.Routes()); err == nil || !strings.Contains(err.Error(), "GET /undocumented") {
+		t.Fatalf("buildSpec with an undocumented route: err = %v", err)
+	}
+}
+
+func TestResponsesMatchSpec(t *testing.T) {
+	e, s := newTestServer(t)
+
+	// serve sends a request and validates the response against the operation of route
+	serve := func(t *testing.T, route, method, target, token, body string, want int) []byte {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		}
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("%s %s: status %d, want %d: %s", method, target, rec.Code, want, rec.Body)
+		}
+
+		input := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: &openapi3filter.RequestValidationInput{
+				Request: req,
+				Route:   s.spec.routes[route],
+				Options: validationOptions,
+			},
+			Status:  rec.Code,
+			Header:  rec.Header(),
+			Options: validationOptions,
+		}
+		input.SetBodyBytes(rec.Body.Bytes())
+		if err := openapi3filter.ValidateResponse(context.Background(), input); err != nil {
+			t.Fatalf("%s %s: response does not match the API spec: %s", method, target, validationMessage(err))
+		}
+		return rec.Body.Bytes()
+	}
+
+	var issued auth.TokenInfo
+	body := serve(t, "POST /admin/tokens", http.MethodPost, "/admin/tokens", testAdminToken,
+		`{"name":"client","tenant":"acme","scopes":["read","upload","webhooks"]}`, http.StatusCreated)
+	if err := json.Unmarshal(body, &issued); err != nil || issued.Token == "" {
+		t.Fatalf("issued token: %s", body)
+	}
+	token := issued.Token
+
+	tests := []struct {
+		name, route, method, target, token, body string
+		want                                     int
+	}{
+		{"health", "GET /health", http.MethodGet, "/health", "", "", http.StatusOK},
+		{"openapi", "GET /openapi.json", http.MethodGet, "/openapi.json", token, "", http.StatusOK},
+		{"status", "GET /status", http.MethodGet, "/status", token, "", http.StatusOK},
+		{"tokens", "GET /admin/tokens", http.MethodGet, "/admin/tokens", testAdminToken, "", http.StatusOK},
+		{"prepare piece", "POST /pieces", http.MethodPost, "/pieces", token,
+			`{"check":{"name":"sha2-256","hash":"` + strings.Repeat("ab", 32) + `","size":1024}}`, http.StatusCreated},
+		{"pieces", "GET /pieces", http.MethodGet, "/pieces?status=prepared", token, "", http.StatusOK},
+		{"create webhook", "POST /webhooks", http.MethodPost, "/webhooks", token,
+			`{"url":"https://example.com/hook","event_types":["piece.status"]}`, http.StatusCreated},
+		{"webhooks", "GET /webhooks", http.MethodGet, "/webhooks", token, "", http.StatusOK},
+
+		{"unauthenticated", "GET /pieces", http.MethodGet, "/pieces", "", "", http.StatusUnauthorized},
+		{"not admin", "GET /admin/tokens", http.MethodGet, "/admin/tokens", token, "", http.StatusUnauthorized},
+		{"invalid request", "POST /pieces", http.MethodPost, "/pieces", token, `{"check":{"hash":""}}`, http.StatusBadRequest},
+		{"unknown webhook", "GET /webhooks/:id", http.MethodGet, "/webhooks/missing", token, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serve(t, tt.route, tt.method, tt.target, tt.token, tt.body, tt.want)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/resumable"
+	"github.com/labstack/echo/v4"
+)
+
+// createUploadRequest declares the length of a resumable upload
+type createUploadRequest struct {
+	Length int64 `json:"length"`
+}
+
+// handleCreateUploadSession opens a resumable upload session
+func (s *PDPServer) handleCreateUploadSession(c echo.Context) error {
+	if s.resumableSvc == nil {
+		return unavailable("Resumable upload service not available")
+	}
+
+	// The length may be declared tus-style in a header or in the JSON body
+	var req createUploadRequest
+	if header := c.Request().Header.Get("Upload-Length"); header != "" {
+		length, err := strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			return invalidRequest("Invalid Upload-Length header")
+		}
+		req.Length = length
+	} else if err := c.Bind(&req); err != nil {
+		return invalidRequest("Invalid request body")
+	}
+
+	session, err := s.resumableSvc.CreateSession(c.Request().Context(), req.Length)
+	if err != nil {
+		return err
+	}
+
+	setUploadHeaders(c, session)
+	c.Response().Header().Set("Location", "/uploads/"+session.ID)
+	return c.JSON(http.StatusCreated, session)
+}
+
+// handleHeadUploadSession reports the progress of a resumable upload
+func (s *PDPServer) handleHeadUploadSession(c echo.Context) error {
+	if s.resumableSvc == nil {
+		return unavailable("Resumable upload service not available")
+	}
+
+	session, err := s.resumableSvc.GetSession(c.Request().Context(), c.Param("uploadID"))
+	if err != nil {
+		return err
+	}
+	if session.Status == "expired" {
+		return resumable.ErrSessionExpired
+	}
+
+	setUploadHeaders(c, session)
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.NoContent(http.StatusOK)
+}
+
+// handlePatchUploadSession appends a chunk to a resumable upload
+func (s *PDPServer) handlePatchUploadSession(c echo.Context) error {
+	if s.resumableSvc == nil {
+		return unavailable("Resumable upload service not available")
+	}
+
+	offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return invalidRequest("Missing or invalid Upload-Offset header")
+	}
+
+	session, err := s.resumableSvc.WriteChunk(c.Request().Context(), c.Param("uploadID"), offset, c.Request().Body)
+	if err != nil {
+		if session != nil {
+			// Part of the chunk was stored; report where to resume from
+			setUploadHeaders(c, session)
+		}
+		return err
+	}
+
+	setUploadHeaders(c, session)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleFinalizeUploadSession turns a completed upload into a piece
+func (s *PDPServer) handleFinalizeUploadSession(c echo.Context) error {
+	if s.resumableSvc == nil {
+		return unavailable("Resumable upload service not available")
+	}
+
+	pieceInfo, err := s.resumableSvc.Finalize(c.Request().Context(), c.Param("uploadID"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, pieceInfo)
+}
+
+// setUploadHeaders writes the tus-style progress headers for a session
+func setUploadHeaders(c echo.Context, session *resumable.SessionInfo) {
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Response().Header().Set("Upload-Length", strconv.FormatInt(session.Length, 10))
+}
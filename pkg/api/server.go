@@ -7,37 +7,118 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/Datazen-Protocol/pdp-server/pkg/auth"
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/capacity"
+	"github.com/Datazen-Protocol/pdp-server/pkg/car"
+	"github.com/Datazen-Protocol/pdp-server/pkg/events"
+	"github.com/Datazen-Protocol/pdp-server/pkg/gc"
+	"github.com/Datazen-Protocol/pdp-server/pkg/idempotency"
+	"github.com/Datazen-Protocol/pdp-server/pkg/importer"
 	"github.com/Datazen-Protocol/pdp-server/pkg/piece"
 	"github.com/Datazen-Protocol/pdp-server/pkg/piri"
 	"github.com/Datazen-Protocol/pdp-server/pkg/proofset"
+	"github.com/Datazen-Protocol/pdp-server/pkg/ratelimit"
+	"github.com/Datazen-Protocol/pdp-server/pkg/resumable"
+	"github.com/Datazen-Protocol/pdp-server/pkg/scrub"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
 	"github.com/Datazen-Protocol/pdp-server/pkg/upload"
 	"github.com/Datazen-Protocol/pdp-server/pkg/watcher"
+	"github.com/Datazen-Protocol/pdp-server/pkg/webhook"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/storacha/piri/pkg/store/blobstore"
+	"github.com/labstack/echo/v4/middleware"
 )
 
 // PDPServer wraps Piri's PDP server functionality
 type PDPServer struct {
-	piriServer     *piri.Server
-	Echo           *echo.Echo // Exported field
-	uploadSvc      *upload.UploadService
-	proofSetSvc    *proofset.ProofSetService
-	simpleProofSvc *proofset.SimpleProofSetService
-	pieceSvc       *piece.PieceService
-	txWatcher      *watcher.TransactionWatcher
+	piriServer        *piri.Server
+	Echo              *echo.Echo // Exported field
+	uploadSvc         *upload.UploadService
+	proofSetSvc       *proofset.ProofSetService
+	simpleProofSvc    *proofset.SimpleProofSetService
+	pieceSvc          *piece.PieceService
+	carSvc            *car.CarService
+	gateway           http.Handler
+	resumableSvc      *resumable.ResumableService
+	janitor           *resumable.Janitor
+	importSvc         *importer.ImportService
+	collector         *gc.Collector
+	scrubber          *scrub.Scrubber
+	tieredStore       *blobstore.TieredBlobstore
+	encryptedStore    *blobstore.EncryptedBlobstore
+	capacity          *capacity.Manager
+	tokenSvc          *auth.TokenService
+	ucanVerifier      *auth.UCANVerifier // Nil when UCAN authorization is disabled
+	adminToken        string
+	requireAuth       bool               // Refuse requests without a bearer token
+	limiter           *ratelimit.Limiter // Nil when clients are not limited
+	idempotency       *idempotency.Store // Nil when Idempotency-Key is not honoured
+	events            *events.Log        // Lifecycle events streamed from GET /events
+	spec              *apiSpec           // Set by RegisterRoutes
+	validateResponses bool               // Log responses that do not match the API spec
+	webhookSvc        *webhook.WebhookService
+	txWatcher         *watcher.TransactionWatcher
+}
+
+// ServerDeps are the services and settings a PDP server is built from
+type ServerDeps struct {
+	PiriServer        *piri.Server
+	UploadSvc         *upload.UploadService
+	ProofSetSvc       *proofset.ProofSetService
+	SimpleProofSvc    *proofset.SimpleProofSetService
+	PieceSvc          *piece.PieceService
+	CarSvc            *car.CarService
+	Gateway           http.Handler
+	ResumableSvc      *resumable.ResumableService
+	Janitor           *resumable.Janitor
+	ImportSvc         *importer.ImportService
+	Collector         *gc.Collector
+	Scrubber          *scrub.Scrubber
+	TieredStore       *blobstore.TieredBlobstore
+	EncryptedStore    *blobstore.EncryptedBlobstore
+	Capacity          *capacity.Manager
+	TokenSvc          *auth.TokenService
+	UCANVerifier      *auth.UCANVerifier // Nil when UCAN authorization is disabled
+	AdminToken        string
+	RequireAuth       bool               // Refuse requests without a bearer token
+	Limiter           *ratelimit.Limiter // Nil when clients are not limited
+	Idempotency       *idempotency.Store // Nil when Idempotency-Key is not honoured
+	Events            *events.Log        // Lifecycle events streamed from GET /events
+	WebhookSvc        *webhook.WebhookService
+	ValidateResponses bool // Log responses that do not match the API spec
+	TxWatcher         *watcher.TransactionWatcher
 }
 
 // NewPDPServer creates a new PDP server instance
-func NewPDPServer(piriServer *piri.Server, blobStore blobstore.Blobstore, dataDir string, proofSetSvc *proofset.ProofSetService, simpleProofSvc *proofset.SimpleProofSetService, pieceSvc *piece.PieceService, txWatcher *watcher.TransactionWatcher) *PDPServer {
+func NewPDPServer(deps ServerDeps) *PDPServer {
 	return &PDPServer{
-		piriServer:     piriServer,
-		Echo:           echo.New(),
-		uploadSvc:      upload.NewUploadService(blobStore, dataDir),
-		proofSetSvc:    proofSetSvc,
-		simpleProofSvc: simpleProofSvc,
-		pieceSvc:       pieceSvc,
-		txWatcher:      txWatcher,
+		piriServer:        deps.PiriServer,
+		Echo:              echo.New(),
+		uploadSvc:         deps.UploadSvc,
+		proofSetSvc:       deps.ProofSetSvc,
+		simpleProofSvc:    deps.SimpleProofSvc,
+		pieceSvc:          deps.PieceSvc,
+		carSvc:            deps.CarSvc,
+		gateway:           deps.Gateway,
+		resumableSvc:      deps.ResumableSvc,
+		janitor:           deps.Janitor,
+		importSvc:         deps.ImportSvc,
+		collector:         deps.Collector,
+		scrubber:          deps.Scrubber,
+		tieredStore:       deps.TieredStore,
+		encryptedStore:    deps.EncryptedStore,
+		capacity:          deps.Capacity,
+		tokenSvc:          deps.TokenSvc,
+		ucanVerifier:      deps.UCANVerifier,
+		adminToken:        deps.AdminToken,
+		requireAuth:       deps.RequireAuth,
+		limiter:           deps.Limiter,
+		idempotency:       deps.Idempotency,
+		events:            deps.Events,
+		webhookSvc:        deps.WebhookSvc,
+		validateResponses: deps.ValidateResponses,
+		txWatcher:         deps.TxWatcher,
 	}
 }
 
@@ -57,6 +138,69 @@ func (s *PDPServer) Start(ctx context.Context) error {
 		}
 	}
 
+	// Start the upload session janitor
+	if s.janitor != nil {
+		if err := s.janitor.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start upload janitor: %w", err)
+		}
+	}
+
+	// Start the import worker
+	if s.importSvc != nil {
+		if err := s.importSvc.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start import service: %w", err)
+		}
+	}
+
+	// Start expiring idempotency keys
+	if s.idempotency != nil {
+		if err := s.idempotency.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start idempotency key store: %w", err)
+		}
+	}
+
+	// Start deleting old lifecycle events
+	if s.events != nil {
+		if err := s.events.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start event log: %w", err)
+		}
+	}
+
+	// Start delivering lifecycle events to webhook subscriptions
+	if s.webhookSvc != nil {
+		if err := s.webhookSvc.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start webhook delivery: %w", err)
+		}
+	}
+
+	// Start the garbage collector
+	if s.collector != nil {
+		if err := s.collector.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start garbage collector: %w", err)
+		}
+	}
+
+	// Start the integrity scrubber
+	if s.scrubber != nil {
+		if err := s.scrubber.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start integrity scrubber: %w", err)
+		}
+	}
+
+	// Start demoting cold blobs off the hot storage tier
+	if s.tieredStore != nil {
+		if err := s.tieredStore.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start storage tiering: %w", err)
+		}
+	}
+
+	// Start re-encrypting blobs under old keys
+	if s.encryptedStore != nil {
+		if err := s.encryptedStore.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start at-rest encryption: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -71,8 +215,31 @@ func (s *PDPServer) Stop(ctx context.Context) error {
 	return nil
 }
 
-// RegisterRoutes registers the API routes
-func RegisterRoutes(e *echo.Echo, pdpServer *PDPServer) {
+// RegisterRoutes registers the API routes and generates their OpenAPI document, which requests
+// are validated against. It fails when the routes and the document disagree.
+func RegisterRoutes(e *echo.Echo, pdpServer *PDPServer) error {
+	// Every request gets an ID, which error responses carry, and errors are reported centrally
+	e.HTTPErrorHandler = pdpServer.handleError
+	e.Use(middleware.RequestID())
+
+	// Every route but /health is limited per IP address, needs a bearer token when
+	// authentication is required, and is then limited per client. Valid mutating requests
+	// run once per Idempotency-Key.
+	if e.IPExtractor == nil {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+	e.Use(pdpServer.throttle)
+	e.Use(pdpServer.authenticate)
+	e.Use(pdpServer.limit)
+	e.Use(pdpServer.validate)
+	e.Use(pdpServer.idempotent)
+
+	read := requireScope(auth.ScopeRead)
+	upload := requireScope(auth.ScopeUpload)
+	proofSetAdmin := requireScope(auth.ScopeProofSetAdmin)
+	walletAdmin := requireScope(auth.ScopeWalletAdmin)
+	webhooks := requireScope(auth.ScopeWebhooks)
+
 	// Health check endpoint
 	e.GET("/health", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]string{
@@ -80,39 +247,95 @@ func RegisterRoutes(e *echo.Echo, pdpServer *PDPServer) {
 		})
 	})
 
+	// OpenAPI document of this API
+	e.GET("/openapi.json", pdpServer.handleOpenAPI)
+
 	// Upload endpoint for direct client uploads
-	e.POST("/upload", pdpServer.handleUpload)
+	e.POST("/upload", pdpServer.handleUpload, upload)
 
 	// File listing endpoint
-	e.GET("/files", pdpServer.handleListFiles)
+	e.GET("/files", pdpServer.handleListFiles, read)
+	e.DELETE("/files/:cid", pdpServer.handleDeleteFile, upload)
 
 	// Status endpoint
-	e.GET("/status", pdpServer.handleStatus)
+	e.GET("/status", pdpServer.handleStatus, read)
 
-	// Proof set management endpoints
-	e.POST("/proofsets", pdpServer.handleCreateProofSet)
-	e.GET("/proofsets", pdpServer.handleListProofSets)
-	e.GET("/proofsets/:id", pdpServer.handleGetProofSet)
-	e.POST("/proofsets/:id/roots", pdpServer.handleAddRootsToProofSet)
-	e.GET("/proofsets/:id/roots", pdpServer.handleGetProofSetRoots)
-	e.GET("/proofsets/:id/status", pdpServer.handleGetProofSetStatus)
+	// Proof set management endpoints; creating a proof set pays its fee from the wallet
+	e.POST("/proofsets", pdpServer.handleCreateProofSet, walletAdmin)
+	e.GET("/proofsets", pdpServer.handleListProofSets, read)
+	e.GET("/proofsets/:id", pdpServer.handleGetProofSet, read)
+	e.POST("/proofsets/:id/roots", pdpServer.handleAddRootsToProofSet, proofSetAdmin)
+	e.GET("/proofsets/:id/roots", pdpServer.handleGetProofSetRoots, read)
+	e.GET("/proofsets/:id/status", pdpServer.handleGetProofSetStatus, read)
 
 	// Piece management endpoints
-	e.POST("/pieces", pdpServer.handlePreparePiece)
-	e.PUT("/pieces/:pieceID", pdpServer.handleUploadPiece)
-	e.GET("/pieces/:pieceID", pdpServer.handleGetPiece)
-	e.POST("/pieces/:pieceID/proofset/:proofSetID", pdpServer.handleAddPieceToProofSet)
+	e.POST("/pieces", pdpServer.handlePreparePiece, upload)
+	e.GET("/pieces", pdpServer.handleListPieces, read)
+	e.PUT("/pieces", pdpServer.handleUploadPiece, upload)
+	e.PUT("/pieces/:pieceID", pdpServer.handleUploadPiece, upload)
+	e.GET("/pieces/:pieceID", pdpServer.handleGetPiece, read)
+	e.DELETE("/pieces/:pieceID", pdpServer.handleDeletePiece, upload)
+	e.POST("/pieces/:pieceID/proofset/:proofSetID", pdpServer.handleAddPieceToProofSet, proofSetAdmin)
+
+	// Resumable upload endpoints
+	e.POST("/uploads", pdpServer.handleCreateUploadSession, upload)
+	e.HEAD("/uploads/:uploadID", pdpServer.handleHeadUploadSession, upload)
+	e.PATCH("/uploads/:uploadID", pdpServer.handlePatchUploadSession, upload)
+	e.POST("/uploads/:uploadID/finalize", pdpServer.handleFinalizeUploadSession, upload)
+
+	// CAR ingestion endpoints
+	e.POST("/car", pdpServer.handleUploadCar, upload)
+	e.GET("/car/:pieceCID", pdpServer.handleGetCar, read)
+
+	// Trustless IPFS gateway backed by the CAR block index
+	e.GET("/ipfs/*", pdpServer.handleGateway, read)
+	e.HEAD("/ipfs/*", pdpServer.handleGateway, read)
 
 	// Transaction monitoring endpoints
-	e.GET("/pieces/:pieceID/transaction/status", pdpServer.handleGetTransactionStatus)
-	e.POST("/pieces/:pieceID/transaction/monitor", pdpServer.handleMonitorTransaction)
+	e.GET("/pieces/:pieceID/transaction/status", pdpServer.handleGetTransactionStatus, read)
+	e.POST("/pieces/:pieceID/transaction/monitor", pdpServer.handleMonitorTransaction, proofSetAdmin)
+
+	// Lifecycle events, streamed as Server-Sent Events or over a WebSocket
+	e.GET("/events", pdpServer.handleEvents, read)
+
+	// Webhook subscriptions, which receive the same events by HTTP POST
+	e.POST("/webhooks", pdpServer.handleCreateWebhook, webhooks)
+	e.GET("/webhooks", pdpServer.handleListWebhooks, webhooks)
+	e.GET("/webhooks/:id", pdpServer.handleGetWebhook, webhooks)
+	e.PATCH("/webhooks/:id", pdpServer.handleUpdateWebhook, webhooks)
+	e.DELETE("/webhooks/:id", pdpServer.handleDeleteWebhook, webhooks)
+	e.GET("/webhooks/:id/deliveries", pdpServer.handleListWebhookDeliveries, webhooks)
 
 	// Piri's piece upload endpoint (for internal use)
-	e.PUT("/pdp/piece/upload/:uploadUUID", pdpServer.handlePiriPieceUpload)
+	e.PUT("/pdp/piece/upload/:uploadUUID", pdpServer.handlePiriPieceUpload, upload)
 
 	// Proving endpoints
-	e.POST("/proofsets/:id/prove", pdpServer.handleProveProofSet)
-	e.GET("/proofsets/:id/prove/status", pdpServer.handleGetProveStatus)
+	e.POST("/proofsets/:id/prove", pdpServer.handleProveProofSet, proofSetAdmin)
+	e.GET("/proofsets/:id/prove/status", pdpServer.handleGetProveStatus, read)
+
+	// Operator endpoints, restricted to the admin token
+	admin := e.Group("/admin", pdpServer.requireAdmin)
+	admin.POST("/tokens", pdpServer.handleIssueToken)
+	admin.GET("/tokens", pdpServer.handleListTokens)
+	admin.DELETE("/tokens/:id", pdpServer.handleRevokeToken)
+	admin.POST("/imports", pdpServer.handleCreateImport)
+	admin.GET("/imports", pdpServer.handleListImports)
+	admin.GET("/imports/:id", pdpServer.handleGetImport)
+	admin.POST("/gc", pdpServer.handleTriggerGC)
+	admin.GET("/gc", pdpServer.handleListGCRuns)
+	admin.GET("/gc/:id", pdpServer.handleGetGCRun)
+	admin.GET("/scrub", pdpServer.handleGetScrubStatus)
+	admin.POST("/scrub", pdpServer.handleTriggerScrub)
+	admin.GET("/storage", pdpServer.handleGetStorageStatus)
+	admin.GET("/encryption", pdpServer.handleGetEncryptionStatus)
+	admin.POST("/encryption/rotate", pdpServer.handleRotateEncryptionKey)
+
+	spec, err := buildSpec(e.Routes())
+	if err != nil {
+		return err
+	}
+	pdpServer.spec = spec
+	return nil
 }
 
 // handleUpload handles direct file uploads from clients
@@ -120,110 +343,119 @@ func (s *PDPServer) handleUpload(c echo.Context) error {
 	// Get the uploaded file
 	file, err := c.FormFile("file")
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "No file uploaded",
-		})
+		return invalidRequest("No file uploaded")
 	}
 
 	// Upload file using upload service
 	result, err := s.uploadSvc.UploadFile(c.Request().Context(), file)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Upload failed: %v", err),
-		})
+		return err
 	}
 
 	return c.JSON(http.StatusOK, result)
 }
 
-// handleListFiles returns a list of uploaded files
+// handleListFiles returns a page of uploaded files
 func (s *PDPServer) handleListFiles(c echo.Context) error {
-	files, err := s.uploadSvc.ListFiles(c.Request().Context())
+	q, err := listQuery(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to list files: %v", err),
-		})
+		return err
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"files": files,
-	})
+	files, next, err := s.uploadSvc.ListFiles(c.Request().Context(), q)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, listResponse("files", files, next))
+}
+
+// handleDeleteFile forgets an uploaded file; its data is kept while pieces or proof sets use it
+func (s *PDPServer) handleDeleteFile(c echo.Context) error {
+	if err := s.uploadSvc.DeleteFile(c.Request().Context(), c.Param("cid")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
 }
 
 // handleStatus returns the current status of the PDP server
 func (s *PDPServer) handleStatus(c echo.Context) error {
-	return c.JSON(http.StatusOK, map[string]interface{}{
+	status := map[string]interface{}{
 		"status":  "running",
 		"service": "pdp-server",
-	})
+	}
+
+	if s.capacity != nil {
+		ctx := c.Request().Context()
+		capacityStatus, err := s.capacity.GetStatus(ctx)
+		if err != nil {
+			return err
+		}
+		usage, err := s.capacity.GetTenantUsage(ctx, tenant.FromContext(ctx))
+		if err != nil {
+			return err
+		}
+		status["capacity"] = capacityStatus
+		status["tenant"] = usage
+	}
+
+	return c.JSON(http.StatusOK, status)
 }
 
 // handleCreateProofSet creates a new proof set
 func (s *PDPServer) handleCreateProofSet(c echo.Context) error {
 	if s.simpleProofSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Proof set service not available",
-		})
+		return unavailable("Proof set service not available")
 	}
 
 	var req proofset.CreateProofSetRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return invalidRequest("Invalid request body")
 	}
 
 	proofSet, err := s.simpleProofSvc.CreateProofSet(c.Request().Context(), &req)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to create proof set: %v", err),
-		})
+		return err
 	}
 
 	return c.JSON(http.StatusCreated, proofSet)
 }
 
-// handleListProofSets lists all proof sets
+// handleListProofSets returns a page of proof sets
 func (s *PDPServer) handleListProofSets(c echo.Context) error {
 	if s.proofSetSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Proof set service not available",
-		})
+		return unavailable("Proof set service not available")
 	}
 
-	proofSets, err := s.proofSetSvc.ListProofSets(c.Request().Context())
+	q, err := listQuery(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to list proof sets: %v", err),
-		})
+		return err
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"proof_sets": proofSets,
-	})
+	proofSets, next, err := s.proofSetSvc.ListProofSets(c.Request().Context(), q)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, listResponse("proof_sets", proofSets, next))
 }
 
 // handleGetProofSet gets a specific proof set by ID
 func (s *PDPServer) handleGetProofSet(c echo.Context) error {
 	if s.simpleProofSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Proof set service not available",
-		})
+		return unavailable("Proof set service not available")
 	}
 
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid proof set ID",
-		})
+		return invalidRequest("Invalid proof set ID")
 	}
 
 	proofSet, err := s.simpleProofSvc.GetProofSet(c.Request().Context(), uint(id))
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": fmt.Sprintf("Proof set not found: %v", err),
-		})
+		return err
 	}
 
 	return c.JSON(http.StatusOK, proofSet)
@@ -232,30 +464,22 @@ func (s *PDPServer) handleGetProofSet(c echo.Context) error {
 // handleAddRootsToProofSet adds roots to a proof set
 func (s *PDPServer) handleAddRootsToProofSet(c echo.Context) error {
 	if s.proofSetSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Proof set service not available",
-		})
+		return unavailable("Proof set service not available")
 	}
 
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid proof set ID",
-		})
+		return invalidRequest("Invalid proof set ID")
 	}
 
 	var requests []proofset.AddRootRequest
 	if err := c.Bind(&requests); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return invalidRequest("Invalid request body")
 	}
 
 	if err := s.proofSetSvc.AddRootsToProofSet(c.Request().Context(), id, requests); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to add roots: %v", err),
-		})
+		return err
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
@@ -263,55 +487,46 @@ func (s *PDPServer) handleAddRootsToProofSet(c echo.Context) error {
 	})
 }
 
-// handleGetProofSetRoots gets the roots for a proof set
+// handleGetProofSetRoots returns a page of the roots for a proof set
 func (s *PDPServer) handleGetProofSetRoots(c echo.Context) error {
 	if s.proofSetSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Proof set service not available",
-		})
+		return unavailable("Proof set service not available")
 	}
 
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid proof set ID",
-		})
+		return invalidRequest("Invalid proof set ID")
 	}
 
-	roots, err := s.proofSetSvc.GetProofSetRoots(c.Request().Context(), id)
+	q, err := listQuery(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to get roots: %v", err),
-		})
+		return err
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"roots": roots,
-	})
+	roots, next, err := s.proofSetSvc.ListProofSetRoots(c.Request().Context(), id, q)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, listResponse("roots", roots, next))
 }
 
 // handleGetProofSetStatus gets detailed status of a proof set
 func (s *PDPServer) handleGetProofSetStatus(c echo.Context) error {
 	if s.proofSetSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Proof set service not available",
-		})
+		return unavailable("Proof set service not available")
 	}
 
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid proof set ID",
-		})
+		return invalidRequest("Invalid proof set ID")
 	}
 
 	status, err := s.proofSetSvc.GetProofSetStatus(c.Request().Context(), id)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to get status: %v", err),
-		})
+		return err
 	}
 
 	return c.JSON(http.StatusOK, status)
@@ -320,56 +535,59 @@ func (s *PDPServer) handleGetProofSetStatus(c echo.Context) error {
 // handlePreparePiece prepares a piece for upload
 func (s *PDPServer) handlePreparePiece(c echo.Context) error {
 	if s.pieceSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Piece service not available",
-		})
-	}
-
-	var req struct {
-		FilePath string `json:"file_path"`
+		return unavailable("Piece service not available")
 	}
 
+	var req piece.PrepareRequest
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		return invalidRequest("Invalid request body")
 	}
 
-	if req.FilePath == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "file_path is required",
-		})
+	if req.Check == nil {
+		return invalidRequest("check is required")
 	}
 
 	// Prepare the piece
-	pieceInfo, err := s.pieceSvc.PreparePiece(c.Request().Context(), req.FilePath)
+	pieceInfo, err := s.pieceSvc.PreparePiece(c.Request().Context(), &req)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to prepare piece: %v", err),
-		})
+		return err
+	}
+
+	// The data is already stored under its content address; nothing left to upload
+	if pieceInfo.Status != "prepared" {
+		return c.JSON(http.StatusOK, pieceInfo)
 	}
 
 	return c.JSON(http.StatusCreated, pieceInfo)
 }
 
-// handleUploadPiece uploads piece data
+// handleUploadPiece uploads piece data. The piece ID is optional; when given it must be the
+// sha2-256 of the data, and re-uploading stored data returns the existing piece.
 func (s *PDPServer) handleUploadPiece(c echo.Context) error {
 	if s.pieceSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Piece service not available",
-		})
+		return unavailable("Piece service not available")
 	}
 
 	pieceID := c.Param("pieceID")
-	if pieceID == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Piece ID is required",
-		})
+
+	fileContent, err := readUploadContent(c)
+	if err != nil {
+		return err
 	}
 
-	// Read file content from multipart form or request body
+	// Upload the piece
+	pieceInfo, err := s.pieceSvc.UploadPiece(c.Request().Context(), pieceID, fileContent)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, pieceInfo)
+}
+
+// readUploadContent reads file content from a multipart "file" field or the raw request body.
+// Bodies over the size limit fail with an *http.MaxBytesError.
+func readUploadContent(c echo.Context) ([]byte, error) {
 	var fileContent []byte
-	var err error
 
 	// Try multipart form first
 	file, err := c.FormFile("file")
@@ -377,85 +595,143 @@ func (s *PDPServer) handleUploadPiece(c echo.Context) error {
 		// Handle multipart file upload
 		src, err := file.Open()
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{
-				"error": "Failed to open uploaded file",
-			})
+			return nil, fmt.Errorf("failed to open uploaded file: %w", err)
 		}
 		defer src.Close()
 
 		fileContent, err = io.ReadAll(src)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{
-				"error": "Failed to read uploaded file",
-			})
+			return nil, fmt.Errorf("failed to read uploaded file: %w", err)
 		}
 	} else {
 		// Try reading from request body
 		fileContent, err = io.ReadAll(c.Request().Body)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{
-				"error": "Failed to read request body",
-			})
+			return nil, fmt.Errorf("failed to read request body: %w", err)
 		}
 	}
 
 	if len(fileContent) == 0 {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "No file content provided",
-		})
+		return nil, invalidRequest("No file content provided")
 	}
 
-	// Upload the piece
-	pieceInfo, err := s.pieceSvc.UploadPiece(c.Request().Context(), pieceID, fileContent)
+	return fileContent, nil
+}
+
+// handleListPieces returns a page of pieces
+func (s *PDPServer) handleListPieces(c echo.Context) error {
+	if s.pieceSvc == nil {
+		return unavailable("Piece service not available")
+	}
+
+	q, err := listQuery(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to upload piece: %v", err),
-		})
+		return err
 	}
 
-	return c.JSON(http.StatusOK, pieceInfo)
+	pieces, next, err := s.pieceSvc.ListPieces(c.Request().Context(), q)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, listResponse("pieces", pieces, next))
 }
 
 // handleGetPiece retrieves piece data
 func (s *PDPServer) handleGetPiece(c echo.Context) error {
 	if s.pieceSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Piece service not available",
-		})
+		return unavailable("Piece service not available")
 	}
 
 	pieceID := c.Param("pieceID")
 	if pieceID == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Piece ID is required",
-		})
+		return invalidRequest("Piece ID is required")
 	}
 
 	// Get piece information
 	pieceInfo, err := s.pieceSvc.GetPiece(c.Request().Context(), pieceID)
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": fmt.Sprintf("Piece not found: %v", err),
-		})
+		return err
 	}
 
 	return c.JSON(http.StatusOK, pieceInfo)
 }
 
+// handleDeletePiece removes a piece; its data is kept while uploads or proof sets use it
+func (s *PDPServer) handleDeletePiece(c echo.Context) error {
+	if s.pieceSvc == nil {
+		return unavailable("Piece service not available")
+	}
+
+	if err := s.pieceSvc.DeletePiece(c.Request().Context(), c.Param("pieceID")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleUploadCar ingests a CAR file as a piece and indexes its blocks
+func (s *PDPServer) handleUploadCar(c echo.Context) error {
+	if s.carSvc == nil {
+		return unavailable("CAR service not available")
+	}
+
+	carContent, err := readUploadContent(c)
+	if err != nil {
+		return err
+	}
+
+	carInfo, err := s.carSvc.IngestCar(c.Request().Context(), carContent)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, carInfo)
+}
+
+// handleGetCar returns the roots recorded for an ingested CAR piece
+func (s *PDPServer) handleGetCar(c echo.Context) error {
+	if s.carSvc == nil {
+		return unavailable("CAR service not available")
+	}
+
+	pieceCID := c.Param("pieceCID")
+	if pieceCID == "" {
+		return invalidRequest("Piece CID is required")
+	}
+
+	carInfo, err := s.carSvc.GetCar(c.Request().Context(), pieceCID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, carInfo)
+}
+
+// handleGateway serves trustless gateway requests for blocks of ingested CARs
+func (s *PDPServer) handleGateway(c echo.Context) error {
+	if s.gateway == nil {
+		return unavailable("Gateway not available")
+	}
+
+	s.gateway.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
 // handleProveProofSet triggers proving for a proof set
 func (s *PDPServer) handleProveProofSet(c echo.Context) error {
 	if s.proofSetSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Proof set service not available",
-		})
+		return unavailable("Proof set service not available")
 	}
 
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid proof set ID",
-		})
+		return invalidRequest("Invalid proof set ID")
+	}
+
+	if err := s.proofSetSvc.CheckOwner(c.Request().Context(), id); err != nil {
+		return err
 	}
 
 	// For now, return a placeholder response
@@ -469,17 +745,17 @@ func (s *PDPServer) handleProveProofSet(c echo.Context) error {
 // handleGetProveStatus gets the proving status for a proof set
 func (s *PDPServer) handleGetProveStatus(c echo.Context) error {
 	if s.proofSetSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Proof set service not available",
-		})
+		return unavailable("Proof set service not available")
 	}
 
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid proof set ID",
-		})
+		return invalidRequest("Invalid proof set ID")
+	}
+
+	if err := s.proofSetSvc.CheckOwner(c.Request().Context(), id); err != nil {
+		return err
 	}
 
 	// For now, return a placeholder response
@@ -494,30 +770,22 @@ func (s *PDPServer) handleGetProveStatus(c echo.Context) error {
 // handleAddPieceToProofSet adds a piece to a proof set
 func (s *PDPServer) handleAddPieceToProofSet(c echo.Context) error {
 	if s.pieceSvc == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Piece service not available",
-		})
+		return unavailable("Piece service not available")
 	}
 
 	pieceID := c.Param("pieceID")
 	if pieceID == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Piece ID is required",
-		})
+		return invalidRequest("Piece ID is required")
 	}
 
 	proofSetIDStr := c.Param("proofSetID")
 	proofSetID, err := strconv.ParseInt(proofSetIDStr, 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid proof set ID",
-		})
+		return invalidRequest("Invalid proof set ID")
 	}
 
 	if err := s.pieceSvc.AddPieceToProofSet(c.Request().Context(), pieceID, proofSetID); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to add piece to proof set: %v", err),
-		})
+		return err
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
@@ -528,39 +796,29 @@ func (s *PDPServer) handleAddPieceToProofSet(c echo.Context) error {
 // handlePiriPieceUpload handles Piri's piece upload endpoint
 func (s *PDPServer) handlePiriPieceUpload(c echo.Context) error {
 	if s.piriServer == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Piri server not available",
-		})
+		return unavailable("Piri server not available")
 	}
 
 	uploadUUID := c.Param("uploadUUID")
 	if uploadUUID == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Upload UUID is required",
-		})
+		return invalidRequest("Upload UUID is required")
 	}
 
 	// Parse UUID
 	uuid, err := uuid.Parse(uploadUUID)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid upload UUID",
-		})
+		return invalidRequest("Invalid upload UUID")
 	}
 
 	// Get Piri's PDPService
 	pdpService := s.piriServer.GetPDPService()
 	if pdpService == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{
-			"error": "Piri PDP service not available",
-		})
+		return unavailable("Piri PDP service not available")
 	}
 
 	// Upload piece using Piri's service
 	if _, err := pdpService.UploadPiece(c.Request().Context(), uuid, c.Request().Body); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to upload piece: %v", err),
-		})
+		return err
 	}
 
 	// Return 204 No Content as per Piri's API
@@ -569,14 +827,18 @@ func (s *PDPServer) handlePiriPieceUpload(c echo.Context) error {
 
 // handleGetTransactionStatus returns the transaction status for a piece
 func (s *PDPServer) handleGetTransactionStatus(c echo.Context) error {
+	if s.pieceSvc == nil {
+		return unavailable("Piece service not available")
+	}
+
 	pieceID := c.Param("pieceID")
 	if pieceID == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "piece ID is required")
+		return invalidRequest("Piece ID is required")
 	}
 
 	piece, err := s.pieceSvc.GetPiece(c.Request().Context(), pieceID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("piece not found: %v", err))
+		return err
 	}
 
 	response := map[string]interface{}{
@@ -592,19 +854,22 @@ func (s *PDPServer) handleGetTransactionStatus(c echo.Context) error {
 
 // handleMonitorTransaction manually triggers transaction monitoring for a piece
 func (s *PDPServer) handleMonitorTransaction(c echo.Context) error {
+	if s.pieceSvc == nil {
+		return unavailable("Piece service not available")
+	}
+
 	pieceID := c.Param("pieceID")
 	if pieceID == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "piece ID is required")
+		return invalidRequest("Piece ID is required")
 	}
 
-	err := s.pieceSvc.MonitorTransactionStatus(c.Request().Context(), pieceID)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to monitor transaction: %v", err))
+	if err := s.pieceSvc.MonitorTransactionStatus(c.Request().Context(), pieceID); err != nil {
+		return err
 	}
 
 	piece, err := s.pieceSvc.GetPiece(c.Request().Context(), pieceID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("piece not found: %v", err))
+		return err
 	}
 
 	response := map[string]interface{}{
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/labstack/echo/v4"
+)
+
+// maxValidatedResponse bounds the response bodies validated against the API spec
+const maxValidatedResponse = 1 << 20
+
+// validationOptions validate requests and responses against the API spec. Requests were
+// already authenticated, and schema errors leave out the schema itself.
+var validationOptions = func() *openapi3filter.Options {
+	opts := &openapi3filter.Options{
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		IncludeResponseStatus: true,
+	}
+	opts.WithCustomSchemaErrorFunc(func(err *openapi3.SchemaError) string {
+		if pointer := err.JSONPointer(); len(pointer) > 0 {
+			return fmt.Sprintf("%s: %s", strings.Join(pointer, "."), err.Reason)
+		}
+		return err.Reason
+	})
+	return opts
+}()
+
+// validate rejects requests that do not match the API spec with 400 Bad Request. Raw data
+// bodies are not read, so that uploads stay streamed. With validateResponses set, responses
+// that do not match the spec are logged.
+func (s *PDPServer) validate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.spec == nil {
+			return next(c)
+		}
+		req := c.Request()
+		route := s.spec.routes[req.Method+" "+c.Path()]
+		if route == nil {
+			return next(c)
+		}
+
+		params := make(map[string]string, len(c.ParamNames()))
+		for i, name := range c.ParamNames() {
+			if name == "*" {
+				name = "path"
+			}
+			params[name] = c.ParamValues()[i]
+		}
+		opts := *validationOptions
+		opts.ExcludeRequestBody = operations[req.Method+" "+c.Path()].upload != nil
+		input := &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: params,
+			Route:      route,
+			Options:    &opts,
+		}
+		if err := openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+			return invalidRequest(validationMessage(err))
+		}
+
+		if !s.validateResponses {
+			return next(c)
+		}
+		recorder := &responseRecorder{ResponseWriter: c.Response().Writer, limit: maxValidatedResponse}
+		c.Response().Writer = recorder
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+		contentType := c.Response().Header().Get(echo.HeaderContentType)
+		if recorder.truncated || req.Method == http.MethodHead || !strings.HasPrefix(contentType, echo.MIMEApplicationJSON) {
+			return nil
+		}
+		output := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 c.Response().Status,
+			Header:                 c.Response().Header(),
+			Options:                &opts,
+		}
+		output.SetBodyBytes(recorder.body.Bytes())
+		if err := openapi3filter.ValidateResponse(req.Context(), output); err != nil {
+			log.Printf("Response to %s %s does not match the API spec: %s", req.Method, c.Path(), validationMessage(err))
+		}
+		return nil
+	}
+}
+
+// validationMessage describes a validation error in one line
+func validationMessage(err error) string {
+	return strings.ReplaceAll(err.Error(), "\n", " ")
+}
+
+// responseRecorder keeps a copy of a response body up to a limit
+type responseRecorder struct {
+	http.ResponseWriter
+	limit     int
+	body      bytes.Buffer
+	truncated bool // The body exceeds the limit and was not kept
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if !r.truncated {
+		if r.body.Len()+len(p) > r.limit {
+			r.truncated = true
+			r.body.Reset()
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Flush lets streamed responses through the recorder
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades through the recorder
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/webhook"
+	"github.com/labstack/echo/v4"
+)
+
+// handleCreateWebhook subscribes the caller's tenant to lifecycle events; a generated secret
+// is only ever returned here
+func (s *PDPServer) handleCreateWebhook(c echo.Context) error {
+	if s.webhookSvc == nil {
+		return unavailable("Webhook service not available")
+	}
+
+	var req webhook.SubscriptionRequest
+	if err := c.Bind(&req); err != nil {
+		return invalidRequest("Invalid request body")
+	}
+
+	subscription, err := s.webhookSvc.Create(c.Request().Context(), &req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, subscription)
+}
+
+// handleListWebhooks lists the caller's webhook subscriptions
+func (s *PDPServer) handleListWebhooks(c echo.Context) error {
+	if s.webhookSvc == nil {
+		return unavailable("Webhook service not available")
+	}
+
+	subscriptions, err := s.webhookSvc.List(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"webhooks": subscriptions,
+	})
+}
+
+// handleGetWebhook retrieves a webhook subscription
+func (s *PDPServer) handleGetWebhook(c echo.Context) error {
+	if s.webhookSvc == nil {
+		return unavailable("Webhook service not available")
+	}
+
+	subscription, err := s.webhookSvc.Get(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, subscription)
+}
+
+// handleUpdateWebhook changes the URL, secret, event types or activity of a subscription
+func (s *PDPServer) handleUpdateWebhook(c echo.Context) error {
+	if s.webhookSvc == nil {
+		return unavailable("Webhook service not available")
+	}
+
+	var req webhook.UpdateRequest
+	if err := c.Bind(&req); err != nil {
+		return invalidRequest("Invalid request body")
+	}
+
+	subscription, err := s.webhookSvc.Update(c.Request().Context(), c.Param("id"), &req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, subscription)
+}
+
+// handleDeleteWebhook removes a subscription and its delivery log
+func (s *PDPServer) handleDeleteWebhook(c echo.Context) error {
+	if s.webhookSvc == nil {
+		return unavailable("Webhook service not available")
+	}
+
+	if err := s.webhookSvc.Delete(c.Request().Context(), c.Param("id")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleListWebhookDeliveries lists a page of a subscription's delivery log
+func (s *PDPServer) handleListWebhookDeliveries(c echo.Context) error {
+	if s.webhookSvc == nil {
+		return unavailable("Webhook service not available")
+	}
+
+	q, err := listQuery(c)
+	if err != nil {
+		return err
+	}
+
+	deliveries, next, err := s.webhookSvc.ListDeliveries(c.Request().Context(), c.Param("id"), q)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, listResponse("deliveries", deliveries, next))
+}
@@ -0,0 +1,52 @@
+// Package apperr defines the kinds of errors services report to API clients. Each error carries
+// a kind, which decides how it is reported, and a stable machine-readable code. Errors without a
+// kind are internal and their details are not shown to clients.
+package apperr
+
+import "errors"
+
+// Kind classifies an error by what the client can do about it
+type Kind int
+
+const (
+	Internal      Kind = iota // An unexpected failure
+	Invalid                   // The request is malformed or fails validation
+	Unauthorized              // The request lacks valid credentials
+	Forbidden                 // The credentials do not allow the request
+	NotFound                  // The resource does not exist, or belongs to another tenant
+	Conflict                  // The request conflicts with another resource or operation
+	InvalidState              // The resource is not in a state that allows the operation
+	Gone                      // The resource existed but is no longer usable
+	TooLarge                  // The request body is too large
+	Unprocessable             // The data does not match what was declared for it
+	RateLimited               // The client exceeded a rate limit
+	StorageFull               // There is not enough space or quota
+	ChainError                // A blockchain call or transaction failed
+	Unavailable               // A feature is disabled or a dependency is unavailable
+)
+
+// Error is an error of a given kind with a stable code, e.g. "PIECE_NOT_FOUND". Services declare
+// them as sentinels and wrap them with details using fmt.Errorf and %w.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+}
+
+// New creates an error of the given kind
+func New(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// As returns the first typed error in err's chain, or nil if it has none
+func As(err error) *Error {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Package auth authenticates API clients and carries who a request acts for, and what it may
+// do, through request contexts.
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// Scopes grant access to groups of API routes
+const (
+	ScopeRead          = "read"           // Read pieces, files, proof sets and their status
+	ScopeUpload        = "upload"         // Upload, prepare and delete pieces and files
+	ScopeProofSetAdmin = "proofset-admin" // Add roots to proof sets and drive proving
+	ScopeWalletAdmin   = "wallet-admin"   // Create proof sets, paying their fees from the server wallet
+	ScopeWebhooks      = "webhooks"       // Manage webhook subscriptions and read their delivery logs
+)
+
+// AllScopes lists every scope, in the order they are documented
+var AllScopes = []string{ScopeRead, ScopeUpload, ScopeProofSetAdmin, ScopeWalletAdmin, ScopeWebhooks}
+
+// Principal is the authenticated client a request acts for
+type Principal struct {
+	ID     string   `json:"id"`     // Token ID, or "admin" / "anonymous"
+	Tenant string   `json:"tenant"` // Tenant stored data is charged to
+	Scopes []string `json:"scopes"`
+	Admin  bool     `json:"admin"` // Holds the operator admin token
+}
+
+// HasScope reports whether the principal was granted scope; admins hold every scope
+func (p *Principal) HasScope(scope string) bool {
+	return p.Admin || slices.Contains(p.Scopes, scope)
+}
+
+// ValidScope reports whether scope is a known scope
+func ValidScope(scope string) bool {
+	return slices.Contains(AllScopes, scope)
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a context acting for the given principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the principal a context acts for, or nil for unauthenticated contexts
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(contextKey{}).(*Principal)
+	return principal
+}
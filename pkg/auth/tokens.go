@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// tokenPrefix marks secrets as API tokens, so leaked ones are easy to spot
+const tokenPrefix = "pdp_"
+
+// lastUsedResolution bounds how often a token's last use is written back
+const lastUsedResolution = time.Minute
+
+var (
+	// ErrInvalidToken is returned for unknown, expired and revoked tokens
+	ErrInvalidToken = apperr.New(apperr.Unauthorized, "INVALID_TOKEN", "invalid or revoked token")
+	// ErrTokenNotFound is returned for unknown token IDs
+	ErrTokenNotFound = apperr.New(apperr.NotFound, "TOKEN_NOT_FOUND", "token not found")
+	// ErrInvalidTokenRequest is returned when a token to issue is incompletely or wrongly described
+	ErrInvalidTokenRequest = apperr.New(apperr.Invalid, "INVALID_TOKEN_REQUEST", "invalid token request")
+)
+
+// TokenService issues, revokes and checks scoped API bearer tokens
+type TokenService struct {
+	db *gorm.DB
+}
+
+// IssueRequest describes a token to issue
+type IssueRequest struct {
+	Name      string     `json:"name" validate:"required"`
+	Tenant    string     `json:"tenant,omitempty"` // Defaults to the default tenant
+	Scopes    []string   `json:"scopes" validate:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Omit for a token that never expires
+}
+
+// TokenInfo describes an issued token. Token holds the secret only in the response to issuing it.
+type TokenInfo struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	Tenant     string     `json:"tenant"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// NewTokenService creates a new token service
+func NewTokenService(db *gorm.DB) *TokenService {
+	return &TokenService{db: db}
+}
+
+// Issue creates a token and returns it with its secret, which is not stored and cannot be
+// retrieved again
+func (s *TokenService) Issue(ctx context.Context, req *IssueRequest) (*TokenInfo, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidTokenRequest)
+	}
+	if len(req.Scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidTokenRequest)
+	}
+	for _, scope := range req.Scopes {
+		if !ValidScope(scope) {
+			return nil, fmt.Errorf("%w: unknown scope %q, expected one of %s", ErrInvalidTokenRequest, scope, strings.Join(AllScopes, ", "))
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidTokenRequest)
+	}
+	owner := req.Tenant
+	if owner == "" {
+		owner = tenant.Default
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	scopes, err := json.Marshal(req.Scopes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode scopes: %w", err)
+	}
+	record := &models.APIToken{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Hash:      hashToken(secret),
+		Tenant:    owner,
+		Scopes:    datatypes.JSON(scopes),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.db.WithContext(ctx).Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to save token: %w", err)
+	}
+
+	log.Printf("Issued API token %s (%s) for tenant %s with scopes %v", record.ID, record.Name, owner, req.Scopes)
+	info := toTokenInfo(record)
+	info.Token = secret
+	return info, nil
+}
+
+// List returns all issued tokens, newest first, without their secrets
+func (s *TokenService) List(ctx context.Context) ([]*TokenInfo, error) {
+	var records []models.APIToken
+	if err := s.db.WithContext(ctx).Order("created_at DESC").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	tokens := make([]*TokenInfo, len(records))
+	for i := range records {
+		tokens[i] = toTokenInfo(&records[i])
+	}
+	return tokens, nil
+}
+
+// Revoke disables a token. Revoked tokens are kept so their use can still be audited.
+func (s *TokenService) Revoke(ctx context.Context, id string) error {
+	now := time.Now()
+	result := s.db.WithContext(ctx).
+		Model(&models.APIToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		if err := s.db.WithContext(ctx).Model(&models.APIToken{}).Where("id = ?", id).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to look up token: %w", err)
+		}
+		if count == 0 {
+			return fmt.Errorf("%w: %s", ErrTokenNotFound, id)
+		}
+		return nil // Already revoked
+	}
+
+	log.Printf("Revoked API token %s", id)
+	return nil
+}
+
+// Authenticate returns the principal a token secret was issued to
+func (s *TokenService) Authenticate(ctx context.Context, secret string) (*Principal, error) {
+	if !IsAPIToken(secret) {
+		return nil, ErrInvalidToken
+	}
+
+	var record models.APIToken
+	err := s.db.WithContext(ctx).Where("hash = ?", hashToken(secret)).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up token: %w", err)
+	}
+	now := time.Now()
+	if record.RevokedAt != nil || (record.ExpiresAt != nil && now.After(*record.ExpiresAt)) {
+		return nil, ErrInvalidToken
+	}
+
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) > lastUsedResolution {
+		if err := s.db.WithContext(ctx).Model(&record).Update("last_used_at", now).Error; err != nil {
+			log.Printf("Warning: failed to record use of token %s: %v", record.ID, err)
+		}
+	}
+
+	info := toTokenInfo(&record)
+	return &Principal{
+		ID:     record.ID,
+		Tenant: record.Tenant,
+		Scopes: info.Scopes,
+	}, nil
+}
+
+// IsAPIToken reports whether a bearer token has the form of an issued API token
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, tokenPrefix)
+}
+
+// hashToken returns the hex SHA-256 a token secret is stored and looked up by. Secrets are
+// random, so an unsalted fast hash is enough.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// toTokenInfo converts a token record to its API representation
+func toTokenInfo(record *models.APIToken) *TokenInfo {
+	info := &TokenInfo{
+		ID:         record.ID,
+		Name:       record.Name,
+		Tenant:     record.Tenant,
+		ExpiresAt:  record.ExpiresAt,
+		RevokedAt:  record.RevokedAt,
+		LastUsedAt: record.LastUsedAt,
+		CreatedAt:  record.CreatedAt,
+	}
+	if err := json.Unmarshal(record.Scopes, &info.Scopes); err != nil {
+		log.Printf("Warning: token %s has unreadable scopes: %v", record.ID, err)
+	}
+	return info
+}
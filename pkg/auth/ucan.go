@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+
+	"github.com/storacha/go-ucanto/core/delegation"
+	"github.com/storacha/go-ucanto/core/result/failure"
+	"github.com/storacha/go-ucanto/core/schema"
+	"github.com/storacha/go-ucanto/did"
+	"github.com/storacha/go-ucanto/principal"
+	edverifier "github.com/storacha/go-ucanto/principal/ed25519/verifier"
+	"github.com/storacha/go-ucanto/principal/verifier"
+	"github.com/storacha/go-ucanto/ucan"
+	"github.com/storacha/go-ucanto/validator"
+)
+
+// UCAN abilities and the scopes they grant
+const (
+	AbilityBlobAllocate = "blob/allocate" // Upload pieces
+	AbilityBlobRetrieve = "blob/retrieve" // Read pieces and retrieve their data
+	AbilityPDPAdd       = "pdp/add"       // Add pieces to proof sets
+)
+
+var ucanScopes = []struct {
+	ability string
+	scope   string
+}{
+	{AbilityBlobAllocate, ScopeUpload},
+	{AbilityBlobRetrieve, ScopeRead},
+	{AbilityPDPAdd, ScopeProofSetAdmin},
+}
+
+// ErrInvalidUCAN is returned for UCANs that do not parse, are not addressed to this service or
+// grant none of its abilities
+var ErrInvalidUCAN = apperr.New(apperr.Unauthorized, "INVALID_UCAN", "invalid UCAN")
+
+// UCANVerifier authorizes clients presenting a UCAN, so they need no API token. The UCAN is
+// issued by the client to the service DID and invokes one or more of the service's abilities,
+// with proofs delegating them from the service DID. The resource the abilities are delegated
+// for (their "with" DID) is the tenant the client acts for.
+type UCANVerifier struct {
+	service      did.DID
+	capabilities map[string]validator.ValidationContext[ucan.NoCaveats] // By scope
+}
+
+// NewUCANVerifier creates a verifier rooted in serviceDID. A did:key carries its own public
+// key; other DIDs such as did:web need serviceKey, the did:key of the key they resolve to.
+func NewUCANVerifier(serviceDID, serviceKey string) (*UCANVerifier, error) {
+	service, err := did.Parse(serviceDID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service DID: %w", err)
+	}
+
+	var authority principal.Verifier
+	if strings.HasPrefix(serviceDID, "did:key:") {
+		authority, err = edverifier.Parse(serviceDID)
+	} else {
+		if serviceKey == "" {
+			return nil, fmt.Errorf("service DID %s is not a did:key, so its service key is required", serviceDID)
+		}
+		var key principal.Verifier
+		key, err = edverifier.Parse(serviceKey)
+		if err == nil {
+			authority, err = verifier.Wrap(key, service)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid service key: %w", err)
+	}
+
+	// Only the service may be the root of a delegation chain; resources cannot self-issue
+	canIssue := func(capability ucan.Capability[any], issuer did.DID) bool {
+		return issuer == service
+	}
+	noRevocations := func(ctx context.Context, auth validator.Authorization[any]) validator.Revoked {
+		return nil
+	}
+
+	v := &UCANVerifier{
+		service:      service,
+		capabilities: make(map[string]validator.ValidationContext[ucan.NoCaveats], len(ucanScopes)),
+	}
+	for _, entry := range ucanScopes {
+		capability := validator.NewCapability(entry.ability, schema.DIDString(), anyCaveats{}, validator.DefaultDerives[ucan.NoCaveats])
+		v.capabilities[entry.scope] = validator.NewValidationContext(
+			authority,
+			capability,
+			canIssue,
+			noRevocations,
+			validator.ProofUnavailable,
+			edverifier.Parse,
+			validator.FailDIDKeyResolution,
+		)
+	}
+	return v, nil
+}
+
+// Authenticate verifies a UCAN encoded as by delegation.Format and returns the principal it
+// authorizes, with a scope for each ability it proves
+func (v *UCANVerifier) Authenticate(ctx context.Context, encoded string) (*Principal, error) {
+	dlg, err := delegation.Parse(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUCAN, err)
+	}
+	if dlg.Audience().DID() != v.service {
+		return nil, fmt.Errorf("%w: addressed to %s, not %s", ErrInvalidUCAN, dlg.Audience().DID(), v.service)
+	}
+
+	principal := &Principal{ID: dlg.Issuer().DID().String()}
+	proofs := []delegation.Proof{delegation.FromDelegation(dlg)}
+	var failures []string
+	for _, entry := range ucanScopes {
+		vctx := v.capabilities[entry.scope]
+		authorization, unauthorized := validator.Claim(ctx, vctx.Capability(), proofs, vctx)
+		if unauthorized != nil {
+			failures = append(failures, unauthorized.Error())
+			continue
+		}
+		resource := authorization.Capability().With()
+		if principal.Tenant != "" && principal.Tenant != resource {
+			return nil, fmt.Errorf("%w: abilities are delegated for both %s and %s", ErrInvalidUCAN, principal.Tenant, resource)
+		}
+		principal.Tenant = resource
+		principal.Scopes = append(principal.Scopes, entry.scope)
+	}
+	if len(principal.Scopes) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidUCAN, strings.Join(failures, "; "))
+	}
+	return principal, nil
+}
+
+// anyCaveats accepts any caveats; abilities are granted per resource, not per piece
+type anyCaveats struct{}
+
+func (anyCaveats) Read(input any) (ucan.NoCaveats, failure.Failure) {
+	return ucan.NoCaveats{}, nil
+}
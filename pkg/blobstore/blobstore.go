@@ -2,66 +2,455 @@ package blobstore
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"iter"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
-// Blobstore provides file storage functionality
+var (
+	// ErrInvalidRange is returned for ranged reads that do not start inside the blob
+	ErrInvalidRange = errors.New("invalid range")
+
+	// ErrInvalidKey is returned for keys that could not be stored safely on every backend
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// maxKeyLength bounds keys well under S3's 1024 byte object name limit, leaving room for a prefix
+const maxKeyLength = 512
+
+// NotFoundError is returned when no blob is stored under a key. It matches both ErrBlobNotFound
+// and os.ErrNotExist with errors.Is.
+type NotFoundError struct {
+	Key string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("blob not found: %s", e.Key)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrBlobNotFound || target == os.ErrNotExist
+}
+
+// ValidateKey checks that a key is one or more slash separated segments of letters, digits,
+// '.', '_' and '-', with no empty, "." or ".." segments that could escape the store
+func ValidateKey(key string) error {
+	if key == "" || len(key) > maxKeyLength {
+		return fmt.Errorf("%w: length %d", ErrInvalidKey, len(key))
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+		for _, r := range segment {
+			if !isKeyChar(r) {
+				return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+			}
+		}
+	}
+	return nil
+}
+
+func isKeyChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-'
+}
+
+// Blobstore provides file storage functionality. Keys must pass ValidateKey.
 type Blobstore interface {
-	// Put stores a file with the given key
+	// Put stores a file with the given key. The file appears whole or not at all: a failed or
+	// interrupted Put never leaves partial content under the key.
 	Put(ctx context.Context, key string, data io.Reader) error
 
-	// Get retrieves a file by key
+	// Get retrieves a file by key, returning a *NotFoundError if it is not stored
 	Get(ctx context.Context, key string) (io.ReadCloser, error)
 
-	// Delete removes a file by key
+	// GetRange retrieves up to length bytes of a file starting at offset. The offset must lie
+	// inside the file; a range running past the end is cut short.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+
+	// Stat returns a file's size and modification time without reading it
+	Stat(ctx context.Context, key string) (*BlobInfo, error)
+
+	// Has reports whether a file is stored under key
+	Has(ctx context.Context, key string) (bool, error)
+
+	// List iterates over every file whose key starts with prefix, in no particular order.
+	// Iteration stops at the first error, which is yielded with a zero BlobInfo.
+	List(ctx context.Context, prefix string) iter.Seq2[BlobInfo, error]
+
+	// Delete removes a file by key. Deleting a key that is not stored may succeed or return
+	// a *NotFoundError, depending on the backend.
 	Delete(ctx context.Context, key string) error
 }
 
-// FileBlobstore implements Blobstore using the filesystem
+// BlobInfo describes a stored file
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// tmpDirName holds in-progress writes. It is not a valid shard name, so it never collides with blobs.
+const tmpDirName = ".tmp"
+
+// FileBlobstore implements Blobstore using the filesystem. Blobs are sharded into two levels of
+// directories named after the leading bytes of the SHA-256 of their key, since keys such as
+// PieceCIDs share long literal prefixes and would otherwise pile into one directory.
 type FileBlobstore struct {
 	basePath string
 }
 
-// NewFileBlobstore creates a new file-based blobstore
+// NewFileBlobstore creates a new file-based blobstore, discarding writes interrupted by a crash
+// and moving blobs stored by the older flat layout into their shards
 func NewFileBlobstore(basePath string) (*FileBlobstore, error) {
-	if err := os.MkdirAll(basePath, 0755); err != nil {
+	fb := &FileBlobstore{basePath: basePath}
+
+	if err := os.RemoveAll(fb.tmpDir()); err != nil {
+		return nil, fmt.Errorf("failed to clear interrupted writes: %w", err)
+	}
+	if err := os.MkdirAll(fb.tmpDir(), 0755); err != nil {
 		return nil, err
 	}
-	return &FileBlobstore{basePath: basePath}, nil
+	if err := fb.migrateFlatLayout(); err != nil {
+		return nil, fmt.Errorf("failed to migrate blobs to sharded layout: %w", err)
+	}
+	return fb, nil
 }
 
-// Put stores a file
+// Put writes to a temp file, syncs it and renames it over the final path, so a crash leaves
+// either the previous content or the new content under the key
 func (fb *FileBlobstore) Put(ctx context.Context, key string, data io.Reader) error {
-	filePath := filepath.Join(fb.basePath, key)
+	filePath, err := fb.path(key)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(fb.tmpDir(), "put-*")
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := io.Copy(tmp, &contextReader{ctx: ctx, r: data}); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
 
-	// Ensure directory exists
 	dir := filepath.Dir(filePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
-
-	// Create file
-	file, err := os.Create(filePath)
-	if err != nil {
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
 		return err
 	}
-	defer file.Close()
+	committed = true
 
-	// Copy data
-	_, err = io.Copy(file, data)
-	return err
+	// Persist the rename itself
+	return syncDir(dir)
 }
 
 // Get retrieves a file
 func (fb *FileBlobstore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
-	filePath := filepath.Join(fb.basePath, key)
-	return os.Open(filePath)
+	file, _, err := fb.open(key)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// GetRange retrieves part of a file
+func (fb *FileBlobstore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length <= 0 {
+		return nil, fmt.Errorf("%w: offset %d, length %d", ErrInvalidRange, offset, length)
+	}
+
+	file, info, err := fb.open(key)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= info.Size() {
+		file.Close()
+		return nil, fmt.Errorf("%w: offset %d, size %d", ErrInvalidRange, offset, info.Size())
+	}
+
+	return &sectionReadCloser{
+		Reader: io.NewSectionReader(file, offset, length),
+		Closer: file,
+	}, nil
+}
+
+// Stat returns a file's size and modification time
+func (fb *FileBlobstore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	filePath, err := fb.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, notFound(key, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, &NotFoundError{Key: key}
+	}
+	return &BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Has reports whether a file is stored under key
+func (fb *FileBlobstore) Has(ctx context.Context, key string) (bool, error) {
+	if _, err := fb.Stat(ctx, key); err != nil {
+		if errors.Is(err, ErrBlobNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
 }
 
-// Delete removes a file
+// List walks every shard, since sharding by hash scatters keys sharing a prefix
+func (fb *FileBlobstore) List(ctx context.Context, prefix string) iter.Seq2[BlobInfo, error] {
+	return func(yield func(BlobInfo, error) bool) {
+		errStop := errors.New("stop")
+		err := filepath.WalkDir(fb.basePath, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p == fb.tmpDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			key, ok := fb.keyOf(p)
+			if !ok || !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				if os.IsNotExist(err) {
+					// Deleted since the directory was read
+					return nil
+				}
+				return err
+			}
+			if !yield(BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil) {
+				return errStop
+			}
+			return nil
+		})
+		if err != nil && err != errStop {
+			yield(BlobInfo{}, err)
+		}
+	}
+}
+
+// Delete removes a file, along with any directories it leaves empty inside its shard
 func (fb *FileBlobstore) Delete(ctx context.Context, key string) error {
-	filePath := filepath.Join(fb.basePath, key)
-	return os.Remove(filePath)
+	filePath, err := fb.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil {
+		return notFound(key, err)
+	}
+
+	shard := filepath.Join(fb.basePath, shardDir(key))
+	for dir := filepath.Dir(filePath); dir != shard; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// path validates a key and returns the file it is stored in
+func (fb *FileBlobstore) path(key string) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(fb.basePath, shardDir(key), filepath.FromSlash(key)), nil
+}
+
+// keyOf recovers the key of a file found inside a shard
+func (fb *FileBlobstore) keyOf(filePath string) (string, bool) {
+	rel, err := filepath.Rel(fb.basePath, filePath)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.SplitN(filepath.ToSlash(rel), "/", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	key := parts[2]
+	if ValidateKey(key) != nil || shardDir(key) != filepath.Join(parts[0], parts[1]) {
+		return "", false
+	}
+	return key, true
+}
+
+// open opens a stored file for reading
+func (fb *FileBlobstore) open(key string) (*os.File, os.FileInfo, error) {
+	filePath, err := fb.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, notFound(key, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, &NotFoundError{Key: key}
+	}
+	return file, info, nil
+}
+
+func (fb *FileBlobstore) tmpDir() string {
+	return filepath.Join(fb.basePath, tmpDirName)
+}
+
+// migrateFlatLayout moves blobs stored directly under basePath into their shards
+func (fb *FileBlobstore) migrateFlatLayout() error {
+	entries, err := os.ReadDir(fb.basePath)
+	if err != nil {
+		return err
+	}
+
+	moved := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == tmpDirName || (entry.IsDir() && isShardName(name)) {
+			continue
+		}
+		err := filepath.WalkDir(filepath.Join(fb.basePath, name), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(fb.basePath, p)
+			if err != nil {
+				return err
+			}
+			key := filepath.ToSlash(rel)
+			dest, err := fb.path(key)
+			if err != nil {
+				log.Printf("Warning: leaving blob with invalid key %q unmigrated: %v", key, err)
+				return nil
+			}
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(p, dest); err != nil {
+				return err
+			}
+			moved++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			// Only removes directories the walk emptied
+			removeEmptyDirs(filepath.Join(fb.basePath, name))
+		}
+	}
+
+	if moved > 0 {
+		log.Printf("Moved %d blobs into the sharded layout", moved)
+	}
+	return nil
+}
+
+// shardDir returns the two-level shard directory for a key
+func shardDir(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(hex.EncodeToString(sum[:1]), hex.EncodeToString(sum[1:2]))
+}
+
+func isShardName(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// notFound converts a missing file error into a *NotFoundError
+func notFound(key string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return &NotFoundError{Key: key}
+	}
+	return err
+}
+
+// removeEmptyDirs removes dir and its subdirectories, deepest first, wherever they are empty
+func removeEmptyDirs(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			removeEmptyDirs(filepath.Join(dir, entry.Name()))
+		}
+	}
+	os.Remove(dir)
+}
+
+// syncDir fsyncs a directory so entries renamed into it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// contextReader stops a copy once its context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// sectionReadCloser closes the file underneath a section reader
+type sectionReadCloser struct {
+	io.Reader
+	io.Closer
 }
@@ -0,0 +1,154 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore/blobstoretest"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func newFileBlobstore(t *testing.T) *blobstore.FileBlobstore {
+	t.Helper()
+	store, err := blobstore.NewFileBlobstore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileBlobstore: %v", err)
+	}
+	return store
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Blob{}, &models.BlobRef{}, &models.BlobEncryption{}); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	return db
+}
+
+func TestFileBlobstore(t *testing.T) {
+	blobstoretest.Run(t, newFileBlobstore(t))
+}
+
+func TestTieredBlobstore(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := newFileBlobstore(t), newFileBlobstore(t)
+	// A hot tier smaller than the suite's data, so blobs are demoted while it runs
+	store, err := blobstore.NewTieredBlobstore(ctx, hot, cold,
+		blobstore.TierLimits{Capacity: 4 << 20, HighWatermark: 0.9, LowWatermark: 0.5},
+		blobstore.TierLimits{},
+		10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewTieredBlobstore: %v", err)
+	}
+	if err := store.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer store.Stop()
+
+	blobstoretest.Run(t, store)
+}
+
+func TestTieredBlobstorePromotion(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := newFileBlobstore(t), newFileBlobstore(t)
+	for key, size := range map[string]int{"a": 600, "b": 600, "huge": 1000} {
+		if err := cold.Put(ctx, key, bytes.NewReader(make([]byte, size))); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	store, err := blobstore.NewTieredBlobstore(ctx, hot, cold,
+		blobstore.TierLimits{Capacity: 1000, HighWatermark: 0.9, LowWatermark: 0.5},
+		blobstore.TierLimits{},
+		time.Hour)
+	if err != nil {
+		t.Fatalf("NewTieredBlobstore: %v", err)
+	}
+	if err := store.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer store.Stop()
+
+	read := func(key string, ranged bool) {
+		t.Helper()
+		var obj io.ReadCloser
+		var err error
+		if ranged {
+			obj, err = store.GetRange(ctx, key, 0, 10)
+		} else {
+			obj, err = store.Get(ctx, key)
+		}
+		if err != nil {
+			t.Fatalf("read %s: %v", key, err)
+		}
+		io.Copy(io.Discard, obj)
+		obj.Close()
+	}
+	// settled waits for background promotions, then reports which blobs are hot
+	settled := func() map[string]bool {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for store.GetStatus().Promoting > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		hotKeys := make(map[string]bool)
+		for info, err := range hot.List(ctx, "") {
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			hotKeys[info.Key] = true
+		}
+		return hotKeys
+	}
+
+	read("a", true)
+	if hotKeys := settled(); hotKeys["a"] {
+		t.Fatal("ranged read promoted a cold blob")
+	}
+	read("a", false)
+	if hotKeys := settled(); !hotKeys["a"] {
+		t.Fatal("full read did not promote a cold blob")
+	}
+
+	// The hot tier has no room for both, so the least recently read one makes way
+	read("b", false)
+	if hotKeys := settled(); !hotKeys["b"] || hotKeys["a"] {
+		t.Fatalf("hot blobs after promoting b = %v, want only b", hotKeys)
+	}
+	if status := store.GetStatus(); status.HotUsed > 900 {
+		t.Fatalf("hot tier holds %d bytes, past its high watermark", status.HotUsed)
+	}
+
+	read("huge", false)
+	if hotKeys := settled(); hotKeys["huge"] || !hotKeys["b"] {
+		t.Fatalf("hot blobs after reading a blob too large for the hot tier = %v, want only b", hotKeys)
+	}
+}
+
+func TestEncryptedBlobstore(t *testing.T) {
+	ctx := context.Background()
+	keys, err := blobstore.OpenLocalKMS(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenLocalKMS: %v", err)
+	}
+	store, err := blobstore.NewEncryptedBlobstore(ctx, newFileBlobstore(t), keys, newTestDB(t), time.Hour)
+	if err != nil {
+		t.Fatalf("NewEncryptedBlobstore: %v", err)
+	}
+	if err := store.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer store.Stop()
+
+	blobstoretest.Run(t, store)
+}
@@ -0,0 +1,308 @@
+// Package blobstoretest checks that a blobstore.Blobstore implementation behaves like the others,
+// so every backend can be held to the same contract.
+package blobstoretest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+)
+
+// TestBlobstore exercises every Blobstore method against store and returns an error describing
+// each contract violation found, or nil. It only touches keys under a random prefix and removes
+// them again, so it can run against a store that already holds data.
+func TestBlobstore(ctx context.Context, store blobstore.Blobstore) error {
+	t := &tester{ctx: ctx, store: store, prefix: "conformance-" + randomHex(8) + "/"}
+
+	t.testPutGet()
+	t.testMissing()
+	t.testInvalidKeys()
+	t.testStatHas()
+	t.testGetRange()
+	t.testList()
+	t.testDelete()
+	t.cleanup()
+
+	return errors.Join(t.errs...)
+}
+
+// Run runs TestBlobstore as part of a Go test, reporting each contract violation as a test
+// error
+func Run(t *testing.T, store blobstore.Blobstore) {
+	t.Helper()
+	err := TestBlobstore(context.Background(), store)
+	if err == nil {
+		return
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			t.Error(err)
+		}
+		return
+	}
+	t.Error(err)
+}
+
+// tester collects failures instead of stopping at the first
+type tester struct {
+	ctx    context.Context
+	store  blobstore.Blobstore
+	prefix string
+	keys   []string
+	errs   []error
+}
+
+func (t *tester) errorf(format string, args ...interface{}) {
+	t.errs = append(t.errs, fmt.Errorf(format, args...))
+}
+
+// put stores data under a key inside the test prefix and returns the full key
+func (t *tester) put(name string, data []byte) (string, bool) {
+	key := t.prefix + name
+	t.keys = append(t.keys, key)
+	if err := t.store.Put(t.ctx, key, bytes.NewReader(data)); err != nil {
+		t.errorf("Put(%s): %v", key, err)
+		return key, false
+	}
+	return key, true
+}
+
+func (t *tester) read(key string, rc io.ReadCloser, err error) ([]byte, bool) {
+	if err != nil {
+		t.errorf("reading %s: %v", key, err)
+		return nil, false
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.errorf("reading %s: %v", key, err)
+		return nil, false
+	}
+	return data, true
+}
+
+func (t *tester) testPutGet() {
+	// Large enough to span several reads and, on object stores, more than one upload part
+	for name, data := range map[string][]byte{
+		"empty": {},
+		"small": []byte("hello blobstore"),
+		"large": randomBytes(5<<20 + 17),
+	} {
+		key, ok := t.put(name, data)
+		if !ok {
+			continue
+		}
+		rc, err := t.store.Get(t.ctx, key)
+		if got, ok := t.read(key, rc, err); ok && !bytes.Equal(got, data) {
+			t.errorf("Get(%s) returned %d bytes, want the %d bytes stored", key, len(got), len(data))
+		}
+	}
+
+	// Putting again replaces the content
+	key, ok := t.put("overwrite", []byte("first"))
+	if !ok {
+		return
+	}
+	if _, ok := t.put("overwrite", []byte("second")); !ok {
+		return
+	}
+	rc, err := t.store.Get(t.ctx, key)
+	if got, ok := t.read(key, rc, err); ok && string(got) != "second" {
+		t.errorf("Get(%s) after overwrite returned %q, want %q", key, got, "second")
+	}
+}
+
+func (t *tester) testMissing() {
+	key := t.prefix + "missing"
+	if _, err := t.store.Get(t.ctx, key); !isNotFound(err) {
+		t.errorf("Get of missing key returned %v, want an *NotFoundError", err)
+	}
+	if _, err := t.store.GetRange(t.ctx, key, 0, 1); !isNotFound(err) {
+		t.errorf("GetRange of missing key returned %v, want an *NotFoundError", err)
+	}
+	if _, err := t.store.Stat(t.ctx, key); !isNotFound(err) {
+		t.errorf("Stat of missing key returned %v, want an *NotFoundError", err)
+	}
+	if has, err := t.store.Has(t.ctx, key); err != nil || has {
+		t.errorf("Has of missing key returned %t, %v, want false, nil", has, err)
+	}
+}
+
+func (t *tester) testInvalidKeys() {
+	for _, key := range []string{"", "../escape", t.prefix + "../escape", "/absolute", t.prefix + "a//b", t.prefix + "sp ace"} {
+		if err := t.store.Put(t.ctx, key, bytes.NewReader([]byte("x"))); !errors.Is(err, blobstore.ErrInvalidKey) {
+			t.errorf("Put(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := t.store.Get(t.ctx, key); !errors.Is(err, blobstore.ErrInvalidKey) {
+			t.errorf("Get(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func (t *tester) testStatHas() {
+	data := randomBytes(4096)
+	key, ok := t.put("stat", data)
+	if !ok {
+		return
+	}
+
+	info, err := t.store.Stat(t.ctx, key)
+	if err != nil {
+		t.errorf("Stat(%s): %v", key, err)
+	} else {
+		if info.Key != key {
+			t.errorf("Stat(%s) returned key %q", key, info.Key)
+		}
+		if info.Size != int64(len(data)) {
+			t.errorf("Stat(%s) returned size %d, want %d", key, info.Size, len(data))
+		}
+		if info.ModTime.IsZero() {
+			t.errorf("Stat(%s) returned no modification time", key)
+		}
+	}
+
+	if has, err := t.store.Has(t.ctx, key); err != nil || !has {
+		t.errorf("Has(%s) returned %t, %v, want true, nil", key, has, err)
+	}
+}
+
+func (t *tester) testGetRange() {
+	data := randomBytes(1 << 20)
+	key, ok := t.put("range", data)
+	if !ok {
+		return
+	}
+
+	size := int64(len(data))
+	for _, r := range []struct{ offset, length, want int64 }{
+		{0, 1, 1},
+		{0, size, size},
+		{12345, 4096, 4096},
+		{size - 1, 1, 1},
+		{size - 10, 100, 10}, // Cut short at the end
+	} {
+		rc, err := t.store.GetRange(t.ctx, key, r.offset, r.length)
+		got, ok := t.read(key, rc, err)
+		if !ok {
+			continue
+		}
+		if !bytes.Equal(got, data[r.offset:r.offset+r.want]) {
+			t.errorf("GetRange(%s, %d, %d) returned %d bytes that do not match the stored range",
+				key, r.offset, r.length, len(got))
+		}
+	}
+
+	for _, r := range []struct{ offset, length int64 }{
+		{size, 1},
+		{size + 100, 1},
+		{-1, 10},
+		{0, 0},
+	} {
+		rc, err := t.store.GetRange(t.ctx, key, r.offset, r.length)
+		if err == nil {
+			rc.Close()
+		}
+		if !errors.Is(err, blobstore.ErrInvalidRange) {
+			t.errorf("GetRange(%s, %d, %d) returned %v, want ErrInvalidRange", key, r.offset, r.length, err)
+		}
+	}
+}
+
+func (t *tester) testList() {
+	var want []string
+	for _, name := range []string{"list/a", "list/b", "list/sub/c", "listing"} {
+		key, ok := t.put(name, []byte(name))
+		if !ok {
+			return
+		}
+		if name != "listing" {
+			want = append(want, key)
+		}
+	}
+
+	prefix := t.prefix + "list/"
+	var got []string
+	for info, err := range t.store.List(t.ctx, prefix) {
+		if err != nil {
+			t.errorf("List(%s): %v", prefix, err)
+			return
+		}
+		got = append(got, info.Key)
+		if info.Size != int64(len(info.Key)-len(t.prefix)) {
+			t.errorf("List(%s) reported size %d for %s", prefix, info.Size, info.Key)
+		}
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.errorf("List(%s) returned %v, want %v", prefix, got, want)
+	}
+
+	// Partial key prefixes match too, not just directory-like ones
+	partial := t.prefix + "list"
+	count := 0
+	for _, err := range t.store.List(t.ctx, partial) {
+		if err != nil {
+			t.errorf("List(%s): %v", partial, err)
+			return
+		}
+		count++
+	}
+	if count != len(want)+1 {
+		t.errorf("List(%s) returned %d keys, want %d", partial, count, len(want)+1)
+	}
+
+	// Breaking out of the loop must stop the iterator cleanly
+	for range t.store.List(t.ctx, prefix) {
+		break
+	}
+}
+
+func (t *tester) testDelete() {
+	key, ok := t.put("delete", []byte("gone soon"))
+	if !ok {
+		return
+	}
+	if err := t.store.Delete(t.ctx, key); err != nil {
+		t.errorf("Delete(%s): %v", key, err)
+		return
+	}
+	if has, err := t.store.Has(t.ctx, key); err != nil || has {
+		t.errorf("Has(%s) after Delete returned %t, %v, want false, nil", key, has, err)
+	}
+	if _, err := t.store.Get(t.ctx, key); !isNotFound(err) {
+		t.errorf("Get(%s) after Delete returned %v, want an *NotFoundError", key, err)
+	}
+}
+
+// cleanup removes every key the run stored
+func (t *tester) cleanup() {
+	for _, key := range t.keys {
+		if err := t.store.Delete(t.ctx, key); err != nil && !isNotFound(err) {
+			t.errorf("cleanup Delete(%s): %v", key, err)
+		}
+	}
+}
+
+// isNotFound reports whether err is a *NotFoundError that also matches both sentinels
+func isNotFound(err error) bool {
+	var notFound *blobstore.NotFoundError
+	return errors.As(err, &notFound) && errors.Is(err, blobstore.ErrBlobNotFound) && errors.Is(err, os.ErrNotExist)
+}
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.Read(data)
+	return data
+}
+
+func randomHex(n int) string {
+	return fmt.Sprintf("%x", randomBytes(n))
+}
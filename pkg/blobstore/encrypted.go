@@ -0,0 +1,821 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/keylock"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Encrypted blobs are a fixed size header followed by the plaintext in chunkSize chunks, each
+// sealed with AES-256-GCM. A chunk's nonce is the header's random prefix, the chunk index and
+// a flag marking the final chunk, so chunks cannot be reordered, dropped or truncated without
+// failing authentication. Fixed size chunks let a ranged read decrypt only the chunks it
+// covers, and let the plaintext size be derived from the stored size.
+const (
+	encMagic       = "PDPE"
+	encVersion     = 1
+	chunkSize      = 64 << 10
+	tagSize        = 16
+	sealedChunk    = chunkSize + tagSize
+	noncePrefixLen = 7
+	headerLen      = len(encMagic) + 1 + 1 + maxKeyIDLength + noncePrefixLen
+)
+
+var (
+	// ErrDecryptFailed is returned when stored data does not authenticate under its key
+	ErrDecryptFailed = errors.New("blob failed to decrypt")
+
+	// ErrRotationInProgress is returned when a re-encryption pass is requested while one is running
+	ErrRotationInProgress = errors.New("a re-encryption pass is already in progress")
+)
+
+// EncryptedBlobstore encrypts blobs at rest in chunked AES-GCM on top of another Blobstore.
+// Callers see plaintext, so CommP and proofs are computed exactly as without encryption.
+// A background pass re-encrypts blobs still under an old key after rotation, and encrypts
+// blobs written before encryption was enabled. Whether each blob is encrypted is recorded in
+// the database, so only blobs known to predate encryption are ever served as stored.
+type EncryptedBlobstore struct {
+	store    Blobstore
+	keys     KeyProvider
+	db       *gorm.DB
+	interval time.Duration
+	locks    keylock.Locks // Serialize writes, deletes and re-encryption per key
+
+	running  sync.Mutex
+	mutex    sync.RWMutex // Guards status
+	status   EncryptionStatus
+	cancel   context.CancelFunc
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// EncryptionStatus describes the re-encryption pass
+type EncryptionStatus struct {
+	CurrentKey     string         `json:"current_key"`
+	Running        bool           `json:"running"`
+	PassStartedAt  *time.Time     `json:"pass_started_at,omitempty"`
+	PassFinishedAt *time.Time     `json:"pass_finished_at,omitempty"`
+	Reencrypted    int            `json:"reencrypted"`  // In the current or last pass
+	Failed         int            `json:"failed"`       // In the current or last pass
+	BlobsByKey     map[string]int `json:"blobs_by_key"` // Seen in the current or last pass; "unencrypted" for blobs stored in the clear
+}
+
+// NewEncryptedBlobstore wraps store so everything written is encrypted with keys from keys.
+// Blobs already recorded in db but not yet as encrypted were written before encryption was
+// enabled, and are recorded as stored in the clear.
+func NewEncryptedBlobstore(ctx context.Context, store Blobstore, keys KeyProvider, db *gorm.DB, interval time.Duration) (*EncryptedBlobstore, error) {
+	e := &EncryptedBlobstore{
+		store:    store,
+		keys:     keys,
+		db:       db,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+	if err := e.adopt(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Start begins periodic re-encryption of blobs not under the current key
+func (e *EncryptedBlobstore) Start(ctx context.Context) error {
+	current, _, err := e.keys.Current()
+	if err != nil {
+		return fmt.Errorf("failed to load current key: %w", err)
+	}
+	log.Printf("Starting at-rest encryption with key %s (re-encryption interval %s)...", current, e.interval)
+
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.wg.Add(1)
+	go e.run(ctx)
+
+	return nil
+}
+
+// Stop stops re-encryption, abandoning any pass in progress
+func (e *EncryptedBlobstore) Stop() error {
+	close(e.stopChan)
+	if e.cancel != nil {
+		e.cancel()
+	}
+	e.wg.Wait()
+	log.Printf("At-rest encryption stopped")
+	return nil
+}
+
+// Rotate moves to a new current key and starts re-encrypting existing blobs under it
+func (e *EncryptedBlobstore) Rotate() error {
+	if err := e.keys.Rotate(); err != nil {
+		return fmt.Errorf("failed to rotate key: %w", err)
+	}
+	current, _, _ := e.keys.Current()
+	log.Printf("Rotated encryption key to %s", current)
+
+	if err := e.Trigger(); err != nil && !errors.Is(err, ErrRotationInProgress) {
+		return err
+	}
+	return nil
+}
+
+// Trigger starts a re-encryption pass in the background without waiting for the next tick
+func (e *EncryptedBlobstore) Trigger() error {
+	if !e.running.TryLock() {
+		return ErrRotationInProgress
+	}
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		defer e.running.Unlock()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-e.stopChan:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		e.pass(ctx)
+	}()
+
+	return nil
+}
+
+// GetStatus returns the re-encryption progress
+func (e *EncryptedBlobstore) GetStatus() EncryptionStatus {
+	e.mutex.RLock()
+	status := e.status
+	status.BlobsByKey = make(map[string]int, len(e.status.BlobsByKey))
+	for id, n := range e.status.BlobsByKey {
+		status.BlobsByKey[id] = n
+	}
+	e.mutex.RUnlock()
+
+	status.CurrentKey, _, _ = e.keys.Current()
+	return status
+}
+
+// Put encrypts data under the current key as it streams to the underlying store
+func (e *EncryptedBlobstore) Put(ctx context.Context, key string, data io.Reader) error {
+	lock := e.locks.Get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return e.put(ctx, key, data)
+}
+
+// Get decrypts a blob as it is read
+func (e *EncryptedBlobstore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := e.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := e.encrypted(ctx, key)
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+
+	header := make([]byte, headerLen)
+	n, err := io.ReadFull(obj, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		obj.Close()
+		return nil, err
+	}
+	h, ok := parseHeader(header[:n])
+	if !ok {
+		if encrypted {
+			obj.Close()
+			return nil, fmt.Errorf("%w: %s has no encryption header", ErrDecryptFailed, key)
+		}
+		// Written before encryption was enabled; served as is until re-encrypted
+		return &sectionReadCloser{Reader: io.MultiReader(bytes.NewReader(header[:n]), obj), Closer: obj}, nil
+	}
+
+	aead, err := e.aead(h.keyID)
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return &sectionReadCloser{
+		Reader: &decryptReader{src: obj, aead: aead, header: h, total: -1, remaining: -1},
+		Closer: obj,
+	}, nil
+}
+
+// GetRange reads and decrypts only the chunks covering the range
+func (e *EncryptedBlobstore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length <= 0 {
+		return nil, fmt.Errorf("%w: offset %d, length %d", ErrInvalidRange, offset, length)
+	}
+
+	h, stored, err := e.header(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return e.store.GetRange(ctx, key, offset, length)
+	}
+
+	size, chunks := plaintextSize(stored)
+	if offset >= size {
+		return nil, fmt.Errorf("%w: offset %d, size %d", ErrInvalidRange, offset, size)
+	}
+	if offset+length > size {
+		length = size - offset
+	}
+
+	first := offset / chunkSize
+	last := (offset + length - 1) / chunkSize
+	obj, err := e.store.GetRange(ctx, key, int64(headerLen)+first*sealedChunk, (last-first+1)*sealedChunk)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := e.aead(h.keyID)
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return &sectionReadCloser{
+		Reader: &decryptReader{
+			src:       obj,
+			aead:      aead,
+			header:    h,
+			index:     uint32(first),
+			total:     chunks,
+			skip:      offset - first*chunkSize,
+			remaining: length,
+		},
+		Closer: obj,
+	}, nil
+}
+
+// Stat reports the plaintext size
+func (e *EncryptedBlobstore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	info, err := e.store.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := e.header(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if h != nil {
+		info.Size, _ = plaintextSize(info.Size)
+	}
+	return info, nil
+}
+
+// Has reports whether a blob is stored
+func (e *EncryptedBlobstore) Has(ctx context.Context, key string) (bool, error) {
+	return e.store.Has(ctx, key)
+}
+
+// List lists the underlying store, deriving plaintext sizes from stored sizes without reading
+// headers. Sizes of blobs not yet encrypted are only approximate until they are.
+func (e *EncryptedBlobstore) List(ctx context.Context, prefix string) iter.Seq2[BlobInfo, error] {
+	return func(yield func(BlobInfo, error) bool) {
+		for info, err := range e.store.List(ctx, prefix) {
+			if err == nil && info.Size >= int64(headerLen+tagSize) {
+				info.Size, _ = plaintextSize(info.Size)
+			}
+			if !yield(info, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Delete removes a blob
+func (e *EncryptedBlobstore) Delete(ctx context.Context, key string) error {
+	lock := e.locks.Get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := e.store.Delete(ctx, key); err != nil {
+		return err
+	}
+	if err := e.db.WithContext(ctx).Where("blob_key = ?", key).Delete(&models.BlobEncryption{}).Error; err != nil {
+		return fmt.Errorf("failed to delete blob encryption record: %w", err)
+	}
+	return nil
+}
+
+// run starts a pass on every tick until stopped
+func (e *EncryptedBlobstore) run(ctx context.Context) {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C:
+			if !e.running.TryLock() {
+				continue
+			}
+			e.pass(ctx)
+			e.running.Unlock()
+		}
+	}
+}
+
+// pass re-encrypts every blob that is not under the current key
+func (e *EncryptedBlobstore) pass(ctx context.Context) {
+	current, _, err := e.keys.Current()
+	if err != nil {
+		log.Printf("Error loading current encryption key: %v", err)
+		return
+	}
+
+	started := time.Now()
+	e.mutex.Lock()
+	e.status = EncryptionStatus{Running: true, PassStartedAt: &started, BlobsByKey: make(map[string]int)}
+	e.mutex.Unlock()
+
+	for info, err := range e.store.List(ctx, "") {
+		if err != nil {
+			log.Printf("Error listing blobs to re-encrypt: %v", err)
+			break
+		}
+
+		keyID, err := e.reencrypt(ctx, info.Key, current)
+		if ctx.Err() != nil {
+			break
+		}
+		if keyID == "" {
+			keyID = "unencrypted"
+		}
+		e.mutex.Lock()
+		if err != nil {
+			log.Printf("Error re-encrypting blob %s: %v", info.Key, err)
+			e.status.Failed++
+			e.status.BlobsByKey[keyID]++
+		} else {
+			if keyID != current {
+				e.status.Reencrypted++
+			}
+			e.status.BlobsByKey[current]++
+		}
+		e.mutex.Unlock()
+	}
+
+	finished := time.Now()
+	e.mutex.Lock()
+	e.status.Running = false
+	e.status.PassFinishedAt = &finished
+	reencrypted, failed := e.status.Reencrypted, e.status.Failed
+	e.mutex.Unlock()
+
+	if reencrypted > 0 || failed > 0 {
+		log.Printf("Re-encryption pass moved %d blobs to key %s in %s, %d failed",
+			reencrypted, current, finished.Sub(started).Round(time.Second), failed)
+	}
+}
+
+// reencrypt rewrites a blob under the current key unless it already is, returning the key it
+// was found under; "" means it was stored unencrypted
+func (e *EncryptedBlobstore) reencrypt(ctx context.Context, key, current string) (string, error) {
+	lock := e.locks.Get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	h, _, err := e.header(ctx, key)
+	if errors.Is(err, ErrBlobNotFound) {
+		return current, nil // Deleted since it was listed
+	}
+	if err != nil {
+		return "", err
+	}
+	keyID := ""
+	if h != nil {
+		keyID = h.keyID
+	}
+	if keyID == current {
+		// Re-encrypted before its state was recorded
+		return keyID, e.record(ctx, key, true)
+	}
+
+	obj, err := e.Get(ctx, key)
+	if err != nil {
+		return keyID, err
+	}
+	defer obj.Close()
+	if err := e.put(ctx, key, obj); err != nil {
+		return keyID, err
+	}
+	return keyID, nil
+}
+
+// put encrypts data under the current key; the caller holds the key lock
+func (e *EncryptedBlobstore) put(ctx context.Context, key string, data io.Reader) error {
+	keyID, keyBytes, err := e.keys.Current()
+	if err != nil {
+		return fmt.Errorf("failed to load current key: %w", err)
+	}
+	aead, err := newAEAD(keyBytes)
+	if err != nil {
+		return err
+	}
+
+	h := &encHeader{keyID: keyID}
+	if _, err := rand.Read(h.prefix[:]); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// A new blob is recorded as encrypted before it can be read. A blob stored in the clear
+	// keeps its record until its encrypted copy has replaced it, and is read by its header
+	// in between.
+	if err := e.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.BlobEncryption{Key: key, Encrypted: true}).Error; err != nil {
+		return fmt.Errorf("failed to record blob encryption: %w", err)
+	}
+	if err := e.store.Put(ctx, key, newEncryptReader(data, aead, h)); err != nil {
+		return err
+	}
+	return e.record(ctx, key, true)
+}
+
+// record sets whether a blob is stored encrypted
+func (e *EncryptedBlobstore) record(ctx context.Context, key string, encrypted bool) error {
+	err := e.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "blob_key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"encrypted", "updated_at"}),
+		}).
+		Create(&models.BlobEncryption{Key: key, Encrypted: encrypted}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record blob encryption: %w", err)
+	}
+	return nil
+}
+
+// encrypted reports whether a blob is recorded as stored encrypted. A blob with no record was
+// not written through this store and is refused.
+func (e *EncryptedBlobstore) encrypted(ctx context.Context, key string) (bool, error) {
+	var state models.BlobEncryption
+	err := e.db.WithContext(ctx).Where("blob_key = ?", key).First(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, fmt.Errorf("%w: %s has no recorded encryption state", ErrDecryptFailed, key)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up blob encryption: %w", err)
+	}
+	return state.Encrypted, nil
+}
+
+// adopt records blobs written before encryption was enabled as stored in the clear. Every
+// blob written through this store is recorded as encrypted before the blob itself, and
+// models.Blob only after it, so a blob with a models.Blob record and no encryption record
+// was written without encryption.
+func (e *EncryptedBlobstore) adopt(ctx context.Context) error {
+	var keys []string
+	err := e.db.WithContext(ctx).Model(&models.Blob{}).
+		Where("blob_key NOT IN (?)", e.db.Model(&models.BlobEncryption{}).Select("blob_key")).
+		Pluck("blob_key", &keys).Error
+	if err != nil {
+		return fmt.Errorf("failed to find unencrypted blobs: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	states := make([]models.BlobEncryption, len(keys))
+	for i, key := range keys {
+		states[i] = models.BlobEncryption{Key: key}
+	}
+	if err := e.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		CreateInBatches(states, 500).Error; err != nil {
+		return fmt.Errorf("failed to record unencrypted blobs: %w", err)
+	}
+	log.Printf("Recorded %d blobs written before encryption was enabled; they will be re-encrypted", len(keys))
+	return nil
+}
+
+// header reads and parses a blob's header, returning nil for blobs stored unencrypted along
+// with the stored size. A blob recorded as encrypted without a header fails to decrypt.
+func (e *EncryptedBlobstore) header(ctx context.Context, key string) (*encHeader, int64, error) {
+	info, err := e.store.Stat(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	encrypted, err := e.encrypted(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.Size < int64(headerLen+tagSize) {
+		if encrypted {
+			return nil, 0, fmt.Errorf("%w: %s is too short to be encrypted", ErrDecryptFailed, key)
+		}
+		return nil, info.Size, nil
+	}
+
+	obj, err := e.store.GetRange(ctx, key, 0, int64(headerLen))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer obj.Close()
+	buf := make([]byte, headerLen)
+	if _, err := io.ReadFull(obj, buf); err != nil {
+		return nil, 0, fmt.Errorf("failed to read header: %w", err)
+	}
+	h, ok := parseHeader(buf)
+	if !ok {
+		if encrypted {
+			return nil, 0, fmt.Errorf("%w: %s has no encryption header", ErrDecryptFailed, key)
+		}
+		return nil, info.Size, nil
+	}
+	return h, info.Size, nil
+}
+
+// aead returns the cipher for a key ID
+func (e *EncryptedBlobstore) aead(keyID string) (cipher.AEAD, error) {
+	keyBytes, err := e.keys.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(keyBytes)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encHeader identifies the key and nonce prefix a blob was sealed with
+type encHeader struct {
+	keyID  string
+	prefix [noncePrefixLen]byte
+	raw    []byte // Authenticated as additional data with every chunk
+}
+
+func (h *encHeader) marshal() []byte {
+	if h.raw == nil {
+		buf := make([]byte, 0, headerLen)
+		buf = append(buf, encMagic...)
+		buf = append(buf, encVersion, byte(len(h.keyID)))
+		id := make([]byte, maxKeyIDLength)
+		copy(id, h.keyID)
+		buf = append(buf, id...)
+		buf = append(buf, h.prefix[:]...)
+		h.raw = buf
+	}
+	return h.raw
+}
+
+// parseHeader parses a header, reporting false for data that is not an encrypted blob
+func parseHeader(buf []byte) (*encHeader, bool) {
+	if len(buf) < headerLen || string(buf[:len(encMagic)]) != encMagic || buf[len(encMagic)] != encVersion {
+		return nil, false
+	}
+	idLen := int(buf[len(encMagic)+1])
+	if idLen == 0 || idLen > maxKeyIDLength {
+		return nil, false
+	}
+	idStart := len(encMagic) + 2
+	h := &encHeader{
+		keyID: string(buf[idStart : idStart+idLen]),
+		raw:   append([]byte(nil), buf[:headerLen]...),
+	}
+	copy(h.prefix[:], buf[idStart+maxKeyIDLength:])
+	return h, true
+}
+
+// nonce builds the nonce for a chunk
+func (h *encHeader) nonce(index uint32, final bool) []byte {
+	nonce := make([]byte, noncePrefixLen+5)
+	copy(nonce, h.prefix[:])
+	binary.BigEndian.PutUint32(nonce[noncePrefixLen:], index)
+	if final {
+		nonce[noncePrefixLen+4] = 1
+	}
+	return nonce
+}
+
+// plaintextSize derives the plaintext size and chunk count from the stored size of an encrypted blob
+func plaintextSize(stored int64) (int64, int64) {
+	body := stored - int64(headerLen)
+	chunks := (body + sealedChunk - 1) / sealedChunk
+	return body - chunks*tagSize, chunks
+}
+
+// encryptReader emits the header and then each sealed chunk, reading one chunk ahead so the
+// final chunk can be flagged
+type encryptReader struct {
+	src      io.Reader
+	aead     cipher.AEAD
+	header   *encHeader
+	cur      []byte
+	next     []byte
+	curLen   int
+	curShort bool
+	index    uint32
+	started  bool
+	finished bool
+	out      []byte
+	sealed   []byte
+	err      error
+}
+
+func newEncryptReader(src io.Reader, aead cipher.AEAD, header *encHeader) *encryptReader {
+	return &encryptReader{
+		src:    src,
+		aead:   aead,
+		header: header,
+		cur:    make([]byte, chunkSize),
+		next:   make([]byte, chunkSize),
+		sealed: make([]byte, 0, sealedChunk),
+		out:    header.marshal(),
+	}
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.sealNext()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *encryptReader) sealNext() error {
+	if r.finished {
+		return io.EOF
+	}
+	if !r.started {
+		n, short, err := readChunk(r.src, r.cur)
+		if err != nil {
+			return err
+		}
+		r.curLen, r.curShort, r.started = n, short, true
+	}
+
+	final := r.curShort
+	nextLen, nextShort := 0, false
+	if !final {
+		var err error
+		nextLen, nextShort, err = readChunk(r.src, r.next)
+		if err != nil {
+			return err
+		}
+		final = nextLen == 0
+	}
+
+	r.out = r.aead.Seal(r.sealed[:0], r.header.nonce(r.index, final), r.cur[:r.curLen], r.header.marshal())
+	if final {
+		r.finished = true
+		return nil
+	}
+	if r.index == ^uint32(0) {
+		return fmt.Errorf("blob too large to encrypt")
+	}
+	r.index++
+	r.cur, r.next = r.next, r.cur
+	r.curLen, r.curShort = nextLen, nextShort
+	return nil
+}
+
+// decryptReader opens sealed chunks starting at index, skipping and limiting plaintext to the
+// requested range. With total unknown (-1) the final chunk is found by reading one ahead.
+type decryptReader struct {
+	src       io.Reader
+	aead      cipher.AEAD
+	header    *encHeader
+	index     uint32
+	total     int64
+	skip      int64
+	remaining int64 // -1 is unlimited
+	cur       []byte
+	next      []byte
+	curLen    int
+	curShort  bool
+	started   bool
+	finished  bool
+	out       []byte
+	err       error
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.openNext()
+	}
+	if r.remaining >= 0 && int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	if r.remaining >= 0 {
+		r.remaining -= int64(n)
+		if r.remaining == 0 {
+			r.out, r.err = nil, io.EOF
+		}
+	}
+	return n, nil
+}
+
+func (r *decryptReader) openNext() error {
+	if r.finished || r.remaining == 0 {
+		return io.EOF
+	}
+	if r.cur == nil {
+		r.cur = make([]byte, sealedChunk)
+		r.next = make([]byte, sealedChunk)
+	}
+	if !r.started {
+		n, short, err := readChunk(r.src, r.cur)
+		if err != nil {
+			return err
+		}
+		r.curLen, r.curShort, r.started = n, short, true
+	}
+
+	var final bool
+	nextLen, nextShort := 0, false
+	if r.total >= 0 {
+		final = int64(r.index) == r.total-1
+	} else {
+		final = r.curShort
+		if !final {
+			var err error
+			nextLen, nextShort, err = readChunk(r.src, r.next)
+			if err != nil {
+				return err
+			}
+			final = nextLen == 0
+		}
+	}
+	if r.curLen < tagSize {
+		return fmt.Errorf("%w: chunk %d is truncated", ErrDecryptFailed, r.index)
+	}
+
+	plain, err := r.aead.Open(r.cur[:0], r.header.nonce(r.index, final), r.cur[:r.curLen], r.header.marshal())
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d: %v", ErrDecryptFailed, r.index, err)
+	}
+	if r.skip > 0 {
+		plain = plain[r.skip:]
+		r.skip = 0
+	}
+	r.out = plain
+
+	if final {
+		r.finished = true
+		return nil
+	}
+	r.index++
+	if r.total >= 0 {
+		// Ranged reads know the chunk count, so there is no lookahead to swap in
+		n, short, err := readChunk(r.src, r.next)
+		if err != nil {
+			return err
+		}
+		nextLen, nextShort = n, short
+		if n == 0 {
+			r.finished = true // The range ended before the final chunk
+		}
+	}
+	r.cur, r.next = r.next, r.cur
+	r.curLen, r.curShort = nextLen, nextShort
+	return nil
+}
+
+// readChunk fills buf from r, reporting a short read at the end of the stream rather than an error
+func readChunk(r io.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, true, nil
+	}
+	return n, false, err
+}
@@ -0,0 +1,282 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Plaintext is sealed in chunks of this size
+const encChunk = 64 << 10
+
+type encryptedFixture struct {
+	store *blobstore.EncryptedBlobstore
+	under *blobstore.FileBlobstore
+	keys  *blobstore.LocalKMS
+	db    *gorm.DB
+}
+
+func newEncryptedFixture(t *testing.T, seed func(under *blobstore.FileBlobstore, db *gorm.DB)) *encryptedFixture {
+	t.Helper()
+	keys, err := blobstore.OpenLocalKMS(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenLocalKMS: %v", err)
+	}
+	f := &encryptedFixture{under: newFileBlobstore(t), keys: keys, db: newTestDB(t)}
+	if seed != nil {
+		seed(f.under, f.db)
+	}
+	f.store, err = blobstore.NewEncryptedBlobstore(context.Background(), f.under, keys, f.db, time.Hour)
+	if err != nil {
+		t.Fatalf("NewEncryptedBlobstore: %v", err)
+	}
+	t.Cleanup(func() { f.store.Stop() })
+	return f
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("rand: %v", err)
+	}
+	return data
+}
+
+// reader returns a function reading a whole blob straight from a Get or GetRange
+func reader(t *testing.T) func(io.ReadCloser, error) []byte {
+	return func(rc io.ReadCloser, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return data
+	}
+}
+
+// waitForPass waits for a re-encryption pass started after since to finish
+func waitForPass(t *testing.T, store *blobstore.EncryptedBlobstore, since time.Time) blobstore.EncryptionStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		status := store.GetStatus()
+		if !status.Running && status.PassFinishedAt != nil && !status.PassStartedAt.Before(since) {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("re-encryption pass did not finish")
+	return blobstore.EncryptionStatus{}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	f := newEncryptedFixture(t, nil)
+
+	sizes := map[string]int{
+		"empty":            0,
+		"one-byte":         1,
+		"short-of-a-chunk": encChunk - 1,
+		"one-chunk":        encChunk,
+		"three-chunks":     3 * encChunk,
+		"partial-last":     3*encChunk + 5,
+	}
+	for name, size := range sizes {
+		t.Run(name, func(t *testing.T) {
+			readAll := reader(t)
+			key := "round-trip-" + name
+			data := randomBytes(t, size)
+			if err := f.store.Put(ctx, key, bytes.NewReader(data)); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			if got := readAll(f.store.Get(ctx, key)); !bytes.Equal(got, data) {
+				t.Fatalf("Get returned %d bytes, want the %d written", len(got), size)
+			}
+			info, err := f.store.Stat(ctx, key)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if info.Size != int64(size) {
+				t.Fatalf("Stat size = %d, want %d", info.Size, size)
+			}
+
+			stored := readAll(f.under.Get(ctx, key))
+			// Short plaintexts turn up in random ciphertext by chance
+			if len(stored) <= size || (size >= 16 && bytes.Contains(stored, data)) {
+				t.Fatalf("stored %d bytes that do not look encrypted", len(stored))
+			}
+		})
+	}
+}
+
+func TestEncryptedGetRange(t *testing.T) {
+	ctx := context.Background()
+	f := newEncryptedFixture(t, nil)
+
+	data := randomBytes(t, 4*encChunk+100)
+	if err := f.store.Put(ctx, "ranged", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	ranges := []struct {
+		name           string
+		offset, length int64
+	}{
+		{"first byte", 0, 1},
+		{"across a chunk boundary", encChunk - 10, 20},
+		{"across several chunks", encChunk / 2, 2 * encChunk},
+		{"exactly one chunk", encChunk, encChunk},
+		{"last chunk", 4 * encChunk, 100},
+		{"past the end", int64(len(data)) - 50, 100},
+	}
+	for _, r := range ranges {
+		t.Run(r.name, func(t *testing.T) {
+			readAll := reader(t)
+			want := data[r.offset:min(r.offset+r.length, int64(len(data)))]
+			if got := readAll(f.store.GetRange(ctx, "ranged", r.offset, r.length)); !bytes.Equal(got, want) {
+				t.Fatalf("GetRange(%d, %d) returned %d bytes, want %d", r.offset, r.length, len(got), len(want))
+			}
+		})
+	}
+
+	if _, err := f.store.GetRange(ctx, "ranged", int64(len(data)), 1); !errors.Is(err, blobstore.ErrInvalidRange) {
+		t.Fatalf("GetRange past the end: err = %v, want ErrInvalidRange", err)
+	}
+}
+
+func TestEncryptedRotation(t *testing.T) {
+	ctx := context.Background()
+	readAll := reader(t)
+	f := newEncryptedFixture(t, nil)
+
+	blobs := map[string][]byte{
+		"a": randomBytes(t, 10),
+		"b": randomBytes(t, 2*encChunk),
+		"c": {},
+	}
+	for key, data := range blobs {
+		if err := f.store.Put(ctx, key, bytes.NewReader(data)); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	oldKey, _, _ := f.keys.Current()
+
+	started := time.Now()
+	if err := f.store.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	status := waitForPass(t, f.store, started)
+	newKey, _, _ := f.keys.Current()
+	if newKey == oldKey {
+		t.Fatal("Rotate did not change the current key")
+	}
+	if status.Reencrypted != len(blobs) || status.Failed != 0 || status.BlobsByKey[newKey] != len(blobs) {
+		t.Fatalf("status = %+v, want all %d blobs re-encrypted under %s", status, len(blobs), newKey)
+	}
+
+	for key, data := range blobs {
+		stored := readAll(f.under.Get(ctx, key))
+		if !bytes.Contains(stored[:min(len(stored), 128)], []byte(newKey)) {
+			t.Fatalf("%s is not stored under the new key", key)
+		}
+		if got := readAll(f.store.Get(ctx, key)); !bytes.Equal(got, data) {
+			t.Fatalf("%s changed across rotation", key)
+		}
+	}
+}
+
+func TestEncryptedRefusesStrippedHeader(t *testing.T) {
+	ctx := context.Background()
+	readAll := reader(t)
+	f := newEncryptedFixture(t, nil)
+
+	plaintext := randomBytes(t, 2*encChunk)
+	if err := f.store.Put(ctx, "tampered", bytes.NewReader(randomBytes(t, 1000))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// Replace the ciphertext with plaintext behind the encrypted store's back
+	if err := f.under.Put(ctx, "tampered", bytes.NewReader(plaintext)); err != nil {
+		t.Fatalf("Put underlying: %v", err)
+	}
+	// And store a blob that was never written through it
+	if err := f.under.Put(ctx, "planted", bytes.NewReader(plaintext)); err != nil {
+		t.Fatalf("Put underlying: %v", err)
+	}
+
+	for _, key := range []string{"tampered", "planted"} {
+		if _, err := f.store.Get(ctx, key); !errors.Is(err, blobstore.ErrDecryptFailed) {
+			t.Fatalf("Get %s: err = %v, want ErrDecryptFailed", key, err)
+		}
+		if _, err := f.store.GetRange(ctx, key, 0, 10); !errors.Is(err, blobstore.ErrDecryptFailed) {
+			t.Fatalf("GetRange %s: err = %v, want ErrDecryptFailed", key, err)
+		}
+		if _, err := f.store.Stat(ctx, key); !errors.Is(err, blobstore.ErrDecryptFailed) {
+			t.Fatalf("Stat %s: err = %v, want ErrDecryptFailed", key, err)
+		}
+	}
+
+	started := time.Now()
+	if err := f.store.Trigger(); err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	if status := waitForPass(t, f.store, started); status.Failed != 2 || status.Reencrypted != 0 {
+		t.Fatalf("status = %+v, want both blobs failed", status)
+	}
+	if stored := readAll(f.under.Get(ctx, "tampered")); !bytes.Equal(stored, plaintext) {
+		t.Fatal("re-encryption sealed a blob it could not decrypt")
+	}
+}
+
+func TestEncryptedAdoptsBlobsWrittenBefore(t *testing.T) {
+	ctx := context.Background()
+	readAll := reader(t)
+	plaintext := randomBytes(t, encChunk+7)
+	f := newEncryptedFixture(t, func(under *blobstore.FileBlobstore, db *gorm.DB) {
+		if err := under.Put(ctx, "legacy", bytes.NewReader(plaintext)); err != nil {
+			t.Fatalf("Put underlying: %v", err)
+		}
+		if err := db.Create(&models.Blob{Key: "legacy", Size: int64(len(plaintext))}).Error; err != nil {
+			t.Fatalf("record blob: %v", err)
+		}
+	})
+
+	if got := readAll(f.store.Get(ctx, "legacy")); !bytes.Equal(got, plaintext) {
+		t.Fatal("a blob written before encryption was not served as stored")
+	}
+
+	started := time.Now()
+	if err := f.store.Trigger(); err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	if status := waitForPass(t, f.store, started); status.Reencrypted != 1 || status.Failed != 0 {
+		t.Fatalf("status = %+v, want the blob re-encrypted", status)
+	}
+	if stored := readAll(f.under.Get(ctx, "legacy")); bytes.Equal(stored, plaintext) {
+		t.Fatal("the blob is still stored in the clear")
+	}
+	if got := readAll(f.store.Get(ctx, "legacy")); !bytes.Equal(got, plaintext) {
+		t.Fatal("the re-encrypted blob does not read back")
+	}
+
+	// Now recorded as encrypted, so stripping its header again is caught
+	if err := f.under.Put(ctx, "legacy", bytes.NewReader(plaintext)); err != nil {
+		t.Fatalf("Put underlying: %v", err)
+	}
+	if _, err := f.store.Get(ctx, "legacy"); !errors.Is(err, blobstore.ErrDecryptFailed) {
+		t.Fatalf("Get: err = %v, want ErrDecryptFailed", err)
+	}
+}
@@ -0,0 +1,247 @@
+package blobstore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keySize is the AES-256 key length
+const keySize = 32
+
+// maxKeyIDLength bounds key IDs so they fit the fixed size blob header
+const maxKeyIDLength = 32
+
+// ErrUnknownKey is returned when a blob was encrypted under a key the provider does not hold
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// KeyProvider supplies the data encryption keys for an EncryptedBlobstore. New blobs are
+// encrypted under the current key; older keys are kept so existing blobs stay readable until
+// they are re-encrypted.
+type KeyProvider interface {
+	// Current returns the ID and bytes of the key new blobs are encrypted under
+	Current() (string, []byte, error)
+
+	// Key returns a key by ID
+	Key(id string) ([]byte, error)
+
+	// Rotate makes a newer key current: a key file is re-read, a KMS creates a new key
+	Rotate() error
+}
+
+// KeyFile is a KeyProvider backed by a JSON keyring file of base64 encoded 32 byte keys:
+//
+//	{"current": "2024-08", "keys": {"2024-01": "...", "2024-08": "..."}}
+//
+// Operators rotate by adding a key, pointing current at it and calling Rotate.
+type KeyFile struct {
+	path    string
+	mutex   sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// LoadKeyFile reads a keyring file
+func LoadKeyFile(path string) (*KeyFile, error) {
+	kf := &KeyFile{path: path}
+	if err := kf.Rotate(); err != nil {
+		return nil, err
+	}
+	return kf, nil
+}
+
+// Current returns the current key
+func (kf *KeyFile) Current() (string, []byte, error) {
+	kf.mutex.RLock()
+	defer kf.mutex.RUnlock()
+	return kf.current, kf.keys[kf.current], nil
+}
+
+// Key returns a key by ID
+func (kf *KeyFile) Key(id string) ([]byte, error) {
+	kf.mutex.RLock()
+	defer kf.mutex.RUnlock()
+	key, ok := kf.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// Rotate re-reads the keyring file. Keys that disappeared from it are refused, so a blob
+// can never become unreadable by editing the file.
+func (kf *KeyFile) Rotate() error {
+	data, err := os.ReadFile(kf.path)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var ring struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return fmt.Errorf("failed to parse key file: %w", err)
+	}
+
+	keys := make(map[string][]byte, len(ring.Keys))
+	for id, encoded := range ring.Keys {
+		key, err := decodeKey(id, encoded)
+		if err != nil {
+			return err
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[ring.Current]; !ok {
+		return fmt.Errorf("key file names current key %q, which it does not contain", ring.Current)
+	}
+
+	kf.mutex.Lock()
+	defer kf.mutex.Unlock()
+	for id := range kf.keys {
+		if _, ok := keys[id]; !ok {
+			return fmt.Errorf("key %q was removed from the key file", id)
+		}
+	}
+	kf.current, kf.keys = ring.Current, keys
+	return nil
+}
+
+// LocalKMS is a KeyProvider standing in for a key management service. Each key is a file in
+// its directory holding the base64 key, and a "current" file names the active one. Rotate
+// generates a new key, so rotation needs no operator-supplied key material.
+type LocalKMS struct {
+	dir     string
+	mutex   sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// OpenLocalKMS loads the keys in dir, generating a first key if there are none
+func OpenLocalKMS(dir string) (*LocalKMS, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	kms := &LocalKMS{dir: dir, keys: make(map[string][]byte)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".key")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		key, err := decodeKey(id, strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, err
+		}
+		kms.keys[id] = key
+	}
+
+	current, err := os.ReadFile(filepath.Join(dir, "current"))
+	if errors.Is(err, os.ErrNotExist) && len(kms.keys) == 0 {
+		if err := kms.Rotate(); err != nil {
+			return nil, err
+		}
+		return kms, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read current key: %w", err)
+	}
+	kms.current = strings.TrimSpace(string(current))
+	if _, ok := kms.keys[kms.current]; !ok {
+		return nil, fmt.Errorf("current key %q is missing from %s", kms.current, dir)
+	}
+	return kms, nil
+}
+
+// Current returns the current key
+func (k *LocalKMS) Current() (string, []byte, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.current, k.keys[k.current], nil
+}
+
+// Key returns a key by ID
+func (k *LocalKMS) Key(id string) ([]byte, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// Rotate generates a new key and makes it current. The key is written before the current
+// pointer moves, so a crash never leaves current naming a missing key.
+func (k *LocalKMS) Rotate() error {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+	id := "k" + time.Now().UTC().Format("20060102T150405.000000000")
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if err := writeFileSync(filepath.Join(k.dir, id+".key"), []byte(base64.StdEncoding.EncodeToString(key)+"\n")); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	if err := writeFileSync(filepath.Join(k.dir, "current"), []byte(id+"\n")); err != nil {
+		return fmt.Errorf("failed to update current key: %w", err)
+	}
+
+	k.keys[id] = key
+	k.current = id
+	return nil
+}
+
+// decodeKey checks a key ID and decodes its base64 key
+func decodeKey(id, encoded string) ([]byte, error) {
+	if id == "" || len(id) > maxKeyIDLength {
+		return nil, fmt.Errorf("key ID %q must be 1 to %d bytes", id, maxKeyIDLength)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("key %q is not valid base64: %w", id, err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key %q is %d bytes, want %d", id, len(key), keySize)
+	}
+	return key, nil
+}
+
+// writeFileSync writes a private file through a temp file and rename, syncing both
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,267 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/keylock"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of references that keep a blob alive
+const (
+	RefPiece  = "piece"  // A piece record, keyed by piece ID
+	RefUpload = "upload" // A file uploaded through /upload, keyed by its CID
+	RefRoot   = "root"   // A proof set root, keyed by proof set ID and piece ID
+	RefLegacy = "legacy" // A blob stored before blobs were reference counted, keyed by blob key
+)
+
+// ErrBlobNotFound is returned when referencing a blob that is not stored
+var ErrBlobNotFound = errors.New("blob not found")
+
+// RefStore is a content-addressed store that counts the pieces, uploads and proof set roots
+// using each blob, storing identical content once and deleting it when the last reference goes
+type RefStore struct {
+	store Blobstore
+	db    *gorm.DB
+	locks keylock.Locks // Serialize reference changes against blob writes and deletes per key
+	mutex sync.Mutex    // Serializes reference count updates
+}
+
+// NewRefStore creates a reference counted store on top of a blobstore
+func NewRefStore(store Blobstore, db *gorm.DB) *RefStore {
+	return &RefStore{
+		store: store,
+		db:    db,
+	}
+}
+
+// Put stores data under key unless the blob is already present, then records the reference.
+// Recording a reference that already exists is a no-op.
+func (r *RefStore) Put(ctx context.Context, key string, data io.Reader, kind, refID string) error {
+	lock := r.locks.Get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Recorded before it is written, so that data left by a write that never finished is
+	// tracked, and collected as unreferenced
+	blob := models.Blob{Key: key}
+	r.mutex.Lock()
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&blob)
+	r.mutex.Unlock()
+	if result.Error != nil {
+		return fmt.Errorf("failed to record blob: %w", result.Error)
+	}
+
+	if result.RowsAffected > 0 {
+		counter := &countingReader{r: data}
+		if err := r.store.Put(ctx, key, counter); err != nil {
+			if err := r.db.WithContext(ctx).Where("blob_key = ? AND ref_count = 0", key).Delete(&models.Blob{}).Error; err != nil {
+				log.Printf("Warning: failed to forget blob %s after a failed write: %v", key, err)
+			}
+			return fmt.Errorf("failed to store blob: %w", err)
+		}
+		if err := r.db.WithContext(ctx).Model(&blob).Update("size", counter.n).Error; err != nil {
+			return fmt.Errorf("failed to record blob size: %w", err)
+		}
+	}
+
+	return r.addRef(ctx, key, kind, refID)
+}
+
+// AddRef records a reference to a blob that is already stored
+func (r *RefStore) AddRef(ctx context.Context, key, kind, refID string) error {
+	lock := r.locks.Get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	var count int64
+	if err := r.db.WithContext(ctx).Model(&models.Blob{}).Where("blob_key = ?", key).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to look up blob: %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+
+	return r.addRef(ctx, key, kind, refID)
+}
+
+// Release drops a reference and deletes the blob once nothing references it
+func (r *RefStore) Release(ctx context.Context, key, kind, refID string) error {
+	lock := r.locks.Get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	deleteBlob := false
+	r.mutex.Lock()
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("blob_key = ? AND kind = ? AND ref_id = ?", key, kind, refID).Delete(&models.BlobRef{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete blob ref: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&models.Blob{}).
+			Where("blob_key = ?", key).
+			Update("ref_count", gorm.Expr("ref_count - 1")).Error; err != nil {
+			return fmt.Errorf("failed to update ref count: %w", err)
+		}
+
+		var blob models.Blob
+		if err := tx.Where("blob_key = ?", key).First(&blob).Error; err != nil {
+			return fmt.Errorf("failed to look up blob: %w", err)
+		}
+		if blob.RefCount > 0 {
+			return nil
+		}
+		if err := tx.Delete(&blob).Error; err != nil {
+			return fmt.Errorf("failed to delete blob record: %w", err)
+		}
+		deleteBlob = true
+		return nil
+	})
+	r.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if deleteBlob {
+		if err := r.store.Delete(ctx, key); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return fmt.Errorf("failed to delete blob: %w", err)
+		}
+		log.Printf("Deleted blob %s, last reference released", key)
+	}
+	return nil
+}
+
+// DeleteUnreferenced deletes a recorded blob unless something references it, reporting whether
+// it was deleted. Blobs that were never recorded are left alone, as they are not known to be
+// garbage. The check and delete happen under the key's lock so a concurrent Put cannot be lost.
+func (r *RefStore) DeleteUnreferenced(ctx context.Context, key string) (bool, error) {
+	lock := r.locks.Get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	var count int64
+	if err := r.db.WithContext(ctx).
+		Model(&models.Blob{}).
+		Where("blob_key = ? AND ref_count = 0", key).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to look up blob: %w", err)
+	}
+	if count == 0 {
+		return false, nil
+	}
+
+	if err := r.db.WithContext(ctx).Where("blob_key = ?", key).Delete(&models.Blob{}).Error; err != nil {
+		return false, fmt.Errorf("failed to delete blob record: %w", err)
+	}
+	if err := r.store.Delete(ctx, key); err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return false, fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return true, nil
+}
+
+// Get retrieves a blob by key
+func (r *RefStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return r.store.Get(ctx, key)
+}
+
+// RefCount returns the number of references to a blob, zero if it is not stored
+func (r *RefStore) RefCount(ctx context.Context, key string) (int64, error) {
+	var blob models.Blob
+	if err := r.db.WithContext(ctx).Where("blob_key = ?", key).First(&blob).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to look up blob: %w", err)
+	}
+	return blob.RefCount, nil
+}
+
+// AdoptUntracked records every blob in store that has no blob record, so that data stored
+// before blobs were reference counted is kept rather than taken for garbage. Each adopted blob
+// is held by a RefLegacy reference until its owner takes it over; blobs written since are
+// recorded before they are stored, so only such data is adopted.
+func AdoptUntracked(ctx context.Context, store Blobstore, db *gorm.DB) (int, error) {
+	var tracked []string
+	if err := db.WithContext(ctx).Model(&models.Blob{}).Pluck("blob_key", &tracked).Error; err != nil {
+		return 0, fmt.Errorf("failed to load blob records: %w", err)
+	}
+	known := make(map[string]bool, len(tracked))
+	for _, key := range tracked {
+		known[key] = true
+	}
+
+	adopted := 0
+	for info, err := range store.List(ctx, "") {
+		if err != nil {
+			return adopted, fmt.Errorf("failed to scan blobstore: %w", err)
+		}
+		if known[info.Key] {
+			continue
+		}
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&models.Blob{Key: info.Key, Size: info.Size, RefCount: 1}).Error; err != nil {
+				return err
+			}
+			return tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&models.BlobRef{BlobKey: info.Key, Kind: RefLegacy, RefID: info.Key}).Error
+		})
+		if err != nil {
+			return adopted, fmt.Errorf("failed to adopt blob %s: %w", info.Key, err)
+		}
+		adopted++
+	}
+	if adopted > 0 {
+		log.Printf("Adopted %d blobs stored before blobs were reference counted", adopted)
+	}
+	return adopted, nil
+}
+
+// addRef inserts a reference row and bumps the count when the reference is new
+func (r *RefStore) addRef(ctx context.Context, key, kind, refID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.BlobRef{
+			BlobKey: key,
+			Kind:    kind,
+			RefID:   refID,
+		})
+		if result.Error != nil {
+			return fmt.Errorf("failed to record blob ref: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		if err := tx.Model(&models.Blob{}).
+			Where("blob_key = ?", key).
+			Update("ref_count", gorm.Expr("ref_count + 1")).Error; err != nil {
+			return fmt.Errorf("failed to update ref count: %w", err)
+		}
+		return nil
+	})
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,113 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+)
+
+func TestRefStoreKeepsUntrackedBlobs(t *testing.T) {
+	ctx := context.Background()
+	store := newFileBlobstore(t)
+	db := newTestDB(t)
+	refs := blobstore.NewRefStore(store, db)
+
+	// Stored before blobs were reference counted
+	if err := store.Put(ctx, "legacy", bytes.NewReader([]byte("old data"))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if deleted, err := refs.DeleteUnreferenced(ctx, "legacy"); err != nil || deleted {
+		t.Fatalf("DeleteUnreferenced of an untracked blob = %v, %v; want it kept", deleted, err)
+	}
+
+	adopted, err := blobstore.AdoptUntracked(ctx, store, db)
+	if err != nil || adopted != 1 {
+		t.Fatalf("AdoptUntracked = %d, %v; want 1 blob adopted", adopted, err)
+	}
+	if adopted, err := blobstore.AdoptUntracked(ctx, store, db); err != nil || adopted != 0 {
+		t.Fatalf("AdoptUntracked again = %d, %v; want nothing adopted", adopted, err)
+	}
+	if count, err := refs.RefCount(ctx, "legacy"); err != nil || count != 1 {
+		t.Fatalf("RefCount = %d, %v; want the legacy reference", count, err)
+	}
+	if deleted, err := refs.DeleteUnreferenced(ctx, "legacy"); err != nil || deleted {
+		t.Fatalf("DeleteUnreferenced of an adopted blob = %v, %v; want it kept", deleted, err)
+	}
+
+	// Taken over by its owner, it is freed with the owner's reference
+	if err := refs.AddRef(ctx, "legacy", blobstore.RefUpload, "file"); err != nil {
+		t.Fatalf("AddRef: %v", err)
+	}
+	if err := refs.Release(ctx, "legacy", blobstore.RefLegacy, "legacy"); err != nil {
+		t.Fatalf("Release legacy: %v", err)
+	}
+	if err := refs.Release(ctx, "legacy", blobstore.RefUpload, "file"); err != nil {
+		t.Fatalf("Release upload: %v", err)
+	}
+	var notFound *blobstore.NotFoundError
+	if _, err := store.Stat(ctx, "legacy"); !errors.As(err, &notFound) {
+		t.Fatalf("Stat after the last release: err = %v, want not found", err)
+	}
+}
+
+func TestRefStoreDeletesTrackedUnreferencedBlobs(t *testing.T) {
+	ctx := context.Background()
+	store := newFileBlobstore(t)
+	db := newTestDB(t)
+	refs := blobstore.NewRefStore(store, db)
+
+	if err := refs.Put(ctx, "piece", bytes.NewReader([]byte("data")), blobstore.RefPiece, "p1"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	var blob models.Blob
+	if err := db.First(&blob, "blob_key = ?", "piece").Error; err != nil || blob.Size != 4 || blob.RefCount != 1 {
+		t.Fatalf("blob record = %+v, %v; want 4 bytes with one reference", blob, err)
+	}
+	if deleted, err := refs.DeleteUnreferenced(ctx, "piece"); err != nil || deleted {
+		t.Fatalf("DeleteUnreferenced of a referenced blob = %v, %v", deleted, err)
+	}
+
+	// A write that never got its reference, e.g. interrupted by a crash
+	if err := db.Model(&blob).Update("ref_count", 0).Error; err != nil {
+		t.Fatalf("drop reference: %v", err)
+	}
+	if deleted, err := refs.DeleteUnreferenced(ctx, "piece"); err != nil || !deleted {
+		t.Fatalf("DeleteUnreferenced of an unreferenced blob = %v, %v; want it deleted", deleted, err)
+	}
+}
+
+func TestRefStoreWritesDoNotBlockOtherKeys(t *testing.T) {
+	ctx := context.Background()
+	refs := blobstore.NewRefStore(newFileBlobstore(t), newTestDB(t))
+
+	// A slow upload holds its key while the data streams in
+	slow, feed := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- refs.Put(ctx, "slow", slow, blobstore.RefPiece, "p1") }()
+	feed.Write([]byte("partial"))
+
+	stored := make(chan error, 1)
+	go func() { stored <- refs.Put(ctx, "fast", bytes.NewReader([]byte("data")), blobstore.RefPiece, "p2") }()
+	select {
+	case err := <-stored:
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Put of another key waited for the slow upload")
+	}
+
+	feed.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("slow Put: %v", err)
+	}
+	if count, err := refs.RefCount(ctx, "slow"); err != nil || count != 1 {
+		t.Fatalf("RefCount = %d, %v; want the slow upload's reference", count, err)
+	}
+}
@@ -0,0 +1,209 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// defaultPartSize is the multipart upload part size used when none is configured
+const defaultPartSize = 16 << 20
+
+// S3Options configures an S3-compatible object storage backend
+type S3Options struct {
+	Endpoint  string // host[:port] of the S3 API, e.g. "s3.amazonaws.com" or "localhost:9000"
+	Region    string
+	Bucket    string
+	Prefix    string // Key prefix inside the bucket, so a bucket can be shared
+	AccessKey string
+	SecretKey string
+	Insecure  bool   // Use plain HTTP, e.g. for a local MinIO
+	PartSize  uint64 // Multipart upload part size; each streaming upload buffers one part
+}
+
+// S3Blobstore implements Blobstore on an S3-compatible object store
+type S3Blobstore struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	partSize uint64
+}
+
+// NewS3Blobstore connects to an S3-compatible object store, creating the bucket if it is missing
+func NewS3Blobstore(ctx context.Context, opts S3Options) (*S3Blobstore, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, fmt.Errorf("s3 endpoint and bucket are required")
+	}
+
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: !opts.Insecure,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check s3 bucket %s: %w", opts.Bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, opts.Bucket, minio.MakeBucketOptions{Region: opts.Region}); err != nil {
+			return nil, fmt.Errorf("failed to create s3 bucket %s: %w", opts.Bucket, err)
+		}
+	}
+
+	partSize := opts.PartSize
+	if partSize == 0 {
+		partSize = defaultPartSize
+	}
+
+	return &S3Blobstore{
+		client:   client,
+		bucket:   opts.Bucket,
+		prefix:   opts.Prefix,
+		partSize: partSize,
+	}, nil
+}
+
+// Put streams data to the object store as a multipart upload, so the size need not be known
+// up front and only one part is buffered at a time
+func (sb *S3Blobstore) Put(ctx context.Context, key string, data io.Reader) error {
+	name, err := sb.objectName(key)
+	if err != nil {
+		return err
+	}
+	_, err = sb.client.PutObject(ctx, sb.bucket, name, data, -1, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		PartSize:    sb.partSize,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get retrieves an object
+func (sb *S3Blobstore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return sb.get(ctx, key, minio.GetObjectOptions{})
+}
+
+// GetRange retrieves part of an object with an HTTP range request
+func (sb *S3Blobstore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length <= 0 {
+		return nil, fmt.Errorf("%w: offset %d, length %d", ErrInvalidRange, offset, length)
+	}
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+	return sb.get(ctx, key, opts)
+}
+
+// Stat returns an object's size and modification time from its metadata
+func (sb *S3Blobstore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	name, err := sb.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := sb.client.StatObject(ctx, sb.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, &NotFoundError{Key: key}
+		}
+		return nil, fmt.Errorf("failed to stat object %s: %w", key, err)
+	}
+	return &BlobInfo{Key: key, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+// Has reports whether an object is stored under key
+func (sb *S3Blobstore) Has(ctx context.Context, key string) (bool, error) {
+	if _, err := sb.Stat(ctx, key); err != nil {
+		if errors.Is(err, ErrBlobNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// List pages through the bucket listing under the store prefix
+func (sb *S3Blobstore) List(ctx context.Context, prefix string) iter.Seq2[BlobInfo, error] {
+	return func(yield func(BlobInfo, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel() // Stops the listing goroutine if the caller breaks early
+
+		base := ""
+		if sb.prefix != "" {
+			base = strings.TrimSuffix(sb.prefix, "/") + "/"
+		}
+		for obj := range sb.client.ListObjects(ctx, sb.bucket, minio.ListObjectsOptions{
+			Prefix:    base + prefix,
+			Recursive: true,
+		}) {
+			if obj.Err != nil {
+				yield(BlobInfo{}, fmt.Errorf("failed to list objects: %w", obj.Err))
+				return
+			}
+			info := BlobInfo{
+				Key:     strings.TrimPrefix(obj.Key, base),
+				Size:    obj.Size,
+				ModTime: obj.LastModified,
+			}
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Delete removes an object
+func (sb *S3Blobstore) Delete(ctx context.Context, key string) error {
+	name, err := sb.objectName(key)
+	if err != nil {
+		return err
+	}
+	if err := sb.client.RemoveObject(ctx, sb.bucket, name, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+	return nil
+}
+
+// get opens an object with a single GET request, so a missing key fails here and a range is
+// sent to the server. The lazy minio.Object would drop the range when asked to stat first.
+func (sb *S3Blobstore) get(ctx context.Context, key string, opts minio.GetObjectOptions) (io.ReadCloser, error) {
+	name, err := sb.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	body, _, _, err := minio.Core{Client: sb.client}.GetObject(ctx, sb.bucket, name, opts)
+	if err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case "NoSuchKey":
+			return nil, &NotFoundError{Key: key}
+		case "InvalidRange":
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRange, key)
+		}
+		return nil, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	return body, nil
+}
+
+// objectName validates a blob key and maps it to its object name in the bucket
+func (sb *S3Blobstore) objectName(key string) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	if sb.prefix == "" {
+		return key, nil
+	}
+	return path.Join(sb.prefix, key), nil
+}
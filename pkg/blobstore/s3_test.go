@@ -0,0 +1,368 @@
+package blobstore_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore/blobstoretest"
+)
+
+func TestS3Blobstore(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// The smallest part size S3 allows, so the suite's largest blob is put in two parts
+	store, err := blobstore.NewS3Blobstore(ctx, blobstore.S3Options{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    "pieces",
+		Prefix:    "pdp",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Insecure:  true,
+		PartSize:  5 << 20,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Blobstore: %v", err)
+	}
+
+	blobstoretest.Run(t, store)
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.mostParts < 2 {
+		t.Errorf("largest multipart upload had %d parts, want at least 2", fake.mostParts)
+	}
+	if fake.rangeRequests == 0 {
+		t.Errorf("GetRange sent no range requests")
+	}
+	if len(fake.uploads) != 0 {
+		t.Errorf("%d multipart uploads were left incomplete", len(fake.uploads))
+	}
+}
+
+func TestS3BlobstorePrefix(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := blobstore.NewS3Blobstore(ctx, blobstore.S3Options{
+		Endpoint: strings.TrimPrefix(server.URL, "http://"),
+		Region:   "us-east-1",
+		Bucket:   "pieces",
+		Prefix:   "pdp",
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Blobstore: %v", err)
+	}
+	if err := store.Put(ctx, "blob", strings.NewReader("data")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	fake.mutex.Lock()
+	_, ok := fake.buckets["pieces"]["pdp/blob"]
+	fake.mutex.Unlock()
+	if !ok {
+		t.Errorf("blob was not stored under the store prefix")
+	}
+	for info, err := range store.List(ctx, "") {
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if info.Key != "blob" {
+			t.Errorf("List returned key %q, want %q", info.Key, "blob")
+		}
+	}
+}
+
+// fakeS3 is an in-process stand-in for the parts of the S3 API the S3 backend uses: bucket
+// creation, multipart and plain puts, full and ranged gets, stats, V2 listings and deletes.
+// It checks no signatures.
+type fakeS3 struct {
+	mutex         sync.Mutex
+	buckets       map[string]map[string]*fakeObject
+	uploads       map[string]*fakeUpload
+	nextUpload    int
+	mostParts     int // Parts of the largest completed multipart upload
+	rangeRequests int
+}
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+type fakeUpload struct {
+	bucket, key string
+	parts       map[int][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		buckets: make(map[string]map[string]*fakeObject),
+		uploads: make(map[string]*fakeUpload),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	if key == "" {
+		f.serveBucket(w, r, bucket)
+		return
+	}
+	objects, ok := f.buckets[bucket]
+	if !ok {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextUpload++
+		id := strconv.Itoa(f.nextUpload)
+		f.uploads[id] = &fakeUpload{bucket: bucket, key: key, parts: make(map[int][]byte)}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: id})
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		upload, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		number, err := strconv.Atoi(query.Get("partNumber"))
+		data, bodyErr := readS3Body(r)
+		if err != nil || bodyErr != nil {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		upload.parts[number] = data
+		w.Header().Set("ETag", etag(data))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		id := query.Get("uploadId")
+		upload, ok := f.uploads[id]
+		if !ok {
+			writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var complete struct {
+			Parts []struct{ PartNumber int } `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			writeS3Error(w, r, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var data []byte
+		for _, part := range complete.Parts {
+			data = append(data, upload.parts[part.PartNumber]...)
+		}
+		delete(f.uploads, id)
+		f.mostParts = max(f.mostParts, len(complete.Parts))
+		objects[key] = &fakeObject{data: data, modTime: time.Now()}
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: etag(data)})
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		objects[key] = &fakeObject{data: data, modTime: time.Now()}
+		w.Header().Set("ETag", etag(data))
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := objects[key]
+		if !ok {
+			writeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.serveObject(w, r, obj)
+
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// serveBucket answers bucket existence checks, bucket creation and V2 listings
+func (f *fakeS3) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	objects, ok := f.buckets[bucket]
+	switch {
+	case r.Method == http.MethodPut:
+		if !ok {
+			f.buckets[bucket] = make(map[string]*fakeObject)
+		}
+	case !ok:
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket")
+	case r.Method == http.MethodHead:
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		type content struct {
+			Key          string
+			LastModified string
+			ETag         string
+			Size         int64
+		}
+		prefix := r.URL.Query().Get("prefix")
+		var keys []string
+		for key := range objects {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		contents := make([]content, len(keys))
+		for i, key := range keys {
+			obj := objects[key]
+			contents[i] = content{
+				Key:          key,
+				LastModified: obj.modTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+				ETag:         etag(obj.data),
+				Size:         int64(len(obj.data)),
+			}
+		}
+		writeXML(w, struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Name        string
+			Prefix      string
+			KeyCount    int
+			MaxKeys     int
+			IsTruncated bool
+			Contents    []content
+		}{Name: bucket, Prefix: prefix, KeyCount: len(contents), MaxKeys: 1000, Contents: contents})
+	default:
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// serveObject answers gets and heads of an object, honouring a "bytes=first-last" or
+// "bytes=first-" range
+func (f *fakeS3) serveObject(w http.ResponseWriter, r *http.Request, obj *fakeObject) {
+	size := int64(len(obj.data))
+	first, last := int64(0), size-1
+	status := http.StatusOK
+	if spec, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes="); ok {
+		f.rangeRequests++
+		from, to, _ := strings.Cut(spec, "-")
+		var err error
+		if first, err = strconv.ParseInt(from, 10, 64); err != nil || first >= size {
+			writeS3Error(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		if to != "" {
+			if last, err = strconv.ParseInt(to, 10, 64); err != nil || last < first {
+				writeS3Error(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+				return
+			}
+			last = min(last, size-1)
+		}
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, size))
+	}
+
+	body := obj.data[first : last+1]
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("ETag", etag(obj.data))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+// readS3Body reads a request body, decoding the aws-chunked encoding of streaming signatures:
+// "<hex size>;chunk-signature=<signature>\r\n<data>\r\n" chunks ending with an empty one
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	reader := bufio.NewReader(r.Body)
+	var data []byte
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size+2) // Followed by "\r\n"
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: code})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	xml.NewEncoder(&buf).Encode(v)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(buf.Bytes())
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,548 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/keylock"
+)
+
+// errColdFull stops demotion when the cold tier reaches its high watermark
+var errColdFull = errors.New("cold tier is full")
+
+// TierLimits bounds how much a tier may hold. A zero capacity means unlimited.
+type TierLimits struct {
+	Capacity      int64
+	HighWatermark float64 // Fraction of capacity; the hot tier demotes above it, the cold tier stops accepting demotions
+	LowWatermark  float64 // Fraction of capacity the hot tier demotes down to
+}
+
+// TieredBlobstore keeps recently read blobs on a fast hot tier and demotes the least recently
+// read ones to a cold tier once the hot tier passes its high watermark. Reads of cold blobs are
+// served from the cold tier, and full reads promote the blob in the background, demoting the
+// least recently read ones first if the hot tier has no room for it. Ranged reads count as
+// access, so pieces being challenged for proofs stay hot, but only read a sliver of a cold blob,
+// which is not worth copying whole.
+//
+// A promoted blob keeps its cold copy, so demoting it again only deletes the hot copy.
+type TieredBlobstore struct {
+	hot       Blobstore
+	cold      Blobstore
+	hotLimit  TierLimits
+	coldLimit TierLimits
+	interval  time.Duration
+
+	locks keylock.Locks // Serialize writes, deletes and tier moves per key
+
+	mutex     sync.Mutex // Guards the fields below
+	hotBlobs  map[string]*hotBlob
+	hotUsed   int64
+	coldUsed  int64
+	promoting map[string]bool
+
+	demoteChan chan struct{}
+	stopChan   chan struct{}
+	wg         sync.WaitGroup
+}
+
+// hotBlob tracks a blob on the hot tier
+type hotBlob struct {
+	size       int64
+	lastAccess time.Time
+}
+
+// TierStatus describes how full each tier is
+type TierStatus struct {
+	HotBlobs     int   `json:"hot_blobs"`
+	HotUsed      int64 `json:"hot_used"`
+	HotCapacity  int64 `json:"hot_capacity"`
+	ColdUsed     int64 `json:"cold_used"`
+	ColdCapacity int64 `json:"cold_capacity"`
+	Promoting    int   `json:"promoting"`
+}
+
+// NewTieredBlobstore creates a tiered store, listing both tiers to learn their usage. Hot blobs
+// start out ranked by modification time until they are read.
+func NewTieredBlobstore(ctx context.Context, hot, cold Blobstore, hotLimit, coldLimit TierLimits, interval time.Duration) (*TieredBlobstore, error) {
+	if hotLimit.Capacity <= 0 {
+		return nil, fmt.Errorf("hot tier capacity is required")
+	}
+	if hotLimit.LowWatermark <= 0 || hotLimit.LowWatermark > hotLimit.HighWatermark || hotLimit.HighWatermark > 1 {
+		return nil, fmt.Errorf("hot tier watermarks must satisfy 0 < low <= high <= 1")
+	}
+
+	t := &TieredBlobstore{
+		hot:        hot,
+		cold:       cold,
+		hotLimit:   hotLimit,
+		coldLimit:  coldLimit,
+		interval:   interval,
+		hotBlobs:   make(map[string]*hotBlob),
+		promoting:  make(map[string]bool),
+		demoteChan: make(chan struct{}, 1),
+		stopChan:   make(chan struct{}),
+	}
+
+	for info, err := range hot.List(ctx, "") {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list hot tier: %w", err)
+		}
+		t.hotBlobs[info.Key] = &hotBlob{size: info.Size, lastAccess: info.ModTime}
+		t.hotUsed += info.Size
+	}
+	for info, err := range cold.List(ctx, "") {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list cold tier: %w", err)
+		}
+		t.coldUsed += info.Size
+	}
+
+	return t, nil
+}
+
+// Start begins demoting blobs whenever the hot tier passes its high watermark
+func (t *TieredBlobstore) Start(ctx context.Context) error {
+	log.Printf("Starting storage tiering (hot %d/%d bytes, cold %d bytes)...", t.hotUsed, t.hotLimit.Capacity, t.coldUsed)
+
+	t.wg.Add(1)
+	go t.run(ctx)
+
+	return nil
+}
+
+// Stop stops demotion and waits for moves in progress
+func (t *TieredBlobstore) Stop() error {
+	close(t.stopChan)
+	t.wg.Wait()
+	log.Printf("Storage tiering stopped")
+	return nil
+}
+
+// GetStatus returns the usage of each tier
+func (t *TieredBlobstore) GetStatus() TierStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return TierStatus{
+		HotBlobs:     len(t.hotBlobs),
+		HotUsed:      t.hotUsed,
+		HotCapacity:  t.hotLimit.Capacity,
+		ColdUsed:     t.coldUsed,
+		ColdCapacity: t.coldLimit.Capacity,
+		Promoting:    len(t.promoting),
+	}
+}
+
+// Put writes new blobs to the hot tier and drops any stale cold copy
+func (t *TieredBlobstore) Put(ctx context.Context, key string, data io.Reader) error {
+	lock := t.locks.Get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	counter := &countingReader{r: data}
+	if err := t.hot.Put(ctx, key, counter); err != nil {
+		return err
+	}
+	t.addHot(key, counter.n)
+
+	if err := t.deleteCold(ctx, key); err != nil {
+		return fmt.Errorf("failed to drop stale cold copy: %w", err)
+	}
+	return nil
+}
+
+// Get reads from the hot tier, falling back to the cold tier and promoting the blob
+func (t *TieredBlobstore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := t.hot.Get(ctx, key)
+	if err == nil {
+		t.touch(key)
+		return obj, nil
+	}
+	if !errors.Is(err, ErrBlobNotFound) {
+		return nil, err
+	}
+
+	obj, err = t.cold.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	t.promote(key)
+	return obj, nil
+}
+
+// GetRange reads part of a blob from whichever tier holds it
+func (t *TieredBlobstore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	obj, err := t.hot.GetRange(ctx, key, offset, length)
+	if err == nil {
+		t.touch(key)
+		return obj, nil
+	}
+	if !errors.Is(err, ErrBlobNotFound) {
+		return nil, err
+	}
+
+	return t.cold.GetRange(ctx, key, offset, length)
+}
+
+// Stat describes a blob from whichever tier holds it
+func (t *TieredBlobstore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	info, err := t.hot.Stat(ctx, key)
+	if err == nil || !errors.Is(err, ErrBlobNotFound) {
+		return info, err
+	}
+	return t.cold.Stat(ctx, key)
+}
+
+// Has reports whether either tier holds a blob
+func (t *TieredBlobstore) Has(ctx context.Context, key string) (bool, error) {
+	has, err := t.hot.Has(ctx, key)
+	if err != nil || has {
+		return has, err
+	}
+	return t.cold.Has(ctx, key)
+}
+
+// List lists the hot tier, then cold blobs that are not also hot
+func (t *TieredBlobstore) List(ctx context.Context, prefix string) iter.Seq2[BlobInfo, error] {
+	return func(yield func(BlobInfo, error) bool) {
+		seen := make(map[string]bool)
+		for info, err := range t.hot.List(ctx, prefix) {
+			if err != nil {
+				yield(BlobInfo{}, err)
+				return
+			}
+			seen[info.Key] = true
+			if !yield(info, nil) {
+				return
+			}
+		}
+		for info, err := range t.cold.List(ctx, prefix) {
+			if err != nil {
+				yield(BlobInfo{}, err)
+				return
+			}
+			if seen[info.Key] {
+				continue
+			}
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Delete removes a blob from both tiers
+func (t *TieredBlobstore) Delete(ctx context.Context, key string) error {
+	lock := t.locks.Get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	hotErr := t.hot.Delete(ctx, key)
+	if hotErr != nil && !errors.Is(hotErr, ErrBlobNotFound) {
+		return hotErr
+	}
+	if hotErr == nil {
+		t.removeHot(key)
+	}
+
+	has, err := t.cold.Has(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !has {
+		if hotErr != nil {
+			return &NotFoundError{Key: key}
+		}
+		return nil
+	}
+	return t.deleteCold(ctx, key)
+}
+
+// run demotes on every tick, and whenever a write pushes the hot tier past its high watermark
+func (t *TieredBlobstore) run(ctx context.Context) {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.stopChan:
+			return
+		case <-ticker.C:
+		case <-t.demoteChan:
+		}
+		t.demote(ctx)
+	}
+}
+
+// demote moves the least recently read hot blobs to the cold tier until the hot tier is back
+// under its low watermark, stopping early if the cold tier reaches its high watermark
+func (t *TieredBlobstore) demote(ctx context.Context) {
+	if !t.overHigh() {
+		return
+	}
+	t.demoteUntil(ctx, t.underLow)
+}
+
+// demoteUntil moves the least recently read hot blobs to the cold tier until done reports true,
+// stopping early if the cold tier reaches its high watermark
+func (t *TieredBlobstore) demoteUntil(ctx context.Context, done func() bool) {
+	t.mutex.Lock()
+	keys := make([]string, 0, len(t.hotBlobs))
+	for key := range t.hotBlobs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return t.hotBlobs[keys[i]].lastAccess.Before(t.hotBlobs[keys[j]].lastAccess)
+	})
+	t.mutex.Unlock()
+
+	demoted, bytes := 0, int64(0)
+	for _, key := range keys {
+		if done() {
+			break
+		}
+		select {
+		case <-t.stopChan:
+			return
+		default:
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		size, err := t.demoteBlob(ctx, key)
+		if errors.Is(err, errColdFull) {
+			log.Printf("Warning: cold tier is past its high watermark, %d bytes left on the hot tier", t.GetStatus().HotUsed)
+			break
+		}
+		if err != nil {
+			log.Printf("Error demoting blob %s: %v", key, err)
+			continue
+		}
+		demoted++
+		bytes += size
+	}
+
+	if demoted > 0 {
+		log.Printf("Demoted %d blobs (%d bytes) to the cold tier", demoted, bytes)
+	}
+}
+
+// demoteBlob copies a blob to the cold tier unless it is already there, then deletes the hot copy
+func (t *TieredBlobstore) demoteBlob(ctx context.Context, key string) (int64, error) {
+	lock := t.locks.Get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	t.mutex.Lock()
+	blob, ok := t.hotBlobs[key]
+	t.mutex.Unlock()
+	if !ok {
+		return 0, nil // Deleted since the candidates were ranked
+	}
+
+	coldInfo, err := t.cold.Stat(ctx, key)
+	if err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return 0, err
+	}
+	if coldInfo == nil || coldInfo.Size != blob.size {
+		if !t.coldHasRoom(blob.size) {
+			return 0, errColdFull
+		}
+		if err := t.copy(ctx, t.hot, t.cold, key, blob.size); err != nil {
+			return 0, err
+		}
+		t.mutex.Lock()
+		t.coldUsed += blob.size
+		if coldInfo != nil {
+			t.coldUsed -= coldInfo.Size
+		}
+		t.mutex.Unlock()
+	}
+
+	if err := t.hot.Delete(ctx, key); err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return 0, err
+	}
+	t.removeHot(key)
+	return blob.size, nil
+}
+
+// promote copies a cold blob to the hot tier in the background, once per key at a time
+func (t *TieredBlobstore) promote(key string) {
+	t.mutex.Lock()
+	if t.promoting[key] {
+		t.mutex.Unlock()
+		return
+	}
+	t.promoting[key] = true
+	t.mutex.Unlock()
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer func() {
+			t.mutex.Lock()
+			delete(t.promoting, key)
+			t.mutex.Unlock()
+		}()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-t.stopChan:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		if err := t.promoteBlob(ctx, key); err != nil && ctx.Err() == nil {
+			log.Printf("Error promoting blob %s: %v", key, err)
+		}
+	}()
+}
+
+// promoteBlob copies a cold blob to the hot tier once it fits under the high watermark
+func (t *TieredBlobstore) promoteBlob(ctx context.Context, key string) error {
+	info, err := t.cold.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if float64(info.Size) > t.hotLimit.HighWatermark*float64(t.hotLimit.Capacity) {
+		return nil // Would never fit, so not worth emptying the hot tier for
+	}
+	// Room is made before taking the key's lock, as demoting takes the locks of other keys
+	if !t.hotHasRoom(info.Size) {
+		t.demoteUntil(ctx, func() bool { return t.hotHasRoom(info.Size) })
+	}
+
+	lock := t.locks.Get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if has, err := t.hot.Has(ctx, key); err != nil || has {
+		return err
+	}
+	if !t.hotHasRoom(info.Size) {
+		return nil // Served from the cold tier until the hot tier has room
+	}
+	if err := t.copy(ctx, t.cold, t.hot, key, info.Size); err != nil {
+		return err
+	}
+	t.addHot(key, info.Size)
+	return nil
+}
+
+// copy streams a blob between tiers and checks the size that arrived
+func (t *TieredBlobstore) copy(ctx context.Context, from, to Blobstore, key string, size int64) error {
+	obj, err := from.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	if err := to.Put(ctx, key, obj); err != nil {
+		return err
+	}
+	info, err := to.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if info.Size != size {
+		to.Delete(ctx, key)
+		return fmt.Errorf("copied %d bytes, expected %d", info.Size, size)
+	}
+	return nil
+}
+
+// deleteCold removes a cold copy if there is one
+func (t *TieredBlobstore) deleteCold(ctx context.Context, key string) error {
+	info, err := t.cold.Stat(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrBlobNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := t.cold.Delete(ctx, key); err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return err
+	}
+	t.mutex.Lock()
+	t.coldUsed -= info.Size
+	t.mutex.Unlock()
+	return nil
+}
+
+// addHot records a blob written to the hot tier and wakes demotion if the tier is now too full
+func (t *TieredBlobstore) addHot(key string, size int64) {
+	t.mutex.Lock()
+	if old, ok := t.hotBlobs[key]; ok {
+		t.hotUsed -= old.size
+	}
+	t.hotBlobs[key] = &hotBlob{size: size, lastAccess: time.Now()}
+	t.hotUsed += size
+	t.mutex.Unlock()
+
+	if t.overHigh() {
+		select {
+		case t.demoteChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *TieredBlobstore) removeHot(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if blob, ok := t.hotBlobs[key]; ok {
+		t.hotUsed -= blob.size
+		delete(t.hotBlobs, key)
+	}
+}
+
+// touch marks a hot blob as just read
+func (t *TieredBlobstore) touch(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if blob, ok := t.hotBlobs[key]; ok {
+		blob.lastAccess = time.Now()
+	}
+}
+
+func (t *TieredBlobstore) overHigh() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return float64(t.hotUsed) > t.hotLimit.HighWatermark*float64(t.hotLimit.Capacity)
+}
+
+func (t *TieredBlobstore) underLow() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return float64(t.hotUsed) <= t.hotLimit.LowWatermark*float64(t.hotLimit.Capacity)
+}
+
+func (t *TieredBlobstore) hotHasRoom(size int64) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return float64(t.hotUsed+size) <= t.hotLimit.HighWatermark*float64(t.hotLimit.Capacity)
+}
+
+func (t *TieredBlobstore) coldHasRoom(size int64) bool {
+	if t.coldLimit.Capacity <= 0 {
+		return true
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return float64(t.coldUsed+size) <= t.coldLimit.HighWatermark*float64(t.coldLimit.Capacity)
+}
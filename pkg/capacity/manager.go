@@ -0,0 +1,305 @@
+package capacity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	tenantpkg "github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrStorageFull is returned when the server has no room left for the data
+	ErrStorageFull = apperr.New(apperr.StorageFull, "STORAGE_FULL", "insufficient storage")
+	// ErrQuotaExceeded is returned when the data would take a tenant over its quota
+	ErrQuotaExceeded = apperr.New(apperr.StorageFull, "QUOTA_EXCEEDED", "storage quota exceeded")
+)
+
+// Options configures capacity limits. Zero limits are unlimited.
+type Options struct {
+	BlobPath     string           // Directory blobs are stored in, measured for free disk space; empty for remote backends
+	TmpPath      string           // Directory uploads are staged in
+	Limit        int64            // Most bytes the blob store may hold, in addition to the disk's own size
+	MinFree      int64            // Disk space always left free on the blob store's filesystem
+	DefaultQuota int64            // Bytes each tenant may store unless listed in Quotas
+	Quotas       map[string]int64 // Per tenant quotas
+}
+
+// Manager accounts for the space used and reserved in each store and admits or rejects writes
+// against disk space, configured limits and tenant quotas. Space is reserved when data is
+// announced, so concurrent uploads cannot together overrun what was free.
+type Manager struct {
+	db    *gorm.DB
+	opts  Options
+	mutex sync.Mutex // Makes checking and reserving space atomic
+}
+
+// Status reports capacity for each store
+type Status struct {
+	Stores []StoreStatus `json:"stores"`
+}
+
+// StoreStatus reports the space of one store. Free already excludes reserved space.
+type StoreStatus struct {
+	Name     string `json:"name"`
+	Used     int64  `json:"used"`
+	Reserved int64  `json:"reserved"`
+	Free     int64  `json:"free"`            // -1 when unlimited
+	Total    int64  `json:"total,omitempty"` // Zero when unlimited
+}
+
+// TenantUsage reports a tenant's usage against its quota
+type TenantUsage struct {
+	Tenant   string `json:"tenant"`
+	Used     int64  `json:"used"`
+	Reserved int64  `json:"reserved"`
+	Quota    int64  `json:"quota,omitempty"` // Zero when unlimited
+}
+
+// NewManager creates a capacity manager
+func NewManager(db *gorm.DB, opts Options) *Manager {
+	return &Manager{
+		db:   db,
+		opts: opts,
+	}
+}
+
+// Reserve holds bytes for a tenant under ref, failing with ErrStorageFull or ErrQuotaExceeded
+// when they do not fit. Reserving an existing ref replaces it. A zero ttl holds the space
+// until Release.
+func (m *Manager) Reserve(ctx context.Context, tenant, ref string, bytes int64, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	db := m.db.WithContext(ctx)
+	if err := db.Where("expires_at IS NOT NULL AND expires_at < ?", time.Now()).Delete(&models.Reservation{}).Error; err != nil {
+		return fmt.Errorf("failed to drop expired reservations: %w", err)
+	}
+
+	// The existing reservation for ref is being replaced, so it does not count against itself
+	var existing models.Reservation
+	if err := db.Where("ref = ?", ref).Find(&existing).Error; err != nil {
+		return fmt.Errorf("failed to look up reservation: %w", err)
+	}
+
+	free, err := m.blobFree(ctx)
+	if err != nil {
+		return err
+	}
+	if free >= 0 && bytes > free+existing.Bytes {
+		return fmt.Errorf("%w: %d bytes requested, %d bytes free", ErrStorageFull, bytes, free+existing.Bytes)
+	}
+
+	if err := m.checkQuota(ctx, tenant, &existing, bytes); err != nil {
+		return err
+	}
+
+	reservation := &models.Reservation{Ref: ref, Tenant: tenant, Bytes: bytes}
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		reservation.ExpiresAt = &expires
+	}
+	if err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(reservation).Error; err != nil {
+		return fmt.Errorf("failed to reserve space: %w", err)
+	}
+	return nil
+}
+
+// CheckQuota fails with ErrQuotaExceeded when bytes more would not fit in the tenant's
+// quota. Nothing is held, so it suits data that is already stored; space reserved under
+// ref does not count, as it would be replaced.
+func (m *Manager) CheckQuota(ctx context.Context, tenant, ref string, bytes int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var existing models.Reservation
+	if err := m.live(m.db.WithContext(ctx)).Where("ref = ?", ref).Find(&existing).Error; err != nil {
+		return fmt.Errorf("failed to look up reservation: %w", err)
+	}
+	return m.checkQuota(ctx, tenant, &existing, bytes)
+}
+
+// checkQuota checks bytes against the tenant's quota, not counting the existing reservation
+func (m *Manager) checkQuota(ctx context.Context, tenant string, existing *models.Reservation, bytes int64) error {
+	quota := m.quota(tenant)
+	if quota <= 0 {
+		return nil
+	}
+	usage, err := m.tenantUsage(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	if existing.Tenant == tenant {
+		usage.Reserved -= existing.Bytes
+	}
+	if usage.Used+usage.Reserved+bytes > quota {
+		return fmt.Errorf("%w: tenant %s uses %d of %d bytes, %d more requested",
+			ErrQuotaExceeded, tenant, usage.Used+usage.Reserved, quota, bytes)
+	}
+	return nil
+}
+
+// Release frees the space held under ref; releasing an unknown ref is a no-op
+func (m *Manager) Release(ctx context.Context, ref string) error {
+	if err := m.db.WithContext(ctx).Where("ref = ?", ref).Delete(&models.Reservation{}).Error; err != nil {
+		return fmt.Errorf("failed to release reservation: %w", err)
+	}
+	return nil
+}
+
+// GetStatus reports used, reserved and free space for the blob store and the upload staging area
+func (m *Manager) GetStatus(ctx context.Context) (*Status, error) {
+	used, err := m.blobUsed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := m.reserved(ctx)
+	if err != nil {
+		return nil, err
+	}
+	free, err := m.blobFree(ctx)
+	if err != nil {
+		return nil, err
+	}
+	blobs := StoreStatus{Name: "blobs", Used: used, Reserved: reserved, Free: free}
+	if m.opts.Limit > 0 {
+		blobs.Total = m.opts.Limit
+	}
+	if m.opts.BlobPath != "" {
+		if total, _, err := diskSpace(m.opts.BlobPath); err == nil && (blobs.Total == 0 || total < blobs.Total) {
+			blobs.Total = total
+		}
+	}
+
+	status := &Status{Stores: []StoreStatus{blobs}}
+	if m.opts.TmpPath != "" {
+		tmp := StoreStatus{Name: "tmp", Free: -1, Used: dirSize(m.opts.TmpPath)}
+		if total, free, err := diskSpace(m.opts.TmpPath); err == nil {
+			tmp.Total, tmp.Free = total, free
+		}
+		status.Stores = append(status.Stores, tmp)
+	}
+	return status, nil
+}
+
+// GetTenantUsage reports a tenant's stored and reserved bytes against its quota
+func (m *Manager) GetTenantUsage(ctx context.Context, tenant string) (*TenantUsage, error) {
+	return m.tenantUsage(ctx, tenant)
+}
+
+// blobFree returns the bytes that may still be reserved in the blob store, or -1 if unlimited
+func (m *Manager) blobFree(ctx context.Context) (int64, error) {
+	reserved, err := m.reserved(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	free := int64(-1)
+	if m.opts.Limit > 0 {
+		used, err := m.blobUsed(ctx)
+		if err != nil {
+			return 0, err
+		}
+		free = m.opts.Limit - used
+	}
+	if m.opts.BlobPath != "" {
+		// Disk space already reflects written blobs, so only reservations come off it
+		_, diskFree, err := diskSpace(m.opts.BlobPath)
+		if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			return 0, fmt.Errorf("failed to measure free disk space: %w", err)
+		}
+		if err == nil {
+			diskFree -= m.opts.MinFree
+			if free < 0 || diskFree < free {
+				free = diskFree
+			}
+		}
+	}
+	if free < 0 && m.opts.Limit <= 0 {
+		return -1, nil
+	}
+
+	free -= reserved
+	if free < 0 {
+		free = 0
+	}
+	return free, nil
+}
+
+// blobUsed sums the sizes of stored blobs, counting shared blobs once
+func (m *Manager) blobUsed(ctx context.Context) (int64, error) {
+	var used int64
+	if err := m.db.WithContext(ctx).Model(&models.Blob{}).Select("COALESCE(SUM(size), 0)").Scan(&used).Error; err != nil {
+		return 0, fmt.Errorf("failed to sum blob sizes: %w", err)
+	}
+	return used, nil
+}
+
+// reserved sums unexpired reservations
+func (m *Manager) reserved(ctx context.Context) (int64, error) {
+	var reserved int64
+	if err := m.live(m.db.WithContext(ctx)).
+		Model(&models.Reservation{}).
+		Select("COALESCE(SUM(bytes), 0)").
+		Scan(&reserved).Error; err != nil {
+		return 0, fmt.Errorf("failed to sum reservations: %w", err)
+	}
+	return reserved, nil
+}
+
+// tenantUsage sums the stored pieces and unexpired reservations charged to a tenant. Pieces
+// owned by several tenants count in full against each of them.
+func (m *Manager) tenantUsage(ctx context.Context, tenant string) (*TenantUsage, error) {
+	usage := &TenantUsage{Tenant: tenant, Quota: m.quota(tenant)}
+	db := m.db.WithContext(ctx)
+	if err := db.Model(&models.Piece{}).
+		Joins("JOIN owners ON owners.kind = ? AND owners.ref_id = pieces.id", tenantpkg.KindPiece).
+		Where("owners.tenant = ? AND pieces.status <> ?", tenant, "prepared").
+		Select("COALESCE(SUM(pieces.size), 0)").
+		Scan(&usage.Used).Error; err != nil {
+		return nil, fmt.Errorf("failed to sum tenant pieces: %w", err)
+	}
+	if err := m.live(db).
+		Model(&models.Reservation{}).
+		Where("tenant = ?", tenant).
+		Select("COALESCE(SUM(bytes), 0)").
+		Scan(&usage.Reserved).Error; err != nil {
+		return nil, fmt.Errorf("failed to sum tenant reservations: %w", err)
+	}
+	return usage, nil
+}
+
+// live scopes a reservation query to those not yet expired
+func (m *Manager) live(db *gorm.DB) *gorm.DB {
+	return db.Where("expires_at IS NULL OR expires_at >= ?", time.Now())
+}
+
+func (m *Manager) quota(tenant string) int64 {
+	if quota, ok := m.opts.Quotas[tenant]; ok {
+		return quota
+	}
+	return m.opts.DefaultQuota
+}
+
+// dirSize sums the sizes of the files under dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package capacity
+
+import "errors"
+
+// diskSpace is not supported on this platform; only configured limits apply
+func diskSpace(path string) (total, free int64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package capacity
+
+import "golang.org/x/sys/unix"
+
+// diskSpace returns the total and available bytes of the filesystem holding path
+func diskSpace(path string) (total, free int64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return int64(st.Blocks) * int64(st.Bsize), int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,287 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/piece"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-varint"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrBlockNotFound is returned when no ingested CAR contains the requested block
+	ErrBlockNotFound = apperr.New(apperr.NotFound, "BLOCK_NOT_FOUND", "block not found")
+	// ErrCarNotFound is returned for PieceCIDs no ingested CAR was stored under
+	ErrCarNotFound = apperr.New(apperr.NotFound, "CAR_NOT_FOUND", "CAR not found")
+	// ErrInvalidCar is returned for malformed CARs and CARs whose blocks do not match their CIDs
+	ErrInvalidCar = apperr.New(apperr.Invalid, "INVALID_CAR", "invalid CAR")
+)
+
+// CarService ingests CAR files as pieces and indexes their blocks
+type CarService struct {
+	pieceSvc  *piece.PieceService
+	blobStore blobstore.Blobstore
+	owners    *tenant.OwnerStore
+	db        *gorm.DB
+}
+
+// CarInfo describes an ingested CAR file
+type CarInfo struct {
+	Version    uint64           `json:"version"`
+	Roots      []string         `json:"roots"`
+	BlockCount int              `json:"block_count"`
+	Piece      *piece.PieceInfo `json:"piece"`
+}
+
+// blockEntry is the location of a block within the CAR bytes
+type blockEntry struct {
+	cid    cid.Cid
+	offset int64
+	size   int64
+}
+
+// NewCarService creates a new CAR service. CARs and their blocks are only visible to the
+// tenants owning their pieces.
+func NewCarService(pieceSvc *piece.PieceService, blobStore blobstore.Blobstore, owners *tenant.OwnerStore, db *gorm.DB) *CarService {
+	return &CarService{
+		pieceSvc:  pieceSvc,
+		blobStore: blobStore,
+		owners:    owners,
+		db:        db,
+	}
+}
+
+// IngestCar validates a CARv1/CARv2 archive, stores it as a piece and indexes its roots and blocks
+func (s *CarService) IngestCar(ctx context.Context, data []byte) (*CarInfo, error) {
+	version, roots, entries, err := indexCar(data)
+	if err != nil {
+		return nil, err
+	}
+	rootStrs := make([]string, len(roots))
+	for i, root := range roots {
+		rootStrs[i] = root.String()
+	}
+
+	pieceInfo, err := s.pieceSvc.UploadPiece(ctx, "", data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store CAR as piece: %w", err)
+	}
+
+	// Re-ingesting the same CAR resolves to the same piece, which is already indexed
+	var indexed int64
+	if err := s.db.WithContext(ctx).
+		Model(&models.CarRoot{}).
+		Where("piece_id = ?", pieceInfo.ID).
+		Count(&indexed).Error; err != nil {
+		return nil, fmt.Errorf("failed to check CAR index: %w", err)
+	}
+	if indexed > 0 {
+		return &CarInfo{Version: version, Roots: rootStrs, BlockCount: len(entries), Piece: pieceInfo}, nil
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, root := range roots {
+			carRoot := &models.CarRoot{
+				PieceID:  pieceInfo.ID,
+				PieceCID: pieceInfo.PieceCID,
+				RootCID:  root.String(),
+			}
+			if err := tx.Create(carRoot).Error; err != nil {
+				return fmt.Errorf("failed to record root %s: %w", root, err)
+			}
+		}
+
+		blocks := make([]models.CarBlock, len(entries))
+		for i, entry := range entries {
+			blocks[i] = models.CarBlock{
+				PieceCID:  pieceInfo.PieceCID,
+				BlockCID:  entry.cid.String(),
+				Multihash: hex.EncodeToString(entry.cid.Hash()),
+				Offset:    entry.offset,
+				Size:      entry.size,
+			}
+		}
+		if len(blocks) > 0 {
+			if err := tx.CreateInBatches(blocks, 500).Error; err != nil {
+				return fmt.Errorf("failed to record block index: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Ingested CARv%d as piece %s with %d roots and %d blocks", version, pieceInfo.PieceCID, len(roots), len(entries))
+
+	return &CarInfo{
+		Version:    version,
+		Roots:      rootStrs,
+		BlockCount: len(entries),
+		Piece:      pieceInfo,
+	}, nil
+}
+
+// GetCar returns the roots and block count recorded for an ingested CAR piece
+func (s *CarService) GetCar(ctx context.Context, pieceCID string) (*CarInfo, error) {
+	var roots []models.CarRoot
+	if err := s.visible(ctx).
+		Where("piece_cid = ?", pieceCID).
+		Order("id ASC").
+		Find(&roots).Error; err != nil {
+		return nil, fmt.Errorf("failed to get CAR roots: %w", err)
+	}
+
+	var blockCount int64
+	if err := s.visible(ctx).
+		Model(&models.CarBlock{}).
+		Where("piece_cid = ?", pieceCID).
+		Count(&blockCount).Error; err != nil {
+		return nil, fmt.Errorf("failed to count CAR blocks: %w", err)
+	}
+
+	if len(roots) == 0 && blockCount == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrCarNotFound, pieceCID)
+	}
+
+	info := &CarInfo{
+		Roots:      make([]string, len(roots)),
+		BlockCount: int(blockCount),
+	}
+	for i, root := range roots {
+		info.Roots[i] = root.RootCID
+	}
+	if len(roots) > 0 {
+		if pieceInfo, err := s.pieceSvc.GetPiece(ctx, roots[0].PieceID); err == nil {
+			info.Piece = pieceInfo
+		}
+	}
+
+	return info, nil
+}
+
+// GetBlock returns the raw bytes of a block from any ingested CAR containing it
+func (s *CarService) GetBlock(ctx context.Context, c cid.Cid) ([]byte, error) {
+	var block models.CarBlock
+	if err := s.visible(ctx).
+		Where("multihash = ?", hex.EncodeToString(c.Hash())).
+		First(&block).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, c)
+		}
+		return nil, fmt.Errorf("failed to look up block: %w", err)
+	}
+
+	if block.Size == 0 {
+		return []byte{}, nil
+	}
+
+	obj, err := s.blobStore.GetRange(ctx, block.PieceCID, block.Offset, block.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open piece %s: %w", block.PieceCID, err)
+	}
+	defer obj.Close()
+
+	data := make([]byte, block.Size)
+	if _, err := io.ReadFull(obj, data); err != nil {
+		return nil, fmt.Errorf("failed to read block: %w", err)
+	}
+
+	return data, nil
+}
+
+// GetBlockSize returns the size of a block from any ingested CAR containing it
+func (s *CarService) GetBlockSize(ctx context.Context, c cid.Cid) (int64, error) {
+	var block models.CarBlock
+	if err := s.visible(ctx).
+		Where("multihash = ?", hex.EncodeToString(c.Hash())).
+		First(&block).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, fmt.Errorf("%w: %s", ErrBlockNotFound, c)
+		}
+		return 0, fmt.Errorf("failed to look up block: %w", err)
+	}
+	return block.Size, nil
+}
+
+// HasBlock reports whether a block is present in any ingested CAR
+func (s *CarService) HasBlock(ctx context.Context, c cid.Cid) (bool, error) {
+	var count int64
+	if err := s.visible(ctx).
+		Model(&models.CarBlock{}).
+		Where("multihash = ?", hex.EncodeToString(c.Hash())).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to look up block: %w", err)
+	}
+	return count > 0, nil
+}
+
+// visible starts a query on CAR roots or blocks, limited to those of pieces the context's
+// tenant owns
+func (s *CarService) visible(ctx context.Context) *gorm.DB {
+	db := s.db.WithContext(ctx)
+	if tenant.SeesAll(ctx) {
+		return db
+	}
+	owned := s.owners.Scope(ctx, s.db.WithContext(ctx).Model(&models.Piece{}).Select("piece_cid"), tenant.KindPiece, "id")
+	return db.Where("piece_cid IN (?)", owned)
+}
+
+// indexCar walks every block section of a CAR, verifying block hashes and recording offsets
+func indexCar(data []byte) (uint64, []cid.Cid, []blockEntry, error) {
+	reader, err := carv2.NewBlockReader(bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("%w: bad header: %v", ErrInvalidCar, err)
+	}
+	if len(reader.Roots) == 0 {
+		return 0, nil, nil, fmt.Errorf("%w: declares no roots", ErrInvalidCar)
+	}
+
+	var entries []blockEntry
+	for {
+		meta, err := reader.SkipNext()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("%w: bad section: %v", ErrInvalidCar, err)
+		}
+
+		// SourceOffset points at the section's length prefix; the block data follows the CID
+		cidLen := uint64(meta.Cid.ByteLen())
+		sectionLen := cidLen + meta.Size
+		offset := meta.SourceOffset + uint64(varint.UvarintSize(sectionLen)) + cidLen
+		if offset+meta.Size > uint64(len(data)) {
+			return 0, nil, nil, fmt.Errorf("%w: block %s extends beyond its end", ErrInvalidCar, meta.Cid)
+		}
+
+		blockData := data[offset : offset+meta.Size]
+		hashed, err := meta.Cid.Prefix().Sum(blockData)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("failed to hash block %s: %w", meta.Cid, err)
+		}
+		if !hashed.Equals(meta.Cid) {
+			return 0, nil, nil, fmt.Errorf("%w: block hash mismatch: expected %s, got %s", ErrInvalidCar, meta.Cid, hashed)
+		}
+
+		entries = append(entries, blockEntry{
+			cid:    meta.Cid,
+			offset: int64(offset),
+			size:   int64(meta.Size),
+		})
+	}
+
+	return reader.Version, reader.Roots, entries, nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/storacha/piri/pkg/config"
 	"gopkg.in/yaml.v3"
@@ -10,15 +11,30 @@ import (
 
 // Config represents the PDP server configuration
 type Config struct {
-	Server ServerConfig   `yaml:"server"`
-	PDP    PDPConfig      `yaml:"pdp"`
-	Piri   *config.Config `yaml:"piri,omitempty"` // Optional Piri integration
+	Server      ServerConfig      `yaml:"server"`
+	PDP         PDPConfig         `yaml:"pdp"`
+	Storage     StorageConfig     `yaml:"storage"`
+	Capacity    CapacityConfig    `yaml:"capacity"`
+	UCAN        UCANConfig        `yaml:"ucan"`
+	Limits      LimitsConfig      `yaml:"limits"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Events      EventsConfig      `yaml:"events"`
+	Webhooks    WebhooksConfig    `yaml:"webhooks"`
+	Uploads     UploadsConfig     `yaml:"uploads"`
+	Imports     ImportsConfig     `yaml:"imports"`
+	GC          GCConfig          `yaml:"gc"`
+	Scrub       ScrubConfig       `yaml:"scrub"`
+	Piri        *config.Config    `yaml:"piri,omitempty"` // Optional Piri integration
 }
 
 // ServerConfig represents the HTTP server configuration
 type ServerConfig struct {
-	Host string `yaml:"host"`
-	Port int    `yaml:"port"`
+	Host              string   `yaml:"host"`
+	Port              int      `yaml:"port"`
+	AdminToken        string   `yaml:"admin_token,omitempty"`     // Bearer token for /admin routes; admin routes are disabled when empty
+	RequireAuth       bool     `yaml:"require_auth"`              // Refuse requests without an API or admin token; /health stays public. Defaults to true.
+	ValidateResponses bool     `yaml:"validate_responses"`        // Log responses that do not match the OpenAPI document
+	TrustedProxies    []string `yaml:"trusted_proxies,omitempty"` // CIDRs of reverse proxies whose X-Forwarded-For is trusted for client addresses
 }
 
 // PDPConfig represents the PDP-specific configuration
@@ -29,6 +45,122 @@ type PDPConfig struct {
 	KeyFile    string `yaml:"key_file,omitempty"`
 }
 
+// StorageConfig selects and configures the blobstore backend
+type StorageConfig struct {
+	Backend    string           `yaml:"backend"` // "file" (default) stores blobs under data_dir, "s3" in object storage
+	S3         S3Config         `yaml:"s3"`
+	Tiering    TieringConfig    `yaml:"tiering"`
+	Encryption EncryptionConfig `yaml:"encryption"`
+}
+
+// S3Config represents an S3-compatible object storage backend
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"` // host[:port], without scheme
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"` // Key prefix inside the bucket
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	Insecure  bool   `yaml:"insecure"`  // Use plain HTTP, e.g. for a local MinIO
+	PartSize  uint64 `yaml:"part_size"` // Multipart upload part size in bytes
+}
+
+// TieringConfig represents a hot local disk tier in front of the storage backend, which becomes the cold tier
+type TieringConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	HotDir            string        `yaml:"hot_dir"`             // Directory on fast disk; defaults to data_dir/hot
+	HotCapacity       int64         `yaml:"hot_capacity"`        // Bytes the hot tier may hold
+	HotHighWatermark  float64       `yaml:"hot_high_watermark"`  // Fraction of capacity above which blobs are demoted
+	HotLowWatermark   float64       `yaml:"hot_low_watermark"`   // Fraction of capacity demotion stops at
+	ColdCapacity      int64         `yaml:"cold_capacity"`       // Bytes the cold tier may hold; zero is unlimited
+	ColdHighWatermark float64       `yaml:"cold_high_watermark"` // Fraction of capacity above which demotion stops
+	Interval          time.Duration `yaml:"interval"`            // How often the hot tier is checked
+}
+
+// EncryptionConfig represents at-rest encryption of stored blobs
+type EncryptionConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	KeyFile           string        `yaml:"key_file"`           // JSON keyring; takes precedence over kms_dir
+	KMSDir            string        `yaml:"kms_dir"`            // Local key management stand-in; defaults to data_dir/kms
+	ReencryptInterval time.Duration `yaml:"reencrypt_interval"` // How often blobs under old keys are re-encrypted
+}
+
+// UCANConfig represents UCAN authorization, as an alternative to API tokens
+type UCANConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	ServiceDID string `yaml:"service_did"`           // DID delegation chains must be rooted in
+	ServiceKey string `yaml:"service_key,omitempty"` // did:key the service DID resolves to, unless it is a did:key itself
+}
+
+// CapacityConfig represents storage limits and per-tenant quotas; zero limits are unlimited
+type CapacityConfig struct {
+	Limit          int64            `yaml:"limit"`           // Most bytes of blobs stored, on top of the disk's own size
+	MinFree        int64            `yaml:"min_free"`        // Disk space always left free on the blob filesystem
+	DefaultQuota   int64            `yaml:"default_quota"`   // Bytes each tenant may store
+	Quotas         map[string]int64 `yaml:"quotas"`          // Per tenant quotas overriding the default
+	ReservationTTL time.Duration    `yaml:"reservation_ttl"` // How long a prepared piece holds its space
+}
+
+// LimitsConfig represents the per-client rate limits and request size limits. Clients are
+// API tokens and UCAN issuers, or IP addresses for requests without one.
+type LimitsConfig struct {
+	RequestsPerSecond    float64          `yaml:"requests_per_second"`     // Sustained request rate per client; 0 is unlimited
+	Burst                int              `yaml:"burst"`                   // Requests a client may make at once; defaults to one second's worth
+	UploadBytesPerSecond int64            `yaml:"upload_bytes_per_second"` // Upload bandwidth per client; 0 is unlimited
+	MaxConcurrentUploads int              `yaml:"max_concurrent_uploads"`  // Uploads a client may run at once; 0 is unlimited
+	MaxBodySize          int64            `yaml:"max_body_size"`           // Largest request body on routes that do not upload data
+	MaxUploadSize        int64            `yaml:"max_upload_size"`         // Largest request body on routes that upload data
+	BodySizes            map[string]int64 `yaml:"body_sizes"`              // Per route overrides keyed by "METHOD /path"; 0 is unlimited
+}
+
+// IdempotencyConfig represents how requests sent with an Idempotency-Key are remembered
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl"` // How long a key and the response to its request are kept
+}
+
+// EventsConfig represents the lifecycle event log streamed from GET /events
+type EventsConfig struct {
+	Retention time.Duration `yaml:"retention"` // How long events are kept for clients resuming a stream
+}
+
+// WebhooksConfig represents how lifecycle events are delivered to webhook subscriptions
+type WebhooksConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`    // Attempts before a delivery becomes a dead letter
+	InitialBackoff time.Duration `yaml:"initial_backoff"` // Wait after the first failed attempt, doubled after each further one
+	MaxBackoff     time.Duration `yaml:"max_backoff"`     // Longest wait between attempts
+	Timeout        time.Duration `yaml:"timeout"`         // How long a subscriber may take to respond
+	Retention      time.Duration `yaml:"retention"`       // How long finished deliveries are kept in the delivery log
+	// CIDRs of non-public networks subscribers may be reached in; only public addresses otherwise
+	AllowedNetworks []string `yaml:"allowed_networks,omitempty"`
+}
+
+// UploadsConfig represents the resumable upload configuration
+type UploadsConfig struct {
+	SessionTTL      time.Duration `yaml:"session_ttl"`      // How long an idle session is kept
+	JanitorInterval time.Duration `yaml:"janitor_interval"` // How often stale sessions are expired
+	MaxLength       int64         `yaml:"max_length"`       // Largest upload a session may declare
+}
+
+// ImportsConfig represents the operator bulk import configuration
+type ImportsConfig struct {
+	Roots []string `yaml:"roots"` // Directories local imports are confined to; imports are disabled when empty
+}
+
+// GCConfig represents the garbage collection configuration
+type GCConfig struct {
+	Interval    time.Duration `yaml:"interval"`     // How often a scheduled run is started
+	GracePeriod time.Duration `yaml:"grace_period"` // Minimum age of anything collected, so in-flight writes are never taken
+	PieceTTL    time.Duration `yaml:"piece_ttl"`    // Age after which uploaded pieces outside any proof set are collected; zero keeps them
+	DryRun      bool          `yaml:"dry_run"`      // Scheduled runs only report what they would collect
+}
+
+// ScrubConfig represents the data integrity scrubber configuration
+type ScrubConfig struct {
+	Interval       time.Duration `yaml:"interval"`         // How often a pass over due pieces is started
+	ReverifyAfter  time.Duration `yaml:"reverify_after"`   // How long a successful verification is trusted
+	BytesPerSecond int64         `yaml:"bytes_per_second"` // Read rate limit; negative disables the limit
+}
+
 // LoadConfig loads configuration from a YAML file
 func LoadConfig(configPath string) (*Config, error) {
 	data, err := os.ReadFile(configPath)
@@ -36,7 +168,8 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var cfg Config
+	// Authentication is on unless require_auth is set to false
+	cfg := Config{Server: ServerConfig{RequireAuth: true}}
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
@@ -51,6 +184,85 @@ func LoadConfig(configPath string) (*Config, error) {
 	if cfg.PDP.DataDir == "" {
 		cfg.PDP.DataDir = "./data"
 	}
+	if cfg.Storage.Backend == "" {
+		cfg.Storage.Backend = "file"
+	}
+	// Credentials may be kept out of the config file
+	if cfg.Storage.S3.AccessKey == "" {
+		cfg.Storage.S3.AccessKey = os.Getenv("PDP_S3_ACCESS_KEY")
+	}
+	if cfg.Storage.S3.SecretKey == "" {
+		cfg.Storage.S3.SecretKey = os.Getenv("PDP_S3_SECRET_KEY")
+	}
+	if cfg.Storage.Tiering.HotHighWatermark == 0 {
+		cfg.Storage.Tiering.HotHighWatermark = 0.9
+	}
+	if cfg.Storage.Tiering.HotLowWatermark == 0 {
+		cfg.Storage.Tiering.HotLowWatermark = 0.75
+	}
+	if cfg.Storage.Tiering.ColdHighWatermark == 0 {
+		cfg.Storage.Tiering.ColdHighWatermark = 0.95
+	}
+	if cfg.Storage.Tiering.Interval == 0 {
+		cfg.Storage.Tiering.Interval = 5 * time.Minute
+	}
+	if cfg.Storage.Encryption.ReencryptInterval == 0 {
+		cfg.Storage.Encryption.ReencryptInterval = time.Hour
+	}
+	if cfg.Capacity.ReservationTTL == 0 {
+		cfg.Capacity.ReservationTTL = 24 * time.Hour
+	}
+	if cfg.Limits.MaxBodySize == 0 {
+		cfg.Limits.MaxBodySize = 1 << 20 // 1 MiB
+	}
+	if cfg.Limits.MaxUploadSize == 0 {
+		cfg.Limits.MaxUploadSize = 1 << 30 // 1 GiB
+	}
+	if cfg.Idempotency.TTL == 0 {
+		cfg.Idempotency.TTL = 24 * time.Hour
+	}
+	if cfg.Events.Retention == 0 {
+		cfg.Events.Retention = 7 * 24 * time.Hour
+	}
+	if cfg.Webhooks.MaxAttempts == 0 {
+		cfg.Webhooks.MaxAttempts = 8
+	}
+	if cfg.Webhooks.InitialBackoff == 0 {
+		cfg.Webhooks.InitialBackoff = 30 * time.Second
+	}
+	if cfg.Webhooks.MaxBackoff == 0 {
+		cfg.Webhooks.MaxBackoff = time.Hour
+	}
+	if cfg.Webhooks.Timeout == 0 {
+		cfg.Webhooks.Timeout = 10 * time.Second
+	}
+	if cfg.Webhooks.Retention == 0 {
+		cfg.Webhooks.Retention = 30 * 24 * time.Hour
+	}
+	if cfg.Uploads.SessionTTL == 0 {
+		cfg.Uploads.SessionTTL = 24 * time.Hour
+	}
+	if cfg.Uploads.JanitorInterval == 0 {
+		cfg.Uploads.JanitorInterval = 10 * time.Minute
+	}
+	if cfg.Uploads.MaxLength == 0 {
+		cfg.Uploads.MaxLength = 32 << 30 // 32 GiB
+	}
+	if cfg.GC.Interval == 0 {
+		cfg.GC.Interval = time.Hour
+	}
+	if cfg.GC.GracePeriod == 0 {
+		cfg.GC.GracePeriod = 24 * time.Hour
+	}
+	if cfg.Scrub.Interval == 0 {
+		cfg.Scrub.Interval = time.Hour
+	}
+	if cfg.Scrub.ReverifyAfter == 0 {
+		cfg.Scrub.ReverifyAfter = 7 * 24 * time.Hour
+	}
+	if cfg.Scrub.BytesPerSecond == 0 {
+		cfg.Scrub.BytesPerSecond = 32 << 20 // 32 MiB/s
+	}
 
 	return &cfg, nil
 }
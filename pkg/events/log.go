@@ -0,0 +1,213 @@
+// Package events keeps a log of piece and proof set lifecycle events, such as status
+// transitions, transaction confirmations, proof results and faults, and notifies streams
+// following the log as events are added. The log is persisted, so clients that lost their
+// connection resume after the last event they saw.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"gorm.io/gorm"
+)
+
+// Event types
+const (
+	PieceStatus          = "piece.status"          // A piece moved to another status
+	PieceFault           = "piece.fault"           // The stored data of a piece no longer matches its PieceCID
+	TransactionConfirmed = "transaction.confirmed" // A transaction adding roots to a proof set succeeded
+	TransactionFailed    = "transaction.failed"    // A transaction adding roots to a proof set failed on chain
+	ProofSucceeded       = "proof.succeeded"       // A proof of possession was submitted for a proof set
+	ProofFailed          = "proof.failed"          // Proving a proof set failed
+)
+
+// Types lists every event type
+var Types = []string{PieceStatus, PieceFault, TransactionConfirmed, TransactionFailed, ProofSucceeded, ProofFailed}
+
+// pruneInterval is how often events past the retention are deleted
+const pruneInterval = time.Hour
+
+// Event is a lifecycle event as sent to clients
+type Event struct {
+	ID         uint64          `json:"id"`
+	Type       string          `json:"type"`
+	PieceID    string          `json:"piece_id,omitempty"`
+	ProofSetID int64           `json:"proof_set_id,omitempty"`
+	Data       json.RawMessage `json:"data"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// Filter selects events; zero fields match any event
+type Filter struct {
+	Types      []string
+	PieceID    string
+	ProofSetID int64
+}
+
+// Log persists lifecycle events and notifies subscribers of new ones. A nil log drops
+// published events.
+type Log struct {
+	db          *gorm.DB
+	owners      *tenant.OwnerStore
+	retention   time.Duration
+	mutex       sync.Mutex // Guards subscribers
+	subscribers map[chan struct{}]struct{}
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewLog creates an event log keeping events for retention. Events about a proof set are only
+// visible to the tenants owning it, and other events to the tenants owning their piece.
+func NewLog(db *gorm.DB, owners *tenant.OwnerStore, retention time.Duration) *Log {
+	return &Log{
+		db:          db,
+		owners:      owners,
+		retention:   retention,
+		subscribers: make(map[chan struct{}]struct{}),
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Publish adds an event about a piece, a proof set or both to the log. Failing to record an
+// event does not fail the operation it describes, so errors are only logged.
+func (l *Log) Publish(ctx context.Context, typ, pieceID string, proofSetID int64, data interface{}) {
+	if l == nil {
+		return
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Warning: failed to encode %s event: %v", typ, err)
+		return
+	}
+	record := &models.Event{
+		Type:       typ,
+		PieceID:    pieceID,
+		ProofSetID: proofSetID,
+		Data:       encoded,
+	}
+	// Events describe what already happened, even when the request that caused it went away
+	if err := l.db.WithContext(context.WithoutCancel(ctx)).Create(record).Error; err != nil {
+		log.Printf("Warning: failed to record %s event: %v", typ, err)
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for notify := range l.subscribers {
+		select {
+		case notify <- struct{}{}:
+		default:
+			// Already notified; the subscriber reads every new event at once
+		}
+	}
+}
+
+// Subscribe returns a channel receiving a value whenever events were published since the
+// last receive, and a function ending the subscription
+func (l *Log) Subscribe() (<-chan struct{}, func()) {
+	notify := make(chan struct{}, 1)
+	l.mutex.Lock()
+	l.subscribers[notify] = struct{}{}
+	l.mutex.Unlock()
+	return notify, func() {
+		l.mutex.Lock()
+		delete(l.subscribers, notify)
+		l.mutex.Unlock()
+	}
+}
+
+// Since returns up to limit events after the given ID that match the filter and concern
+// pieces or proof sets the context may access, oldest first
+func (l *Log) Since(ctx context.Context, after uint64, filter Filter, limit int) ([]*Event, error) {
+	query := l.db.WithContext(ctx).Where("id > ?", after)
+	if len(filter.Types) > 0 {
+		query = query.Where("type IN ?", filter.Types)
+	}
+	if filter.PieceID != "" {
+		query = query.Where("piece_id = ?", filter.PieceID)
+	}
+	if filter.ProofSetID != 0 {
+		query = query.Where("proof_set_id = ?", filter.ProofSetID)
+	}
+	if !tenant.SeesAll(ctx) {
+		// A piece shared by several tenants may be in proof sets only some of them own
+		bySet := l.owners.Scope(ctx, l.db.Where("proof_set_id <> 0"), tenant.KindProofSet, "proof_set_id")
+		byPiece := l.owners.Scope(ctx, l.db.Where("proof_set_id = 0"), tenant.KindPiece, "piece_id")
+		query = query.Where(bySet.Or(byPiece))
+	}
+
+	var records []models.Event
+	if err := query.Order("id ASC").Limit(limit).Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+	events := make([]*Event, len(records))
+	for i, record := range records {
+		events[i] = &Event{
+			ID:         record.ID,
+			Type:       record.Type,
+			PieceID:    record.PieceID,
+			ProofSetID: record.ProofSetID,
+			Data:       json.RawMessage(record.Data),
+			CreatedAt:  record.CreatedAt,
+		}
+	}
+	return events, nil
+}
+
+// Latest returns the ID of the newest event, or zero when the log is empty
+func (l *Log) Latest(ctx context.Context) (uint64, error) {
+	var latest uint64
+	if err := l.db.WithContext(ctx).
+		Model(&models.Event{}).
+		Select("COALESCE(MAX(id), 0)").
+		Scan(&latest).Error; err != nil {
+		return 0, fmt.Errorf("failed to read latest event: %w", err)
+	}
+	return latest, nil
+}
+
+// Start begins deleting events past the retention
+func (l *Log) Start(ctx context.Context) error {
+	l.wg.Add(1)
+	go l.run(ctx)
+	return nil
+}
+
+// Stop stops deleting events
+func (l *Log) Stop() error {
+	close(l.stopChan)
+	l.wg.Wait()
+	return nil
+}
+
+// run deletes events past the retention on every tick until stopped
+func (l *Log) run(ctx context.Context) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.stopChan:
+			return
+		case <-ticker.C:
+			result := l.db.WithContext(ctx).
+				Where("created_at < ?", time.Now().Add(-l.retention)).
+				Delete(&models.Event{})
+			if result.Error != nil {
+				log.Printf("Error deleting old events: %v", result.Error)
+			} else if result.RowsAffected > 0 {
+				log.Printf("Deleted %d events older than %s", result.RowsAffected, l.retention)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/car"
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	boxogateway "github.com/ipfs/boxo/gateway"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// errReadOnly is returned for any attempt to write through the gateway blockstore
+var errReadOnly = errors.New("gateway blockstore is read-only")
+
+// NewHandler creates a trustless IPFS gateway handler serving blocks from ingested CAR pieces.
+// Only verifiable application/vnd.ipld.raw and application/vnd.ipld.car responses are produced;
+// dag-scope and entity-bytes are handled by the gateway implementation.
+func NewHandler(carSvc *car.CarService) (http.Handler, error) {
+	blockService := blockservice.New(&carBlockstore{carSvc: carSvc}, nil)
+
+	backend, err := boxogateway.NewBlocksBackend(blockService)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gateway backend: %w", err)
+	}
+
+	return boxogateway.NewHandler(boxogateway.Config{
+		DeserializedResponses: false,
+		NoDNSLink:             true,
+		DisableHTMLErrors:     true,
+	}, backend), nil
+}
+
+// carBlockstore is a read-only blockstore backed by the CAR block index
+type carBlockstore struct {
+	carSvc *car.CarService
+}
+
+var _ blockstore.Blockstore = (*carBlockstore)(nil)
+
+// Has reports whether any ingested CAR contains the block
+func (bs *carBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return bs.carSvc.HasBlock(ctx, c)
+}
+
+// Get reads the block out of the piece that contains it
+func (bs *carBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	data, err := bs.carSvc.GetBlock(ctx, c)
+	if err != nil {
+		if errors.Is(err, car.ErrBlockNotFound) {
+			return nil, format.ErrNotFound{Cid: c}
+		}
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// GetSize returns the size of the block from the index
+func (bs *carBlockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	size, err := bs.carSvc.GetBlockSize(ctx, c)
+	if err != nil {
+		if errors.Is(err, car.ErrBlockNotFound) {
+			return -1, format.ErrNotFound{Cid: c}
+		}
+		return -1, err
+	}
+	return int(size), nil
+}
+
+// Put is not supported; blocks enter the index through CAR ingestion
+func (bs *carBlockstore) Put(ctx context.Context, block blocks.Block) error {
+	return errReadOnly
+}
+
+// PutMany is not supported; blocks enter the index through CAR ingestion
+func (bs *carBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	return errReadOnly
+}
+
+// DeleteBlock is not supported; blocks leave the index with their piece
+func (bs *carBlockstore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	return errReadOnly
+}
+
+// AllKeysChan is not supported by the gateway blockstore
+func (bs *carBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return nil, errors.New("gateway blockstore does not support enumeration")
+}
+
+// HashOnRead is a no-op; block hashes are verified when a CAR is ingested
+func (bs *carBlockstore) HashOnRead(enabled bool) {}
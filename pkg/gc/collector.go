@@ -0,0 +1,440 @@
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/piece"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrRunNotFound is returned for unknown GC runs
+	ErrRunNotFound = apperr.New(apperr.NotFound, "GC_RUN_NOT_FOUND", "gc run not found")
+	// ErrRunInProgress is returned when a run is requested while another is still going
+	ErrRunInProgress = apperr.New(apperr.Conflict, "GC_RUN_IN_PROGRESS", "a gc run is already in progress")
+)
+
+// maxCandidates bounds how many collected items a run records in its report
+const maxCandidates = 1000
+
+// Collector removes blobs, pieces and tmp files that nothing references any more
+type Collector struct {
+	db          *gorm.DB
+	blobStore   blobstore.Blobstore
+	refStore    *blobstore.RefStore
+	pieceSvc    *piece.PieceService
+	tmpDir      string
+	interval    time.Duration
+	gracePeriod time.Duration // Minimum age before anything is collected
+	pieceTTL    time.Duration // Age after which pieces outside any proof set are collected; zero keeps them
+	dryRun      bool          // Scheduled runs only report
+	running     sync.Mutex
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+// RunInfo describes a GC run
+type RunInfo struct {
+	ID              string      `json:"id"`
+	Status          string      `json:"status"`
+	Trigger         string      `json:"trigger"`
+	DryRun          bool        `json:"dry_run"`
+	ScannedBlobs    int         `json:"scanned_blobs"`
+	OrphanedBlobs   int         `json:"orphaned_blobs"`
+	DeletedBlobs    int         `json:"deleted_blobs"`
+	ReclaimedBytes  int64       `json:"reclaimed_bytes"`
+	AbandonedPieces int         `json:"abandoned_pieces"`
+	DeletedPieces   int         `json:"deleted_pieces"`
+	StaleTmpFiles   int         `json:"stale_tmp_files"`
+	DeletedTmpFiles int         `json:"deleted_tmp_files"`
+	Candidates      []Candidate `json:"candidates"`
+	ErrorMessage    string      `json:"error_message,omitempty"`
+	StartedAt       time.Time   `json:"started_at"`
+	FinishedAt      *time.Time  `json:"finished_at,omitempty"`
+}
+
+// Candidate is an item a run collected, or would collect in a dry run
+type Candidate struct {
+	Kind   string `json:"kind"` // "blob", "piece", "tmp"
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+}
+
+// NewCollector creates a garbage collector over the blobstore, piece records and tmp directory
+func NewCollector(db *gorm.DB, blobStore blobstore.Blobstore, refStore *blobstore.RefStore, pieceSvc *piece.PieceService, tmpDir string, interval, gracePeriod, pieceTTL time.Duration, dryRun bool) *Collector {
+	return &Collector{
+		db:          db,
+		blobStore:   blobStore,
+		refStore:    refStore,
+		pieceSvc:    pieceSvc,
+		tmpDir:      tmpDir,
+		interval:    interval,
+		gracePeriod: gracePeriod,
+		pieceTTL:    pieceTTL,
+		dryRun:      dryRun,
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Start begins periodic collection
+func (c *Collector) Start(ctx context.Context) error {
+	// Runs interrupted by a restart never finished
+	if err := c.db.WithContext(ctx).
+		Model(&models.GCRun{}).
+		Where("status = ?", "running").
+		Updates(map[string]interface{}{
+			"status":        "failed",
+			"error_message": "interrupted by server restart",
+			"finished_at":   time.Now(),
+		}).Error; err != nil {
+		return fmt.Errorf("failed to fail interrupted gc runs: %w", err)
+	}
+
+	log.Printf("Starting garbage collector (interval %s, grace period %s, dry run %t)...", c.interval, c.gracePeriod, c.dryRun)
+
+	c.wg.Add(1)
+	go c.run(ctx)
+
+	return nil
+}
+
+// Stop stops periodic collection and waits for a run in progress
+func (c *Collector) Stop() error {
+	close(c.stopChan)
+	c.wg.Wait()
+	log.Printf("Garbage collector stopped")
+	return nil
+}
+
+// Trigger starts a manual run in the background
+func (c *Collector) Trigger(ctx context.Context, dryRun bool) (*RunInfo, error) {
+	if !c.running.TryLock() {
+		return nil, ErrRunInProgress
+	}
+
+	run, err := c.createRun(ctx, "manual", dryRun)
+	if err != nil {
+		c.running.Unlock()
+		return nil, err
+	}
+
+	// The request context ends with the response; the run must outlive it but not the collector
+	runCtx, cancel := context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer c.running.Unlock()
+		defer cancel()
+		go func() {
+			select {
+			case <-c.stopChan:
+				cancel()
+			case <-runCtx.Done():
+			}
+		}()
+		c.collect(runCtx, run)
+	}()
+
+	return toRunInfo(run), nil
+}
+
+// GetRun returns a GC run
+func (c *Collector) GetRun(ctx context.Context, id string) (*RunInfo, error) {
+	var run models.GCRun
+	if err := c.db.WithContext(ctx).Where("id = ?", id).First(&run).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRunNotFound
+		}
+		return nil, fmt.Errorf("failed to get gc run: %w", err)
+	}
+	return toRunInfo(&run), nil
+}
+
+// ListRuns returns recent GC runs, newest first
+func (c *Collector) ListRuns(ctx context.Context, limit int) ([]*RunInfo, error) {
+	var runs []models.GCRun
+	if err := c.db.WithContext(ctx).
+		Order("started_at DESC").
+		Limit(limit).
+		Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list gc runs: %w", err)
+	}
+
+	result := make([]*RunInfo, len(runs))
+	for i := range runs {
+		info := toRunInfo(&runs[i])
+		info.Candidates = nil // Listing only summarizes; fetch a run for its report
+		result[i] = info
+	}
+	return result, nil
+}
+
+// run collects on every tick until stopped
+func (c *Collector) run(ctx context.Context) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stopChan:
+			return
+		case <-ticker.C:
+			if !c.running.TryLock() {
+				continue
+			}
+			run, err := c.createRun(ctx, "scheduled", c.dryRun)
+			if err != nil {
+				log.Printf("Error starting gc run: %v", err)
+			} else {
+				c.collect(ctx, run)
+			}
+			c.running.Unlock()
+		}
+	}
+}
+
+// createRun records the start of a run
+func (c *Collector) createRun(ctx context.Context, trigger string, dryRun bool) (*models.GCRun, error) {
+	run := &models.GCRun{
+		ID:         uuid.New().String(),
+		Status:     "running",
+		Trigger:    trigger,
+		DryRun:     dryRun,
+		Candidates: datatypes.JSON("[]"),
+		StartedAt:  time.Now(),
+	}
+	if err := c.db.WithContext(ctx).Create(run).Error; err != nil {
+		return nil, fmt.Errorf("failed to create gc run: %w", err)
+	}
+	return run, nil
+}
+
+// collect performs a single pass over abandoned pieces, unreferenced blobs and stale tmp files
+func (c *Collector) collect(ctx context.Context, run *models.GCRun) {
+	var candidates []Candidate
+	report := func(candidate Candidate) {
+		if len(candidates) < maxCandidates {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	err := c.collectPieces(ctx, run, report)
+	if err == nil {
+		err = c.collectBlobs(ctx, run, report)
+	}
+	if err == nil {
+		err = c.collectTmpFiles(ctx, run, report)
+	}
+
+	now := time.Now()
+	run.Status = "completed"
+	run.FinishedAt = &now
+	if err != nil {
+		run.Status = "failed"
+		run.ErrorMessage = err.Error()
+	}
+	if candidates == nil {
+		candidates = []Candidate{}
+	}
+	run.Candidates, _ = json.Marshal(candidates)
+	if saveErr := c.db.WithContext(ctx).Save(run).Error; saveErr != nil {
+		log.Printf("Error saving gc run %s: %v", run.ID, saveErr)
+	}
+
+	if err != nil {
+		log.Printf("GC run %s failed: %v", run.ID, err)
+		return
+	}
+	log.Printf("GC run %s (dry run %t): %d/%d orphaned blobs deleted (%d bytes), %d/%d abandoned pieces deleted, %d/%d stale tmp files deleted",
+		run.ID, run.DryRun, run.DeletedBlobs, run.OrphanedBlobs, run.ReclaimedBytes,
+		run.DeletedPieces, run.AbandonedPieces, run.DeletedTmpFiles, run.StaleTmpFiles)
+}
+
+// collectPieces deletes prepared pieces that were never uploaded and, when a piece TTL is set,
+// uploaded pieces that never made it into a proof set
+func (c *Collector) collectPieces(ctx context.Context, run *models.GCRun, report func(Candidate)) error {
+	var prepared []models.Piece
+	if err := c.db.WithContext(ctx).
+		Where("status = ? AND updated_at < ?", "prepared", time.Now().Add(-c.gracePeriod)).
+		Find(&prepared).Error; err != nil {
+		return fmt.Errorf("failed to find abandoned prepared pieces: %w", err)
+	}
+	for i := range prepared {
+		c.collectPiece(ctx, run, &prepared[i], "prepared but never uploaded", report)
+	}
+	// Expectations tenants declared for pieces another tenant prepared first
+	if !run.DryRun {
+		if err := c.db.WithContext(ctx).
+			Where("updated_at < ?", time.Now().Add(-c.gracePeriod)).
+			Delete(&models.PiecePreparation{}).Error; err != nil {
+			log.Printf("GC failed to delete abandoned piece preparations: %v", err)
+		}
+	}
+
+	if c.pieceTTL <= 0 {
+		return nil
+	}
+
+	var unused []models.Piece
+	if err := c.db.WithContext(ctx).
+		Where("status = ? AND updated_at < ?", "uploaded", time.Now().Add(-c.pieceTTL)).
+		Where("id NOT IN (?)", c.db.Model(&models.PieceRoot{}).
+			Select("piece_id").
+			Where("status IN ?", []string{"pending_confirmation", "added_to_proofset"})).
+		Find(&unused).Error; err != nil {
+		return fmt.Errorf("failed to find abandoned pieces: %w", err)
+	}
+	for i := range unused {
+		c.collectPiece(ctx, run, &unused[i], "not in any proof set", report)
+	}
+	return nil
+}
+
+// collectPiece deletes a single abandoned piece, releasing its reference to the data
+func (c *Collector) collectPiece(ctx context.Context, run *models.GCRun, p *models.Piece, reason string, report func(Candidate)) {
+	run.AbandonedPieces++
+	report(Candidate{Kind: "piece", Key: p.ID, Size: p.Size, Reason: reason})
+	if run.DryRun {
+		return
+	}
+	if err := c.pieceSvc.DeletePiece(ctx, p.ID); err != nil {
+		log.Printf("GC failed to delete piece %s: %v", p.ID, err)
+		return
+	}
+	run.DeletedPieces++
+}
+
+// collectBlobs deletes blobs past the grace period that no piece, upload or proof set root
+// references. Only blobs with a record are deleted: data stored before blobs were reference
+// counted is adopted at startup, and anything else without a record is not known to be garbage.
+func (c *Collector) collectBlobs(ctx context.Context, run *models.GCRun, report func(Candidate)) error {
+	var unreferenced []string
+	if err := c.db.WithContext(ctx).
+		Model(&models.Blob{}).
+		Where("ref_count = 0").
+		Pluck("blob_key", &unreferenced).Error; err != nil {
+		return fmt.Errorf("failed to load blob references: %w", err)
+	}
+	dead := make(map[string]bool, len(unreferenced))
+	for _, key := range unreferenced {
+		dead[key] = true
+	}
+
+	cutoff := time.Now().Add(-c.gracePeriod)
+	for info, err := range c.blobStore.List(ctx, "") {
+		if err != nil {
+			return fmt.Errorf("failed to scan blobstore: %w", err)
+		}
+		run.ScannedBlobs++
+		if !dead[info.Key] || info.ModTime.After(cutoff) {
+			continue
+		}
+
+		run.OrphanedBlobs++
+		report(Candidate{Kind: "blob", Key: info.Key, Size: info.Size, Reason: "unreferenced"})
+		if run.DryRun {
+			continue
+		}
+
+		// Re-checked under the store lock in case it was referenced since the scan began
+		deleted, err := c.refStore.DeleteUnreferenced(ctx, info.Key)
+		if err != nil {
+			log.Printf("GC failed to delete blob %s: %v", info.Key, err)
+			continue
+		}
+		if deleted {
+			run.DeletedBlobs++
+			run.ReclaimedBytes += info.Size
+		}
+	}
+	return nil
+}
+
+// collectTmpFiles deletes tmp files past the grace period that no active upload session owns
+func (c *Collector) collectTmpFiles(ctx context.Context, run *models.GCRun, report func(Candidate)) error {
+	var active []string
+	if err := c.db.WithContext(ctx).
+		Model(&models.UploadSession{}).
+		Where("status = ?", "active").
+		Pluck("tmp_path", &active).Error; err != nil {
+		return fmt.Errorf("failed to list active upload sessions: %w", err)
+	}
+	owned := make(map[string]bool, len(active))
+	for _, path := range active {
+		owned[path] = true
+	}
+
+	cutoff := time.Now().Add(-c.gracePeriod)
+	err := filepath.Walk(c.tmpDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || owned[path] || info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		run.StaleTmpFiles++
+		report(Candidate{Kind: "tmp", Key: path, Size: info.Size(), Reason: "no active upload session"})
+		if run.DryRun {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("GC failed to delete tmp file %s: %v", path, err)
+			return nil
+		}
+		run.DeletedTmpFiles++
+		run.ReclaimedBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan tmp directory: %w", err)
+	}
+	return nil
+}
+
+// toRunInfo converts a run record to its API representation
+func toRunInfo(run *models.GCRun) *RunInfo {
+	info := &RunInfo{
+		ID:              run.ID,
+		Status:          run.Status,
+		Trigger:         run.Trigger,
+		DryRun:          run.DryRun,
+		ScannedBlobs:    run.ScannedBlobs,
+		OrphanedBlobs:   run.OrphanedBlobs,
+		DeletedBlobs:    run.DeletedBlobs,
+		ReclaimedBytes:  run.ReclaimedBytes,
+		AbandonedPieces: run.AbandonedPieces,
+		DeletedPieces:   run.DeletedPieces,
+		StaleTmpFiles:   run.StaleTmpFiles,
+		DeletedTmpFiles: run.DeletedTmpFiles,
+		Candidates:      []Candidate{},
+		ErrorMessage:    run.ErrorMessage,
+		StartedAt:       run.StartedAt,
+		FinishedAt:      run.FinishedAt,
+	}
+	if len(run.Candidates) > 0 {
+		json.Unmarshal(run.Candidates, &info.Candidates)
+	}
+	return info
+}
@@ -0,0 +1,196 @@
+// Package idempotency remembers the responses to mutating requests that clients send with an
+// Idempotency-Key, so that a client retrying a request after a timeout gets the original
+// response instead of running the request, and e.g. paying for a transaction, twice.
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxKeyLength bounds the keys clients may send
+const MaxKeyLength = 255
+
+// sweepInterval is how often expired keys are deleted
+const sweepInterval = time.Hour
+
+var (
+	// ErrInvalidKey is returned for keys that are too long
+	ErrInvalidKey = apperr.New(apperr.Invalid, "INVALID_IDEMPOTENCY_KEY", "invalid idempotency key")
+	// ErrKeyReused is returned when a key is sent again with a different request
+	ErrKeyReused = apperr.New(apperr.Unprocessable, "IDEMPOTENCY_KEY_REUSED", "idempotency key was used for a different request")
+	// ErrKeyInUse is returned when a key is sent again while its first request still runs
+	ErrKeyInUse = apperr.New(apperr.Conflict, "IDEMPOTENCY_KEY_IN_USE", "a request with this idempotency key is in progress")
+)
+
+// Response is a response remembered for a key
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Store keeps idempotency keys and the responses to their requests for a while
+type Store struct {
+	db       *gorm.DB
+	ttl      time.Duration
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewStore creates a store keeping keys for ttl after their first use
+func NewStore(db *gorm.DB, ttl time.Duration) *Store {
+	return &Store{
+		db:       db,
+		ttl:      ttl,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Begin claims a key of a scope for a request with the given fingerprint. It returns nil when
+// the request should run, after which the caller must Complete or Release the key. When the
+// key already completed the same request, it returns that request's response instead. Keys
+// used for another request fail with ErrKeyReused, and keys whose request still runs with
+// ErrKeyInUse.
+func (s *Store) Begin(ctx context.Context, scope, key, method, path, fingerprint string) (*Response, error) {
+	if len(key) > MaxKeyLength {
+		return nil, fmt.Errorf("%w: longer than %d characters", ErrInvalidKey, MaxKeyLength)
+	}
+
+	now := time.Now()
+	record := &models.IdempotencyRecord{
+		Scope:       scope,
+		Key:         key,
+		Method:      method,
+		Path:        path,
+		Fingerprint: fingerprint,
+		Status:      "pending",
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.ttl),
+	}
+	db := s.db.WithContext(ctx)
+	// A key left behind by an expired record is free again
+	if err := db.Where("scope = ? AND idempotency_key = ? AND expires_at < ?", scope, key, now).
+		Delete(&models.IdempotencyRecord{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to expire idempotency key: %w", err)
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+
+	var existing models.IdempotencyRecord
+	if err := db.Where("scope = ? AND idempotency_key = ?", scope, key).First(&existing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Released meanwhile; the client may retry
+			return nil, ErrKeyInUse
+		}
+		return nil, fmt.Errorf("failed to load idempotency key: %w", err)
+	}
+	if existing.Fingerprint != fingerprint {
+		return nil, fmt.Errorf("%w: first used for %s %s", ErrKeyReused, existing.Method, existing.Path)
+	}
+	if existing.Status != "completed" {
+		return nil, ErrKeyInUse
+	}
+
+	response := &Response{Status: existing.ResponseStatus, Body: existing.ResponseBody}
+	if len(existing.ResponseHeader) > 0 {
+		if err := json.Unmarshal(existing.ResponseHeader, &response.Header); err != nil {
+			return nil, fmt.Errorf("failed to decode remembered response: %w", err)
+		}
+	}
+	return response, nil
+}
+
+// Complete remembers the response to the request a key was claimed for
+func (s *Store) Complete(ctx context.Context, scope, key string, response *Response) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return fmt.Errorf("failed to encode response headers: %w", err)
+	}
+	if err := s.db.WithContext(ctx).
+		Model(&models.IdempotencyRecord{}).
+		Where("scope = ? AND idempotency_key = ?", scope, key).
+		Updates(map[string]interface{}{
+			"status":          "completed",
+			"response_status": response.Status,
+			"response_header": header,
+			"response_body":   response.Body,
+		}).Error; err != nil {
+		return fmt.Errorf("failed to remember response: %w", err)
+	}
+	return nil
+}
+
+// Release frees a key whose request should be run again when retried, e.g. after a server
+// error
+func (s *Store) Release(ctx context.Context, scope, key string) error {
+	if err := s.db.WithContext(ctx).
+		Where("scope = ? AND idempotency_key = ? AND status = ?", scope, key, "pending").
+		Delete(&models.IdempotencyRecord{}).Error; err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Start frees the keys of requests a previous run left unfinished and begins deleting expired
+// keys
+func (s *Store) Start(ctx context.Context) error {
+	if err := s.db.WithContext(ctx).
+		Where("status = ?", "pending").
+		Delete(&models.IdempotencyRecord{}).Error; err != nil {
+		return fmt.Errorf("failed to release unfinished idempotency keys: %w", err)
+	}
+
+	s.wg.Add(1)
+	go s.run(ctx)
+	return nil
+}
+
+// Stop stops deleting expired keys
+func (s *Store) Stop() error {
+	close(s.stopChan)
+	s.wg.Wait()
+	return nil
+}
+
+// run deletes expired keys on every tick until stopped
+func (s *Store) run(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			result := s.db.WithContext(ctx).
+				Where("expires_at < ?", time.Now()).
+				Delete(&models.IdempotencyRecord{})
+			if result.Error != nil {
+				log.Printf("Error deleting expired idempotency keys: %v", result.Error)
+			} else if result.RowsAffected > 0 {
+				log.Printf("Deleted %d expired idempotency keys", result.RowsAffected)
+			}
+		}
+	}
+}
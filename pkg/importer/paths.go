@@ -0,0 +1,113 @@
+package importer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+)
+
+var (
+	// ErrOutsideRoots is returned for paths that do not resolve inside a configured import root
+	ErrOutsideRoots = apperr.New(apperr.Forbidden, "OUTSIDE_IMPORT_ROOTS", "path is outside the configured import roots")
+	// ErrInvalidPath is returned for import paths that cannot be resolved, or no paths at all
+	ErrInvalidPath = apperr.New(apperr.Invalid, "INVALID_IMPORT_PATH", "invalid import path")
+)
+
+// resolver confines paths to a set of import roots, following symlinks before checking containment
+type resolver struct {
+	roots []string // Fully resolved absolute root directories
+}
+
+// newResolver resolves each configured root to its real absolute path
+func newResolver(roots []string) (*resolver, error) {
+	resolved := make([]string, 0, len(roots))
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid import root %s: %w", root, err)
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid import root %s: %w", root, err)
+		}
+		info, err := os.Stat(real)
+		if err != nil {
+			return nil, fmt.Errorf("invalid import root %s: %w", root, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("import root %s is not a directory", root)
+		}
+		resolved = append(resolved, real)
+	}
+	return &resolver{roots: resolved}, nil
+}
+
+// Resolve returns the real path for p, which may be absolute or relative to the first root,
+// and fails unless every symlink along the way stays inside an import root
+func (r *resolver) Resolve(p string) (string, error) {
+	if len(r.roots) == 0 {
+		return "", ErrOutsideRoots
+	}
+
+	candidate := p
+	if !filepath.IsAbs(candidate) {
+		candidate = filepath.Join(r.roots[0], candidate)
+	}
+
+	real, err := filepath.EvalSymlinks(filepath.Clean(candidate))
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to resolve %s: %v", ErrInvalidPath, p, err)
+	}
+	if !r.contains(real) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideRoots, p)
+	}
+	return real, nil
+}
+
+// Open opens a resolved regular file and verifies it is still the file that was resolved,
+// so a symlink swapped in after resolution cannot redirect the read
+func (r *resolver) Open(real string) (*os.File, os.FileInfo, error) {
+	if !r.contains(real) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrOutsideRoots, real)
+	}
+
+	linfo, err := os.Lstat(real)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !linfo.Mode().IsRegular() {
+		return nil, nil, fmt.Errorf("%s is not a regular file", real)
+	}
+
+	file, err := os.Open(real)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if !os.SameFile(linfo, info) {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s changed while being opened", real)
+	}
+	return file, info, nil
+}
+
+// contains reports whether a resolved path lies within one of the roots
+func (r *resolver) contains(real string) bool {
+	for _, root := range r.roots {
+		rel, err := filepath.Rel(root, real)
+		if err != nil {
+			continue
+		}
+		if rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))) {
+			return true
+		}
+	}
+	return false
+}
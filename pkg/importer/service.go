@@ -0,0 +1,370 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/piece"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// ErrJobNotFound is returned for unknown import jobs
+var ErrJobNotFound = apperr.New(apperr.NotFound, "IMPORT_NOT_FOUND", "import job not found")
+
+// ImportService imports files from operator-configured directories into pieces asynchronously
+type ImportService struct {
+	pieceSvc *piece.PieceService
+	db       *gorm.DB
+	resolver *resolver
+	queue    chan string
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// JobInfo describes the progress of an import job
+type JobInfo struct {
+	ID             string         `json:"id"`
+	Status         string         `json:"status"`
+	Tenant         string         `json:"tenant"`
+	Paths          []string       `json:"paths"`
+	TotalFiles     int            `json:"total_files"`
+	ProcessedFiles int            `json:"processed_files"`
+	TotalBytes     int64          `json:"total_bytes"`
+	ProcessedBytes int64          `json:"processed_bytes"`
+	Results        []ImportResult `json:"results"`
+	ErrorMessage   string         `json:"error_message,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	FinishedAt     *time.Time     `json:"finished_at,omitempty"`
+}
+
+// ImportResult records the outcome of importing a single file
+type ImportResult struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	PieceID  string `json:"piece_id,omitempty"`
+	PieceCID string `json:"piece_cid,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// NewImportService creates an import service confined to the given root directories
+func NewImportService(pieceSvc *piece.PieceService, db *gorm.DB, roots []string) (*ImportService, error) {
+	r, err := newResolver(roots)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImportService{
+		pieceSvc: pieceSvc,
+		db:       db,
+		resolver: r,
+		queue:    make(chan string, 100),
+		stopChan: make(chan struct{}),
+	}, nil
+}
+
+// Start resumes queued jobs and begins processing
+func (s *ImportService) Start(ctx context.Context) error {
+	// Jobs that were running when the server stopped cannot be resumed part way
+	if err := s.db.WithContext(ctx).
+		Model(&models.ImportJob{}).
+		Where("status = ?", "running").
+		Updates(map[string]interface{}{
+			"status":        "failed",
+			"error_message": "interrupted by server restart",
+			"finished_at":   time.Now(),
+		}).Error; err != nil {
+		return fmt.Errorf("failed to fail interrupted import jobs: %w", err)
+	}
+
+	var queued []models.ImportJob
+	if err := s.db.WithContext(ctx).
+		Where("status = ?", "queued").
+		Order("created_at ASC").
+		Find(&queued).Error; err != nil {
+		return fmt.Errorf("failed to load queued import jobs: %w", err)
+	}
+
+	s.wg.Add(1)
+	go s.run(ctx)
+
+	for _, job := range queued {
+		s.enqueue(job.ID)
+	}
+
+	log.Printf("Import service started with %d queued jobs", len(queued))
+	return nil
+}
+
+// Stop stops processing import jobs
+func (s *ImportService) Stop() error {
+	close(s.stopChan)
+	s.wg.Wait()
+	return nil
+}
+
+// CreateJob validates the requested paths and queues an import job for them. The imported
+// pieces belong to the context's tenant.
+func (s *ImportService) CreateJob(ctx context.Context, paths []string) (*JobInfo, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%w: at least one path is required", ErrInvalidPath)
+	}
+
+	resolved := make([]string, len(paths))
+	for i, p := range paths {
+		real, err := s.resolver.Resolve(p)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = real
+	}
+
+	pathsJSON, err := json.Marshal(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode paths: %w", err)
+	}
+
+	job := &models.ImportJob{
+		ID:      uuid.New().String(),
+		Status:  "queued",
+		Tenant:  tenant.FromContext(ctx),
+		Paths:   datatypes.JSON(pathsJSON),
+		Results: datatypes.JSON("[]"),
+	}
+	if err := s.db.WithContext(ctx).Create(job).Error; err != nil {
+		return nil, fmt.Errorf("failed to create import job: %w", err)
+	}
+
+	s.enqueue(job.ID)
+	log.Printf("Queued import job %s for %d paths", job.ID, len(resolved))
+	return toJobInfo(job), nil
+}
+
+// GetJob returns the progress of an import job
+func (s *ImportService) GetJob(ctx context.Context, id string) (*JobInfo, error) {
+	var job models.ImportJob
+	if err := s.db.WithContext(ctx).Where("id = ?", id).First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, fmt.Errorf("failed to get import job: %w", err)
+	}
+	return toJobInfo(&job), nil
+}
+
+// ListJobs returns all import jobs, newest first
+func (s *ImportService) ListJobs(ctx context.Context) ([]*JobInfo, error) {
+	var jobs []models.ImportJob
+	if err := s.db.WithContext(ctx).Order("created_at DESC").Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list import jobs: %w", err)
+	}
+
+	result := make([]*JobInfo, len(jobs))
+	for i := range jobs {
+		result[i] = toJobInfo(&jobs[i])
+	}
+	return result, nil
+}
+
+// enqueue hands a job to the worker without blocking the caller
+func (s *ImportService) enqueue(id string) {
+	go func() {
+		select {
+		case s.queue <- id:
+		case <-s.stopChan:
+		}
+	}()
+}
+
+// run processes queued jobs one at a time
+func (s *ImportService) run(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopChan:
+			return
+		case id := <-s.queue:
+			if err := s.processJob(ctx, id); err != nil {
+				log.Printf("Import job %s failed: %v", id, err)
+			}
+		}
+	}
+}
+
+// processJob expands the job's paths into files and imports each one as a piece
+func (s *ImportService) processJob(ctx context.Context, id string) error {
+	var job models.ImportJob
+	if err := s.db.WithContext(ctx).Where("id = ?", id).First(&job).Error; err != nil {
+		return fmt.Errorf("failed to load job: %w", err)
+	}
+	if job.Status != "queued" {
+		return nil
+	}
+
+	var paths []string
+	if err := json.Unmarshal(job.Paths, &paths); err != nil {
+		return s.failJob(ctx, &job, fmt.Errorf("invalid job paths: %w", err))
+	}
+
+	files, totalBytes, err := s.expand(paths)
+	if err != nil {
+		return s.failJob(ctx, &job, err)
+	}
+
+	job.Status = "running"
+	job.TotalFiles = len(files)
+	job.TotalBytes = totalBytes
+	if err := s.db.WithContext(ctx).Save(&job).Error; err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+
+	// Jobs run in the background, so the pieces are stored for the tenant that queued the job
+	owner := tenant.WithTenant(ctx, job.Tenant)
+	results := make([]ImportResult, 0, len(files))
+	failed := 0
+	for _, file := range files {
+		result := s.importFile(owner, file)
+		if result.Error != "" {
+			failed++
+		}
+		results = append(results, result)
+
+		job.ProcessedFiles++
+		job.ProcessedBytes += result.Size
+		job.Results, _ = json.Marshal(results)
+		if err := s.db.WithContext(ctx).Save(&job).Error; err != nil {
+			return fmt.Errorf("failed to update job progress: %w", err)
+		}
+	}
+
+	now := time.Now()
+	job.Status = "completed"
+	job.FinishedAt = &now
+	if failed > 0 {
+		job.ErrorMessage = fmt.Sprintf("%d of %d files failed to import", failed, len(files))
+	}
+	if err := s.db.WithContext(ctx).Save(&job).Error; err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+
+	log.Printf("Import job %s completed: %d files, %d failed", job.ID, len(files), failed)
+	return nil
+}
+
+// expand walks directories and returns every regular file inside the import roots
+func (s *ImportService) expand(paths []string) ([]string, int64, error) {
+	var files []string
+	var totalBytes int64
+
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			// Re-resolve every entry so symlinks inside the tree cannot escape the roots
+			real, err := s.resolver.Resolve(p)
+			if err != nil {
+				log.Printf("Skipping %s: %v", p, err)
+				return nil
+			}
+			info, err := os.Stat(real)
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			files = append(files, real)
+			totalBytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to walk %s: %w", root, err)
+		}
+	}
+
+	return files, totalBytes, nil
+}
+
+// importFile stores a single file as a piece
+func (s *ImportService) importFile(ctx context.Context, path string) ImportResult {
+	result := ImportResult{Path: path}
+
+	file, info, err := s.resolver.Open(path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer file.Close()
+	result.Size = info.Size()
+
+	if info.Size() == 0 {
+		result.Error = "file is empty"
+		return result
+	}
+
+	// Streamed from the file, so files larger than memory can be imported
+	pieceInfo, err := s.pieceSvc.UploadPieceFrom(ctx, "", file, info.Size())
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.PieceID = pieceInfo.ID
+	result.PieceCID = pieceInfo.PieceCID
+	return result
+}
+
+// failJob marks a job as failed
+func (s *ImportService) failJob(ctx context.Context, job *models.ImportJob, cause error) error {
+	now := time.Now()
+	job.Status = "failed"
+	job.ErrorMessage = cause.Error()
+	job.FinishedAt = &now
+	if err := s.db.WithContext(ctx).Save(job).Error; err != nil {
+		return fmt.Errorf("failed to mark job failed: %w", err)
+	}
+	return cause
+}
+
+// toJobInfo converts a job record to its API representation
+func toJobInfo(job *models.ImportJob) *JobInfo {
+	info := &JobInfo{
+		ID:             job.ID,
+		Status:         job.Status,
+		Tenant:         job.Tenant,
+		Paths:          []string{},
+		TotalFiles:     job.TotalFiles,
+		ProcessedFiles: job.ProcessedFiles,
+		TotalBytes:     job.TotalBytes,
+		ProcessedBytes: job.ProcessedBytes,
+		Results:        []ImportResult{},
+		ErrorMessage:   job.ErrorMessage,
+		CreatedAt:      job.CreatedAt,
+		FinishedAt:     job.FinishedAt,
+	}
+	json.Unmarshal(job.Paths, &info.Paths)
+	if len(job.Results) > 0 {
+		json.Unmarshal(job.Results, &info.Results)
+	}
+	return info
+}
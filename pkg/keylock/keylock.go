@@ -0,0 +1,22 @@
+// Package keylock serializes work on the same key without holding up unrelated keys
+package keylock
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// stripes is the number of mutexes key locks are spread over
+const stripes = 64
+
+// Locks serializes operations on the same key using a fixed set of mutexes, so unrelated
+// keys rarely contend and no per-key state needs cleaning up. Holding two keys' locks at
+// once may deadlock, as they can share a mutex.
+type Locks [stripes]sync.Mutex
+
+// Get returns the mutex guarding a key
+func (l *Locks) Get(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &l[h.Sum32()%stripes]
+}
@@ -0,0 +1,230 @@
+// Package listing pages through lists of records with opaque cursors. Pages are keyset based:
+// a cursor holds the sort value and a unique tiebreak of the last record returned, and the next
+// page starts right after that record, so records added or removed between requests neither
+// shift nor repeat the pages that follow.
+package listing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultLimit = 100  // Records per page when a query sets no limit
+	MaxLimit     = 1000 // Most records per page
+)
+
+var (
+	// ErrInvalidCursor is returned for cursors that are malformed or were issued for another sort
+	ErrInvalidCursor = apperr.New(apperr.Invalid, "INVALID_CURSOR", "invalid cursor")
+	// ErrInvalidSort is returned for sorts a listing does not support
+	ErrInvalidSort = apperr.New(apperr.Invalid, "INVALID_SORT", "invalid sort")
+	// ErrInvalidLimit is returned for limits outside 1 to MaxLimit
+	ErrInvalidLimit = apperr.New(apperr.Invalid, "INVALID_LIMIT", "invalid limit")
+)
+
+// Query asks for one page of a list
+type Query struct {
+	Limit  int    // Most records to return; zero for DefaultLimit
+	Cursor string // Next cursor of the previous page; empty for the first page
+	Sort   string // Field to sort by, prefixed with "-" for descending order; empty for the default
+
+	// Filters; lists ignore those that do not apply to their records
+	Status       string
+	ProofSetID   int64     // Zero matches any proof set
+	CreatedAfter time.Time // Zero matches any time
+	Tenant       string    // Only records this tenant owns; empty matches any tenant
+}
+
+// Kind is the type of a field's values
+type Kind int
+
+const (
+	String Kind = iota
+	Int         // int64 values
+	Time        // time.Time values
+)
+
+// Field is a field records can be sorted by
+type Field struct {
+	Name   string // As given in a query's sort, e.g. "created_at"
+	Column string // Column holding the field in SQL queries
+	Kind   Kind
+}
+
+// Listing describes how a list of records is paged
+type Listing struct {
+	Fields   []Field // Fields the list can be sorted by
+	Default  string  // Sort of queries that name none, e.g. "-created_at"
+	Tiebreak Field   // Unique field ordering records with equal sort values
+}
+
+// Sorts returns every sort a query may ask for
+func (l Listing) Sorts() []string {
+	sorts := make([]string, 0, 2*len(l.Fields))
+	for _, f := range l.Fields {
+		sorts = append(sorts, f.Name, "-"+f.Name)
+	}
+	return sorts
+}
+
+// Page is a query checked against a listing
+type Page struct {
+	Limit    int
+	Field    Field // Field records are sorted by
+	Desc     bool
+	Tiebreak Field
+	sort     string
+	after    *position // Last record of the previous page; nil for the first page
+}
+
+// position is the sort value and tiebreak of a record
+type position struct {
+	value    interface{}
+	tiebreak interface{}
+}
+
+// cursor is the JSON encoding of a position, tied to the sort it was issued for
+type cursor struct {
+	Sort     string `json:"s"`
+	Value    string `json:"v"`
+	Tiebreak string `json:"t"`
+}
+
+// Page checks a query's limit, sort and cursor against the listing
+func (l Listing) Page(q Query) (*Page, error) {
+	if q.Limit < 0 || q.Limit > MaxLimit {
+		return nil, fmt.Errorf("%w: must be between 1 and %d", ErrInvalidLimit, MaxLimit)
+	}
+	p := &Page{Limit: q.Limit, Tiebreak: l.Tiebreak, sort: q.Sort}
+	if p.Limit == 0 {
+		p.Limit = DefaultLimit
+	}
+	if p.sort == "" {
+		p.sort = l.Default
+	}
+
+	name := strings.TrimPrefix(p.sort, "-")
+	p.Desc = name != p.sort
+	found := false
+	for _, f := range l.Fields {
+		if f.Name == name {
+			p.Field, found = f, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %q, expected one of %s", ErrInvalidSort, q.Sort, strings.Join(l.Sorts(), ", "))
+	}
+
+	if q.Cursor == "" {
+		return p, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != p.sort {
+		return nil, fmt.Errorf("%w: issued for sort %q", ErrInvalidCursor, c.Sort)
+	}
+	value, err := decode(c.Value, p.Field.Kind)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	tiebreak, err := decode(c.Tiebreak, p.Tiebreak.Kind)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	p.after = &position{value: value, tiebreak: tiebreak}
+	return p, nil
+}
+
+// Apply restricts a query to the records of the page, in order. It asks for one record more
+// than the limit, which tells whether another page follows.
+func (p *Page) Apply(db *gorm.DB) *gorm.DB {
+	op, dir := ">", "ASC"
+	if p.Desc {
+		op, dir = "<", "DESC"
+	}
+	if p.after != nil {
+		col, tie := p.Field.Column, p.Tiebreak.Column
+		db = db.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", col, op, col, tie, op),
+			p.after.value, p.after.value, p.after.tiebreak)
+	}
+	return db.Order(p.Field.Column + " " + dir).Order(p.Tiebreak.Column + " " + dir).Limit(p.Limit + 1)
+}
+
+// Less orders records held in memory, given their sort values and tiebreaks
+func (p *Page) Less(value1, tiebreak1, value2, tiebreak2 interface{}) bool {
+	c := compare(value1, value2)
+	if c == 0 {
+		c = compare(tiebreak1, tiebreak2)
+	}
+	if p.Desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+// Follows reports whether a record held in memory belongs after the previous page
+func (p *Page) Follows(value, tiebreak interface{}) bool {
+	return p.after == nil || p.Less(p.after.value, p.after.tiebreak, value, tiebreak)
+}
+
+// Next returns the cursor of the page following a page whose last record has the given sort
+// value and tiebreak
+func (p *Page) Next(value, tiebreak interface{}) string {
+	data, _ := json.Marshal(cursor{Sort: p.sort, Value: encode(value), Tiebreak: encode(tiebreak)})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func encode(value interface{}) string {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func decode(s string, kind Kind) (interface{}, error) {
+	switch kind {
+	case Int:
+		return strconv.ParseInt(s, 10, 64)
+	case Time:
+		return time.Parse(time.RFC3339Nano, s)
+	default:
+		return s, nil
+	}
+}
+
+// compare orders two values of the same kind
+func compare(a, b interface{}) int {
+	switch a := a.(type) {
+	case int64:
+		b := b.(int64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
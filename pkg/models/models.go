@@ -74,3 +74,252 @@ type PDPProofSetCreate struct {
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
 }
+
+// Piece tracks a piece stored by this server, keyed by the sha2-256 digest of its raw data
+type Piece struct {
+	ID               string `gorm:"primaryKey"` // Hex encoded sha2-256 of the raw data
+	PieceCID         string `gorm:"column:piece_cid;index"`
+	CommP            string
+	Size             int64      // Padded size, or the declared raw size while prepared
+	RawSize          int64      `gorm:"not null"`
+	Status           string     `gorm:"index;not null;default:'prepared'"` // "prepared" or "uploaded"; proof set state is kept per root
+	ExpectedPieceCID string     `gorm:"column:expected_piece_cid"`
+	LastVerifiedAt   *time.Time `gorm:"index"`                        // When the scrubber last recomputed the PieceCID
+	Corrupt          bool       `gorm:"index;not null;default:false"` // Stored data no longer matches the PieceCID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// PieceRoot records a piece added as a root of a proof set
+type PieceRoot struct {
+	ID                   uint   `gorm:"primaryKey"`
+	PieceID              string `gorm:"uniqueIndex:idx_piece_root;not null"`
+	ProofSetID           int64  `gorm:"uniqueIndex:idx_piece_root;not null"`
+	PieceCID             string `gorm:"column:piece_cid;index;not null"`
+	RootCID              string
+	Status               string `gorm:"not null;default:'pending_confirmation'"` // "pending_confirmation", "added_to_proofset", "transaction_failed", "error"
+	TransactionHash      string `gorm:"index"`
+	TransactionTimestamp time.Time
+	ErrorMessage         string
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+// PiecePreparation records what a tenant expects of a piece another tenant prepared first,
+// whose expectations stay on the piece record
+type PiecePreparation struct {
+	PieceID          string `gorm:"primaryKey"`
+	Tenant           string `gorm:"primaryKey"`
+	RawSize          int64  `gorm:"not null"`
+	ExpectedPieceCID string `gorm:"column:expected_piece_cid"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time `gorm:"index"`
+}
+
+// Blob records a content-addressed blob and how many references keep it alive
+type Blob struct {
+	Key       string `gorm:"primaryKey;column:blob_key"` // PieceCID of the stored data
+	Size      int64  `gorm:"not null"`
+	RefCount  int64  `gorm:"not null;default:0"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// BlobRef records one piece, upload or proof set root referencing a blob
+type BlobRef struct {
+	ID        uint   `gorm:"primaryKey"`
+	BlobKey   string `gorm:"uniqueIndex:idx_blob_ref;not null"`
+	Kind      string `gorm:"uniqueIndex:idx_blob_ref;not null"` // "piece", "upload", "root"
+	RefID     string `gorm:"uniqueIndex:idx_blob_ref;not null"`
+	CreatedAt time.Time
+}
+
+// BlobEncryption records whether a blob is stored encrypted, so that an encrypted blob whose
+// header was stripped or replaced is refused instead of being served as plaintext
+type BlobEncryption struct {
+	Key       string `gorm:"primaryKey;column:blob_key"`
+	Encrypted bool   `gorm:"not null"` // False for blobs written before encryption was enabled
+	UpdatedAt time.Time
+}
+
+// CarRoot records a root CID declared in the header of an ingested CAR file
+type CarRoot struct {
+	ID        uint   `gorm:"primaryKey"`
+	PieceID   string `gorm:"index;not null"`
+	PieceCID  string `gorm:"column:piece_cid;index;not null"`
+	RootCID   string `gorm:"index;not null"`
+	CreatedAt time.Time
+}
+
+// CarBlock indexes a single block inside an ingested CAR file
+type CarBlock struct {
+	ID        uint   `gorm:"primaryKey"`
+	PieceCID  string `gorm:"column:piece_cid;index;not null"`
+	BlockCID  string `gorm:"not null"`
+	Multihash string `gorm:"index;not null"` // Hex encoded, so CIDv0/v1 of the same block resolve alike
+	Offset    int64  `gorm:"not null"`       // Offset of the block data within the CAR bytes
+	Size      int64  `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// UploadSession tracks a resumable chunked upload
+type UploadSession struct {
+	ID        string `gorm:"primaryKey"`
+	Length    int64  `gorm:"not null"`
+	Offset    int64  `gorm:"not null;default:0"`
+	TmpPath   string `gorm:"not null"`
+	Status    string `gorm:"not null;default:'active'"` // "active", "finalized", "expired"
+	PieceID   string
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ImportJob tracks an asynchronous bulk import of local files into pieces
+type ImportJob struct {
+	ID             string         `gorm:"primaryKey"`
+	Status         string         `gorm:"not null;default:'queued'"`        // "queued", "running", "completed", "failed"
+	Tenant         string         `gorm:"index;not null;default:'default'"` // Owner of the imported pieces
+	Paths          datatypes.JSON `gorm:"not null"`
+	TotalFiles     int            `gorm:"not null;default:0"`
+	ProcessedFiles int            `gorm:"not null;default:0"`
+	TotalBytes     int64          `gorm:"not null;default:0"`
+	ProcessedBytes int64          `gorm:"not null;default:0"`
+	Results        datatypes.JSON
+	ErrorMessage   string
+	FinishedAt     *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// GCRun records a garbage collection pass and what it found
+type GCRun struct {
+	ID              string `gorm:"primaryKey"`
+	Status          string `gorm:"not null;default:'running'"` // "running", "completed", "failed"
+	Trigger         string `gorm:"not null"`                   // "scheduled", "manual"
+	DryRun          bool   `gorm:"not null;default:false"`
+	ScannedBlobs    int
+	OrphanedBlobs   int
+	DeletedBlobs    int
+	ReclaimedBytes  int64
+	AbandonedPieces int
+	DeletedPieces   int
+	StaleTmpFiles   int
+	DeletedTmpFiles int
+	Candidates      datatypes.JSON // What was (or in a dry run, would be) collected
+	ErrorMessage    string
+	StartedAt       time.Time `gorm:"index"`
+	FinishedAt      *time.Time
+}
+
+// ScrubAlert records stored data found not to match its PieceCID
+type ScrubAlert struct {
+	ID        uint   `gorm:"primaryKey"`
+	PieceCID  string `gorm:"column:piece_cid;index;not null"`
+	Message   string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// Reservation holds storage space for data that has been announced but not yet written
+type Reservation struct {
+	Ref       string     `gorm:"primaryKey"` // What the space is held for, e.g. "piece:<tenant>:<id>" or "session:<id>"
+	Tenant    string     `gorm:"index;not null"`
+	Bytes     int64      `gorm:"not null"`
+	ExpiresAt *time.Time `gorm:"index"` // Nil holds the space until released
+	CreatedAt time.Time
+}
+
+// Owner records a tenant owning a piece, file, proof set or upload session. Content-addressed
+// pieces and files may be owned by several tenants at once.
+type Owner struct {
+	Kind      string `gorm:"primaryKey"` // "piece", "file", "proofset", "session"
+	RefID     string `gorm:"primaryKey"`
+	Tenant    string `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+// APIToken is a bearer token issued to an API client. Only the SHA-256 of the secret is kept.
+type APIToken struct {
+	ID         string         `gorm:"primaryKey"`
+	Name       string         `gorm:"not null"`
+	Hash       string         `gorm:"uniqueIndex;not null"` // Hex SHA-256 of the token secret
+	Tenant     string         `gorm:"index;not null;default:'default'"`
+	Scopes     datatypes.JSON `gorm:"not null"`
+	ExpiresAt  *time.Time     // Nil never expires
+	RevokedAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// IdempotencyRecord remembers a mutating request sent with an Idempotency-Key and, once it
+// completed, its response, so that retries of the request are answered with that response
+type IdempotencyRecord struct {
+	Scope          string `gorm:"primaryKey"`                        // Client and tenant the key belongs to
+	Key            string `gorm:"primaryKey;column:idempotency_key"` // As sent by the client
+	Method         string `gorm:"not null"`
+	Path           string `gorm:"not null"`
+	Fingerprint    string `gorm:"not null"`                   // Hex SHA-256 of what identifies the request
+	Status         string `gorm:"not null;default:'pending'"` // "pending", "completed"
+	ResponseStatus int
+	ResponseHeader datatypes.JSON
+	ResponseBody   []byte
+	CreatedAt      time.Time
+	ExpiresAt      time.Time `gorm:"index;not null"`
+}
+
+// Event records a piece or proof set lifecycle event. IDs increase, so clients resume a
+// stream of events after the last ID they saw.
+type Event struct {
+	ID         uint64         `gorm:"primaryKey;autoIncrement"`
+	Type       string         `gorm:"index;not null"` // e.g. "piece.status", "proof.failed"
+	PieceID    string         `gorm:"index"`          // Empty for events about a proof set only
+	ProofSetID int64          `gorm:"index"`          // Zero for events about a piece only
+	Data       datatypes.JSON `gorm:"not null"`
+	CreatedAt  time.Time      `gorm:"index"`
+}
+
+// WebhookSubscription sends a tenant's lifecycle events of the chosen types to a URL
+type WebhookSubscription struct {
+	ID          string         `gorm:"primaryKey"`
+	Tenant      string         `gorm:"index;not null"`
+	AllTenants  bool           `gorm:"not null"` // Created by an operator; receives events about every tenant's resources
+	URL         string         `gorm:"not null"`
+	Secret      string         `gorm:"not null"` // Signs deliveries, so it is kept in the clear
+	EventTypes  datatypes.JSON `gorm:"not null"` // Empty for every type
+	Active      bool           `gorm:"not null"`
+	LastEventID uint64         `gorm:"not null"` // Newest event considered for delivery
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// WebhookDelivery is an event sent, or still to be sent, to a subscription
+type WebhookDelivery struct {
+	ID             uint64    `gorm:"primaryKey;autoIncrement"`
+	SubscriptionID string    `gorm:"uniqueIndex:idx_webhook_delivery;not null"`
+	EventID        uint64    `gorm:"uniqueIndex:idx_webhook_delivery;not null"`
+	EventType      string    `gorm:"not null"`
+	Payload        []byte    `gorm:"not null"`
+	Status         string    `gorm:"index;not null;default:'pending'"` // "pending", "delivered", "dead"
+	Attempts       int       `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time `gorm:"index"`
+	ResponseStatus int       // Of the last attempt; zero when no response arrived
+	LastError      string
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time `gorm:"index"`
+	UpdatedAt      time.Time
+}
+
+// WebhookDeadLetter keeps a delivery that failed every attempt. Dead letters outlive their
+// delivery and subscription, so operators can inspect and replay them.
+type WebhookDeadLetter struct {
+	ID             uint64 `gorm:"primaryKey;autoIncrement"`
+	DeliveryID     uint64 `gorm:"uniqueIndex;not null"`
+	SubscriptionID string `gorm:"index;not null"`
+	URL            string `gorm:"not null"`
+	EventID        uint64 `gorm:"not null"`
+	EventType      string `gorm:"not null"`
+	Payload        []byte `gorm:"not null"`
+	Attempts       int    `gorm:"not null"`
+	LastError      string
+	CreatedAt      time.Time
+}
@@ -8,15 +8,22 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
 	"errors"
 
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
 	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/capacity"
+	"github.com/Datazen-Protocol/pdp-server/pkg/events"
+	"github.com/Datazen-Protocol/pdp-server/pkg/keylock"
+	"github.com/Datazen-Protocol/pdp-server/pkg/listing"
 	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/proofset"
 	"github.com/Datazen-Protocol/pdp-server/pkg/service"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
 	"github.com/filecoin-project/go-commp-utils/nonffi"
 	commcid "github.com/filecoin-project/go-fil-commcid"
 	commp "github.com/filecoin-project/go-fil-commp-hashhash"
@@ -24,103 +31,278 @@ import (
 	"github.com/ipfs/go-cid"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // PieceService handles piece preparation and upload using our own system
 type PieceService struct {
-	piriService service.PDPService
-	blobStore   blobstore.Blobstore
-	db          *gorm.DB
-	mutex       sync.RWMutex
-	pieces      map[string]*PieceInfo
+	piriService    service.PDPService
+	refStore       *blobstore.RefStore
+	capacity       *capacity.Manager
+	owners         *tenant.OwnerStore
+	events         *events.Log
+	reservationTTL time.Duration // How long a prepared piece holds its space
+	db             *gorm.DB
+	locks          keylock.Locks // Serialize read-modify-write of piece records per piece
 }
 
 // PieceInfo represents information about a prepared piece
 type PieceInfo struct {
-	ID                   string    `json:"id"`
-	FilePath             string    `json:"file_path"`
-	Size                 int64     `json:"size"`
-	CommP                string    `json:"comm_p"`
-	PieceCID             string    `json:"piece_cid"`
-	DataCID              string    `json:"data_cid"`
-	ProofSetID           int64     `json:"proof_set_id,omitempty"`
-	Status               string    `json:"status"` // "prepared", "uploaded", "added_to_proofset"
-	ErrorMessage         string    `json:"error_message,omitempty"`
-	UploadURL            string    `json:"upload_url,omitempty"` // Piri's upload URL
+	ID                   string     `json:"id"` // Hex encoded sha2-256 of the raw data
+	Size                 int64      `json:"size"`
+	CommP                string     `json:"comm_p"`
+	PieceCID             string     `json:"piece_cid"`
+	DataCID              string     `json:"data_cid"`
+	ProofSetID           int64      `json:"proof_set_id,omitempty"`
+	Status               string     `json:"status"` // "prepared", "uploaded", or the status of the latest root
+	ErrorMessage         string     `json:"error_message,omitempty"`
+	UploadURL            string     `json:"upload_url,omitempty"` // Where to PUT the data of a prepared piece
+	TransactionHash      string     `json:"transaction_hash,omitempty"`
+	TransactionTimestamp time.Time  `json:"transaction_timestamp,omitempty"`
+	Check                *Check     `json:"check,omitempty"`              // Hash and size of the raw data
+	ExpectedPieceCID     string     `json:"expected_piece_cid,omitempty"` // Expected PieceCID declared at prepare time
+	LastVerifiedAt       *time.Time `json:"last_verified_at,omitempty"`   // When the stored data was last checked against the PieceCID
+	Corrupt              bool       `json:"corrupt,omitempty"`            // Stored data no longer matches the PieceCID
+	Roots                []RootInfo `json:"roots,omitempty"`              // Roots in proof sets the caller owns, oldest transaction first
+}
+
+// RootInfo describes a piece added as a root of a proof set
+type RootInfo struct {
+	ProofSetID           int64     `json:"proof_set_id"`
+	RootCID              string    `json:"root_cid,omitempty"`
+	Status               string    `json:"status"` // "pending_confirmation", "added_to_proofset", "transaction_failed", "error"
 	TransactionHash      string    `json:"transaction_hash,omitempty"`
 	TransactionTimestamp time.Time `json:"transaction_timestamp,omitempty"`
+	ErrorMessage         string    `json:"error_message,omitempty"`
 }
 
-// NewPieceService creates a new piece service
-func NewPieceService(piriService service.PDPService, blobStore blobstore.Blobstore, db *gorm.DB) *PieceService {
+// Check describes the hash and size a client expects its upload to have
+type Check struct {
+	Name string `json:"name"`                           // Hash function name, only "sha2-256" is supported
+	Hash string `json:"hash" validate:"required"`       // Hex encoded digest of the raw data
+	Size int64  `json:"size" validate:"required,min=1"` // Raw data size in bytes
+}
+
+// PrepareRequest describes a piece a client intends to upload
+type PrepareRequest struct {
+	Check    *Check `json:"check,omitempty" validate:"required"`
+	PieceCID string `json:"piece_cid,omitempty"`
+}
+
+var (
+	// ErrVerificationFailed is returned when uploaded data does not match the prepared expectations
+	ErrVerificationFailed = apperr.New(apperr.Unprocessable, "VERIFICATION_FAILED", "upload verification failed")
+	// ErrPieceNotFound is returned for unknown piece IDs and PieceCIDs
+	ErrPieceNotFound = apperr.New(apperr.NotFound, "PIECE_NOT_FOUND", "piece not found")
+	// ErrInvalidPiece is returned for malformed checks and PieceCIDs
+	ErrInvalidPiece = apperr.New(apperr.Invalid, "INVALID_PIECE", "invalid piece")
+	// ErrPieceNotUploaded is returned for operations on prepared pieces that need their data
+	ErrPieceNotUploaded = apperr.New(apperr.InvalidState, "PIECE_NOT_UPLOADED", "piece is not uploaded")
+	// ErrPieceCorrupt is returned for pieces that failed integrity verification
+	ErrPieceCorrupt = apperr.New(apperr.InvalidState, "PIECE_CORRUPT", "piece failed integrity verification")
+	// ErrAlreadyInProofSet is returned when adding a piece to a proof set it is already a root of
+	ErrAlreadyInProofSet = apperr.New(apperr.Conflict, "ALREADY_IN_PROOF_SET", "piece is already in the proof set")
+	// ErrTransactionPending is returned for pieces that have a proof set transaction in flight
+	ErrTransactionPending = apperr.New(apperr.InvalidState, "TRANSACTION_PENDING", "piece has a pending proof set transaction")
+	// ErrNoPendingTransaction is returned when monitoring a piece without a transaction in flight
+	ErrNoPendingTransaction = apperr.New(apperr.InvalidState, "NO_PENDING_TRANSACTION", "piece has no pending transaction")
+)
+
+// NewPieceService creates a new piece service. Prepared pieces reserve their space with
+// capacityMgr for reservationTTL, or until uploaded or deleted. Pieces are only visible to
+// the tenants recorded as their owners. Status transitions are published to eventLog.
+func NewPieceService(piriService service.PDPService, refStore *blobstore.RefStore, capacityMgr *capacity.Manager, owners *tenant.OwnerStore, eventLog *events.Log, reservationTTL time.Duration, db *gorm.DB) *PieceService {
 	return &PieceService{
-		piriService: piriService,
-		blobStore:   blobStore,
-		db:          db,
-		pieces:      make(map[string]*PieceInfo),
+		piriService:    piriService,
+		refStore:       refStore,
+		capacity:       capacityMgr,
+		owners:         owners,
+		events:         eventLog,
+		reservationTTL: reservationTTL,
+		db:             db,
 	}
 }
 
-// PreparePiece prepares a file for upload using our own system
-func (p *PieceService) PreparePiece(ctx context.Context, filePath string) (*PieceInfo, error) {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-
-	// Read file and calculate SHA256 hash
-	file, err := os.Open(filePath)
+// PreparePiece registers a piece ahead of its upload, recording the hash, size and PieceCID
+// the client expects so that the upload can be verified against them. The piece ID is the
+// declared sha2-256 digest, so preparing data that is already stored returns the existing piece.
+// Tenants that do not own stored or prepared data only learn of it by uploading it, so to them
+// it looks freshly prepared, and their expectations never replace those of the tenant that
+// prepared the piece first.
+func (p *PieceService) PreparePiece(ctx context.Context, req *PrepareRequest) (*PieceInfo, error) {
+	check, err := normalizeCheck(req.Check)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %v", err)
+		return nil, err
+	}
+	if check == nil {
+		return nil, fmt.Errorf("%w: check is required", ErrInvalidPiece)
 	}
-	defer file.Close()
+	if req.PieceCID != "" {
+		if _, err := cid.Decode(req.PieceCID); err != nil {
+			return nil, fmt.Errorf("%w: bad piece CID: %v", ErrInvalidPiece, err)
+		}
+	}
+
+	pieceID := check.Hash
+	lock := p.locks.Get(pieceID)
+	lock.Lock()
+	defer lock.Unlock()
 
-	// Get file info
-	fileInfo, err := file.Stat()
+	existing, err := p.loadPiece(ctx, pieceID)
+	if err != nil && !errors.Is(err, ErrPieceNotFound) {
+		return nil, err
+	}
+	owned, err := p.owners.Owns(ctx, tenant.KindPiece, pieceID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get file info: %v", err)
+		return nil, err
+	}
+	if existing != nil && existing.Status != "prepared" && owned {
+		// The data is already here; the declared size and PieceCID must still agree with it
+		if existing.RawSize != check.Size {
+			return nil, fmt.Errorf("%w: piece %s has %d bytes, not %d", ErrInvalidPiece, pieceID, existing.RawSize, check.Size)
+		}
+		if req.PieceCID != "" && req.PieceCID != existing.PieceCID {
+			return nil, fmt.Errorf("%w: piece %s has piece CID %s, not %s", ErrInvalidPiece, pieceID, existing.PieceCID, req.PieceCID)
+		}
+		log.Printf("Piece %s is already stored, skipping prepare", pieceID)
+		return p.info(ctx, existing)
 	}
 
-	// Calculate SHA256 hash
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return nil, fmt.Errorf("failed to calculate hash: %v", err)
+	if existing != nil && existing.Status == "prepared" && !owned {
+		// Prepared by another tenant, whose expectations stay on the record and whose
+		// reservation already holds the space the same data needs. The caller's expectations
+		// are kept apart, to verify its own upload against.
+		preparation := &models.PiecePreparation{
+			PieceID:          pieceID,
+			Tenant:           tenant.FromContext(ctx),
+			RawSize:          check.Size,
+			ExpectedPieceCID: req.PieceCID,
+		}
+		if err := p.db.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "piece_id"}, {Name: "tenant"}},
+			DoUpdates: clause.AssignmentColumns([]string{"raw_size", "expected_piece_cid", "updated_at"}),
+		}).Create(preparation).Error; err != nil {
+			return nil, fmt.Errorf("failed to save piece preparation: %w", err)
+		}
+		log.Printf("Prepared piece %s for tenant %s, which another tenant prepared first", pieceID, tenant.FromContext(ctx))
+		return toPieceInfo(&models.Piece{
+			ID:               pieceID,
+			Size:             check.Size,
+			RawSize:          check.Size,
+			Status:           "prepared",
+			ExpectedPieceCID: req.PieceCID,
+		}, nil), nil
 	}
-	hashBytes := hash.Sum(nil)
 
-	// Generate a unique ID for our piece tracking
-	pieceID := fmt.Sprintf("%x", hashBytes[:8]) // Use first 8 bytes of hash as ID
+	// Hold space for the padded piece so the upload cannot be refused for lack of it
+	ref := reservationRef(tenant.FromContext(ctx), pieceID)
+	if err := p.capacity.Reserve(ctx, tenant.FromContext(ctx), ref, paddedSize(check.Size), p.reservationTTL); err != nil {
+		log.Printf("Rejected prepare for piece %s: %v", pieceID, err)
+		return nil, err
+	}
 
-	// Create piece info
-	pieceInfo := &PieceInfo{
-		ID:        pieceID,
-		FilePath:  filePath,
-		Size:      fileInfo.Size(),
-		Status:    "prepared",
-		UploadURL: "", // No upload URL needed for our system
+	record := &models.Piece{
+		ID:               pieceID,
+		Size:             check.Size,
+		RawSize:          check.Size,
+		Status:           "prepared",
+		ExpectedPieceCID: req.PieceCID,
+	}
+	if existing != nil && existing.Status != "prepared" {
+		// Stored for another tenant; the caller must upload the data to become an owner
+		log.Printf("Prepared piece %s for tenant %s, which does not own the stored data", pieceID, tenant.FromContext(ctx))
+		return toPieceInfo(record, nil), nil
+	}
+	if err := p.db.WithContext(ctx).Save(record).Error; err != nil {
+		if relErr := p.capacity.Release(ctx, ref); relErr != nil {
+			log.Printf("Warning: failed to release reservation for piece %s: %v", pieceID, relErr)
+		}
+		return nil, fmt.Errorf("failed to save piece: %w", err)
+	}
+	if err := p.owners.Claim(ctx, tenant.KindPiece, pieceID); err != nil {
+		return nil, err
 	}
+	p.publishStatus(ctx, record)
 
-	p.pieces[pieceID] = pieceInfo
-	log.Printf("Prepared piece %s for file %s", pieceID, filePath)
-	return pieceInfo, nil
+	log.Printf("Prepared piece %s expecting %d bytes", pieceID, check.Size)
+	return toPieceInfo(record, nil), nil
 }
 
-// UploadPiece uploads a piece to the blob store
+// UploadPiece stores piece data in the blob store. The piece ID is the sha2-256 digest of the
+// data; pieceID may be empty to derive it, otherwise it must match. Uploading data that is
+// already stored returns the existing piece, and data sharing a PieceCID with a stored piece
+// reuses its blob. Uploading makes the caller's tenant an owner of the piece.
 func (p *PieceService) UploadPiece(ctx context.Context, pieceID string, fileContent []byte) (*PieceInfo, error) {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
+	return p.UploadPieceFrom(ctx, pieceID, bytes.NewReader(fileContent), int64(len(fileContent)))
+}
 
-	// Check if piece already exists
-	if _, exists := p.pieces[pieceID]; exists {
-		return nil, fmt.Errorf("piece %s already exists", pieceID)
+// UploadPieceFrom is UploadPiece for size bytes read from data, which is never held in memory
+// at once. Data is read twice: once to derive the piece ID and PieceCID, which key the stored
+// blob, and once more to store it padded.
+func (p *PieceService) UploadPieceFrom(ctx context.Context, pieceID string, data io.ReadSeeker, size int64) (*PieceInfo, error) {
+	// Hash the data and calculate the piece commitment (CommP) over it padded to the next
+	// power of 2 for Filecoin compatibility
+	padded := int64(0)
+	if size > 0 {
+		padded = paddedSize(size)
 	}
-
-	// Pad file content to next power of 2 for Filecoin compatibility
-	paddedContent := padToPowerOfTwo(fileContent)
-
-	// Calculate the piece commitment (CommP)
+	hash := sha256.New()
 	cp := &commp.Calc{}
-	if _, err := io.Copy(cp, bytes.NewReader(paddedContent)); err != nil {
+	read, err := io.Copy(io.MultiWriter(hash, cp), io.LimitReader(data, size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read piece data: %w", err)
+	}
+	if read != size {
+		return nil, fmt.Errorf("failed to read piece data: got %d of %d bytes", read, size)
+	}
+	if _, err := io.Copy(cp, io.LimitReader(zeros{}, padded-size)); err != nil {
 		return nil, fmt.Errorf("failed to calculate commp: %v", err)
 	}
+
+	contentID := PieceIDFromDigest(hash.Sum(nil))
+	if pieceID != "" && strings.ToLower(pieceID) != contentID {
+		err := fmt.Errorf("%w: piece ID %s does not match data sha2-256 %s", ErrVerificationFailed, pieceID, contentID)
+		log.Printf("Rejected upload: %v", err)
+		return nil, err
+	}
+	pieceID = contentID
+	lock := p.locks.Get(pieceID)
+	lock.Lock()
+	defer lock.Unlock()
+	ref := reservationRef(tenant.FromContext(ctx), pieceID)
+
+	// Prepared pieces carry expectations to verify against; anything else is already stored
+	record, err := p.loadPiece(ctx, pieceID)
+	if err != nil && !errors.Is(err, ErrPieceNotFound) {
+		return nil, err
+	}
+	if record != nil && record.Status != "prepared" {
+		// The caller has shown it holds the data, so it may share the piece, within its quota
+		// Nothing is written, so only the quota applies, not the free space
+		if err := p.capacity.CheckQuota(ctx, tenant.FromContext(ctx), ref, record.Size); err != nil {
+			log.Printf("Rejected upload for piece %s: %v", pieceID, err)
+			return nil, err
+		}
+		if err := p.owners.Claim(ctx, tenant.KindPiece, pieceID); err != nil {
+			return nil, err
+		}
+		// Space the caller held when preparing the piece is no longer needed
+		if err := p.capacity.Release(ctx, ref); err != nil {
+			log.Printf("Warning: failed to release reservation for piece %s: %v", pieceID, err)
+		}
+		log.Printf("Piece %s already uploaded, returning existing piece", pieceID)
+		return p.info(ctx, record)
+	}
+	expectedSize, expectedPieceCID, prepared, err := p.expectations(ctx, pieceID, record)
+	if err != nil {
+		return nil, err
+	}
+	if prepared && expectedSize != size {
+		err := fmt.Errorf("%w: expected %d bytes, got %d", ErrVerificationFailed, expectedSize, size)
+		log.Printf("Rejected upload for piece %s: %v", pieceID, err)
+		return nil, err
+	}
+
 	digest, paddedPieceSize, err := cp.Digest()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get commp digest: %v", err)
@@ -130,42 +312,96 @@ func (p *PieceService) UploadPiece(ctx context.Context, pieceID string, fileCont
 		return nil, fmt.Errorf("failed to convert commp to piece CID: %v", err)
 	}
 
-	// Create piece info
-	piece := &PieceInfo{
-		ID:       pieceID,
-		Size:     int64(len(paddedContent)), // Use padded size for Filecoin compatibility
-		CommP:    hex.EncodeToString(digest),
-		PieceCID: pieceCID.String(),
-		DataCID:  pieceCID.String(),
-		Status:   "uploaded",
+	if prepared && expectedPieceCID != "" && expectedPieceCID != pieceCID.String() {
+		err := fmt.Errorf("%w: expected piece CID %s, got %s", ErrVerificationFailed, expectedPieceCID, pieceCID)
+		log.Printf("Rejected upload for piece %s: %v", pieceID, err)
+		return nil, err
+	}
+	if record == nil {
+		record = &models.Piece{ID: pieceID}
 	}
 
-	// Store the padded file in blob store using piece CID as key
-	if err := p.blobStore.Put(ctx, piece.PieceCID, bytes.NewReader(paddedContent)); err != nil {
+	// Prepared pieces already hold a reservation, which may have lapsed; either way the
+	// space is held until the piece is stored and counted as used
+	if err := p.capacity.Reserve(ctx, tenant.FromContext(ctx), ref, padded, 0); err != nil {
+		log.Printf("Rejected upload for piece %s: %v", pieceID, err)
+		return nil, err
+	}
+	defer func() {
+		if err := p.capacity.Release(ctx, ref); err != nil {
+			log.Printf("Warning: failed to release reservation for piece %s: %v", pieceID, err)
+		}
+	}()
+	record.Size = padded // Use padded size for Filecoin compatibility
+	record.RawSize = size
+	record.CommP = hex.EncodeToString(digest)
+	record.PieceCID = pieceCID.String()
+	record.Status = "uploaded"
+
+	// Store the padded data under its piece CID; data that differs only in trailing zeros
+	// pads to the same piece and shares the blob
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind piece data: %w", err)
+	}
+	paddedData := io.MultiReader(io.LimitReader(data, size), io.LimitReader(zeros{}, padded-size))
+	if err := p.refStore.Put(ctx, record.PieceCID, paddedData, blobstore.RefPiece, pieceID); err != nil {
 		return nil, fmt.Errorf("failed to store piece in blob store: %v", err)
 	}
 
-	// Store piece info in memory
-	p.pieces[pieceID] = piece
+	if err := p.db.WithContext(ctx).Save(record).Error; err != nil {
+		if relErr := p.refStore.Release(ctx, record.PieceCID, blobstore.RefPiece, pieceID); relErr != nil {
+			log.Printf("Warning: failed to release blob %s: %v", record.PieceCID, relErr)
+		}
+		return nil, fmt.Errorf("failed to save piece: %w", err)
+	}
+	if err := p.owners.Claim(ctx, tenant.KindPiece, pieceID); err != nil {
+		return nil, err
+	}
+	// Once stored, later uploads of the data are not verified against expectations
+	if err := p.db.WithContext(ctx).Where("piece_id = ?", pieceID).Delete(&models.PiecePreparation{}).Error; err != nil {
+		log.Printf("Warning: failed to delete preparations of piece %s: %v", pieceID, err)
+	}
+	p.publishStatus(ctx, record)
 
 	log.Printf("Successfully uploaded piece %s with CommP: %s, PieceCID: %s, PaddedSize: %d",
-		pieceID, piece.CommP, piece.PieceCID, paddedPieceSize)
+		pieceID, record.CommP, record.PieceCID, paddedPieceSize)
 
-	return piece, nil
+	return toPieceInfo(record, nil), nil
 }
 
-// AddPieceToProofSet adds a piece to a proof set by creating the necessary database entries and using Piri's method
+// AddPieceToProofSet adds a piece to a proof set by creating the necessary database entries and using Piri's method.
+// The transaction's progress is kept on the proof set root, never on the piece other tenants may share.
 func (p *PieceService) AddPieceToProofSet(ctx context.Context, pieceID string, proofSetID int64) error {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
+	piece, unlock, err := p.lockOwnedPiece(ctx, pieceID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	owned, err := p.owners.Owns(ctx, tenant.KindProofSet, strconv.FormatInt(proofSetID, 10))
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return fmt.Errorf("%w: %d", proofset.ErrProofSetNotFound, proofSetID)
+	}
 
-	piece, exists := p.pieces[pieceID]
-	if !exists {
-		return fmt.Errorf("piece not found: %s", pieceID)
+	if piece.Status == "prepared" {
+		return fmt.Errorf("%w: %s", ErrPieceNotUploaded, pieceID)
+	}
+	if piece.Corrupt {
+		return fmt.Errorf("%w: %s", ErrPieceCorrupt, pieceID)
 	}
 
-	if piece.Status != "uploaded" {
-		return fmt.Errorf("piece %s is not uploaded", pieceID)
+	// The same data may be a root of several proof sets, but only once per proof set
+	var root models.PieceRoot
+	err = p.db.WithContext(ctx).
+		Where("piece_id = ? AND proof_set_id = ?", piece.ID, proofSetID).
+		First(&root).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to look up proof set root: %w", err)
+	}
+	if err == nil && root.Status != "transaction_failed" && root.Status != "error" {
+		return fmt.Errorf("%w: piece %s, proof set %d", ErrAlreadyInProofSet, pieceID, proofSetID)
 	}
 
 	// Parse the piece CID
@@ -194,9 +430,24 @@ func (p *PieceService) AddPieceToProofSet(ctx context.Context, pieceID string, p
 
 	log.Printf("Generated unsealed CID: %s for piece: %s", generatedCID, piece.PieceCID)
 
+	// The proof set root keeps the blob alive independently of the piece record
+	if err := p.refStore.AddRef(ctx, piece.PieceCID, blobstore.RefRoot, rootRef(piece.ID, proofSetID)); err != nil {
+		return fmt.Errorf("failed to reference piece data: %w", err)
+	}
+
+	root.PieceID = piece.ID
+	root.ProofSetID = proofSetID
+	root.PieceCID = piece.PieceCID
+	root.RootCID = generatedCID.String()
+	root.Status = "pending_confirmation"
+	root.TransactionHash = ""
+	root.TransactionTimestamp = time.Now()
+	root.ErrorMessage = ""
+
 	// Create the database entries that Piri expects
 	// We need to create entries in parked_pieces, parked_piece_refs, and pdp_piecerefs
 	if err := p.createPiriDatabaseEntries(ctx, piece); err != nil {
+		p.releaseRoot(ctx, piece.PieceCID, piece.ID, proofSetID)
 		return fmt.Errorf("failed to create Piri database entries: %v", err)
 	}
 
@@ -209,28 +460,57 @@ func (p *PieceService) AddPieceToProofSet(ctx context.Context, pieceID string, p
 	// Use Piri's ProofSetAddRoot method
 	result, err := p.piriService.ProofSetAddRoot(ctx, proofSetID, []service.AddRootRequest{addRootReq})
 	if err != nil {
-		piece.Status = "error"
-		piece.ErrorMessage = fmt.Sprintf("failed to add root to proof set: %v", err)
-		return err
+		root.Status = "error"
+		root.ErrorMessage = fmt.Sprintf("failed to add root to proof set: %v", err)
+		if saveErr := p.db.WithContext(ctx).Save(&root).Error; saveErr != nil {
+			log.Printf("Warning: failed to save proof set root for piece %s: %v", pieceID, saveErr)
+		}
+		p.releaseRoot(ctx, piece.PieceCID, piece.ID, proofSetID)
+		p.publishRootStatus(ctx, &root)
+		return fmt.Errorf("%w: failed to add root to proof set: %v", proofset.ErrChainFailure, err)
 	}
 
 	// Store the transaction hash for monitoring
 	if txHash, ok := result.(string); ok {
-		piece.TransactionHash = txHash
+		root.TransactionHash = txHash
 		log.Printf("Transaction sent: %s", txHash)
 	}
 
-	// Update piece status to pending
-	piece.ProofSetID = proofSetID
-	piece.Status = "pending_confirmation"
-	piece.TransactionTimestamp = time.Now()
+	if err := p.db.WithContext(ctx).Save(&root).Error; err != nil {
+		return fmt.Errorf("failed to save proof set root: %w", err)
+	}
+	p.publishRootStatus(ctx, &root)
 
 	log.Printf("Added piece %s to proof set %d, transaction pending confirmation", pieceID, proofSetID)
 	return nil
 }
 
+// expectations returns the raw size and PieceCID the context's tenant declared when it
+// prepared a piece, and whether it prepared it at all: either after another tenant, or as the
+// owner of the prepared record
+func (p *PieceService) expectations(ctx context.Context, pieceID string, record *models.Piece) (int64, string, bool, error) {
+	var preparation models.PiecePreparation
+	err := p.db.WithContext(ctx).
+		Where("piece_id = ? AND tenant = ?", pieceID, tenant.FromContext(ctx)).
+		Take(&preparation).Error
+	if err == nil {
+		return preparation.RawSize, preparation.ExpectedPieceCID, true, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, "", false, fmt.Errorf("failed to look up piece preparation: %w", err)
+	}
+	if record == nil || record.Status != "prepared" {
+		return 0, "", false, nil
+	}
+	owned, err := p.owners.Owns(ctx, tenant.KindPiece, pieceID)
+	if err != nil || !owned {
+		return 0, "", false, err
+	}
+	return record.RawSize, record.ExpectedPieceCID, true, nil
+}
+
 // createPiriDatabaseEntries creates the necessary database entries that Piri expects
-func (p *PieceService) createPiriDatabaseEntries(ctx context.Context, piece *PieceInfo) error {
+func (p *PieceService) createPiriDatabaseEntries(ctx context.Context, piece *models.Piece) error {
 	log.Printf("Creating Piri database entries for piece: %s", piece.PieceCID)
 
 	// We need to create entries in the following order:
@@ -239,6 +519,16 @@ func (p *PieceService) createPiriDatabaseEntries(ctx context.Context, piece *Pie
 	// 3. PDPPieceRef - links to our service with the piece CID
 
 	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The entries are keyed by piece CID, so a piece added to another proof set reuses them
+		var existing int64
+		if err := tx.Model(&models.PDPPieceRef{}).Where("piece_cid = ?", piece.PieceCID).Count(&existing).Error; err != nil {
+			return fmt.Errorf("failed to look up PDP piece ref: %v", err)
+		}
+		if existing > 0 {
+			log.Printf("Reusing Piri database entries for piece: %s", piece.PieceCID)
+			return nil
+		}
+
 		// Step 1: Create ParkedPiece entry
 		parkedPiece := &models.ParkedPiece{
 			PieceCID:        piece.PieceCID,
@@ -286,45 +576,105 @@ func (p *PieceService) createPiriDatabaseEntries(ctx context.Context, piece *Pie
 	})
 }
 
-// GetPiece retrieves piece information
+// GetPiece retrieves piece information by piece ID or PieceCID
 func (p *PieceService) GetPiece(ctx context.Context, pieceID string) (*PieceInfo, error) {
-	p.mutex.RLock()
-	defer p.mutex.RUnlock()
-
-	piece, exists := p.pieces[pieceID]
-	if !exists {
-		return nil, fmt.Errorf("piece not found: %s", pieceID)
+	piece, err := p.loadOwnedPiece(ctx, pieceID)
+	if err != nil {
+		return nil, err
 	}
+	return p.info(ctx, piece)
+}
 
-	return piece, nil
+// Listing describes how pieces are paged: by creation time by default, or by size or the
+// time they last changed
+var Listing = listing.Listing{
+	Fields: []listing.Field{
+		{Name: "created_at", Column: "created_at", Kind: listing.Time},
+		{Name: "updated_at", Column: "updated_at", Kind: listing.Time},
+		{Name: "size", Column: "raw_size", Kind: listing.Int},
+	},
+	Default:  "created_at",
+	Tiebreak: listing.Field{Name: "id", Column: "id", Kind: listing.String},
 }
 
-// ListPieces returns all pieces
-func (p *PieceService) ListPieces(ctx context.Context) ([]*PieceInfo, error) {
-	p.mutex.RLock()
-	defer p.mutex.RUnlock()
+// ListPieces returns a page of the pieces the context's tenant owns, oldest first unless the
+// query sorts them otherwise, and the cursor of the next page, if any. Pieces can be filtered
+// by status, proof set, creation time and owning tenant. Filtering by a root status or proof
+// set only considers roots in proof sets the context may access.
+func (p *PieceService) ListPieces(ctx context.Context, q listing.Query) ([]*PieceInfo, string, error) {
+	page, err := Listing.Page(q)
+	if err != nil {
+		return nil, "", err
+	}
 
-	pieces := make([]*PieceInfo, 0, len(p.pieces))
-	for _, piece := range p.pieces {
-		pieces = append(pieces, piece)
+	query := p.owners.ScopeTenant(ctx, p.db.WithContext(ctx), tenant.KindPiece, "id", q.Tenant)
+	switch q.Status {
+	case "":
+	case "prepared", "uploaded":
+		query = query.Where("status = ?", q.Status)
+	default:
+		query = query.Where("id IN (?)", p.scopeRoots(ctx).Select("piece_id").Where("status = ?", q.Status))
+	}
+	if q.ProofSetID != 0 {
+		query = query.Where("id IN (?)", p.scopeRoots(ctx).Select("piece_id").Where("proof_set_id = ?", q.ProofSetID))
+	}
+	if !q.CreatedAfter.IsZero() {
+		query = query.Where("created_at > ?", q.CreatedAfter)
 	}
 
-	return pieces, nil
+	var records []models.Piece
+	if err := page.Apply(query).Find(&records).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to list pieces: %w", err)
+	}
+
+	var next string
+	if len(records) > page.Limit {
+		records = records[:page.Limit]
+		last := &records[len(records)-1]
+		next = page.Next(sortValue(last, page.Field.Name), last.ID)
+	}
+
+	ids := make([]string, len(records))
+	for i := range records {
+		ids[i] = records[i].ID
+	}
+	roots, err := p.ownedRoots(ctx, ids...)
+	if err != nil {
+		return nil, "", err
+	}
+	pieces := make([]*PieceInfo, len(records))
+	for i := range records {
+		pieces[i] = toPieceInfo(&records[i], roots[records[i].ID])
+	}
+
+	return pieces, next, nil
+}
+
+// sortValue returns the value of the Listing field a piece is sorted by
+func sortValue(piece *models.Piece, field string) interface{} {
+	switch field {
+	case "updated_at":
+		return piece.UpdatedAt
+	case "size":
+		return piece.RawSize
+	default:
+		return piece.CreatedAt
+	}
 }
 
 // GetPieceContent retrieves piece content from the blob store
 func (p *PieceService) GetPieceContent(ctx context.Context, pieceID string) ([]byte, error) {
-	p.mutex.RLock()
-	defer p.mutex.RUnlock()
-
 	// Check if piece exists
-	piece, exists := p.pieces[pieceID]
-	if !exists {
-		return nil, fmt.Errorf("piece not found: %s", pieceID)
+	piece, err := p.loadOwnedPiece(ctx, pieceID)
+	if err != nil {
+		return nil, err
+	}
+	if piece.Status == "prepared" {
+		return nil, fmt.Errorf("%w: %s", ErrPieceNotUploaded, pieceID)
 	}
 
 	// Get from blob store using piece CID as key
-	obj, err := p.blobStore.Get(ctx, piece.PieceCID)
+	obj, err := p.refStore.Get(ctx, piece.PieceCID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get piece content: %v", err)
 	}
@@ -339,79 +689,356 @@ func (p *PieceService) GetPieceContent(ctx context.Context, pieceID string) ([]b
 	return content, nil
 }
 
-// MonitorTransactionStatus checks the status of a pending transaction
+// MonitorTransactionStatus checks the transactions of the piece's roots pending confirmation in
+// proof sets the context's tenant owns
 func (p *PieceService) MonitorTransactionStatus(ctx context.Context, pieceID string) error {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-
-	piece, exists := p.pieces[pieceID]
-	if !exists {
-		return fmt.Errorf("piece not found: %s", pieceID)
+	piece, unlock, err := p.lockOwnedPiece(ctx, pieceID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var pending []models.PieceRoot
+	if err := p.scopeRoots(ctx).
+		Where("piece_id = ? AND status = ?", piece.ID, "pending_confirmation").
+		Find(&pending).Error; err != nil {
+		return fmt.Errorf("failed to look up proof set roots: %w", err)
+	}
+	if len(pending) == 0 {
+		return fmt.Errorf("%w: piece %s is not in pending confirmation status", ErrNoPendingTransaction, pieceID)
 	}
 
-	if piece.Status != "pending_confirmation" {
-		return fmt.Errorf("piece %s is not in pending confirmation status", pieceID)
+	for i := range pending {
+		if err := p.checkRoot(ctx, &pending[i]); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if piece.TransactionHash == "" {
-		return fmt.Errorf("no transaction hash found for piece %s", pieceID)
+// checkRoot updates a root pending confirmation from the status of its transaction
+func (p *PieceService) checkRoot(ctx context.Context, root *models.PieceRoot) error {
+	if root.TransactionHash == "" {
+		log.Printf("No transaction hash found for piece %s in proof set %d", root.PieceID, root.ProofSetID)
+		return nil
 	}
 
 	// Check transaction status in Piri's database
 	var messageWait models.MessageWaitsEth
 	err := p.db.WithContext(ctx).
-		Where("signed_tx_hash = ?", piece.TransactionHash).
+		Where("signed_tx_hash = ?", root.TransactionHash).
 		First(&messageWait).Error
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			log.Printf("Transaction %s not found in database, still pending", piece.TransactionHash)
+			log.Printf("Transaction %s not found in database, still pending", root.TransactionHash)
 			return nil
 		}
 		return fmt.Errorf("failed to query transaction status: %v", err)
 	}
 
-	// Update piece status based on transaction status
+	// Update root status based on transaction status
 	switch messageWait.TxStatus {
 	case "confirmed":
 		if messageWait.TxSuccess != nil && *messageWait.TxSuccess {
-			piece.Status = "added_to_proofset"
-			log.Printf("Transaction %s confirmed successfully", piece.TransactionHash)
+			root.Status = "added_to_proofset"
+			log.Printf("Transaction %s confirmed successfully", root.TransactionHash)
 		} else {
-			piece.Status = "transaction_failed"
-			piece.ErrorMessage = "Transaction failed on blockchain"
-			log.Printf("Transaction %s failed on blockchain", piece.TransactionHash)
+			root.Status = "transaction_failed"
+			root.ErrorMessage = "Transaction failed on blockchain"
+			log.Printf("Transaction %s failed on blockchain", root.TransactionHash)
+			p.releaseRoot(ctx, root.PieceCID, root.PieceID, root.ProofSetID)
 		}
 	case "pending":
-		log.Printf("Transaction %s still pending", piece.TransactionHash)
+		log.Printf("Transaction %s still pending", root.TransactionHash)
+		return nil
 	default:
-		log.Printf("Transaction %s has status: %s", piece.TransactionHash, messageWait.TxStatus)
+		log.Printf("Transaction %s has status: %s", root.TransactionHash, messageWait.TxStatus)
+		return nil
 	}
 
+	if err := p.db.WithContext(ctx).Save(root).Error; err != nil {
+		return fmt.Errorf("failed to save proof set root: %w", err)
+	}
+	p.publishRootStatus(ctx, root)
 	return nil
 }
 
-// padToPowerOfTwo pads data to the next power of 2 size for Filecoin compatibility
-func padToPowerOfTwo(data []byte) []byte {
-	size := len(data)
-	if size == 0 {
-		return data
+// DeletePiece removes a piece record and releases its reference to the stored data.
+// The data itself is kept while uploads or proof set roots still reference it. Only the
+// caller's own pending proof set transactions hold the piece back.
+func (p *PieceService) DeletePiece(ctx context.Context, pieceID string) error {
+	piece, unlock, err := p.lockOwnedPiece(ctx, pieceID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var pending int64
+	if err := p.scopeRoots(ctx).
+		Where("piece_id = ? AND status = ?", piece.ID, "pending_confirmation").
+		Count(&pending).Error; err != nil {
+		return fmt.Errorf("failed to look up proof set roots: %w", err)
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %s", ErrTransactionPending, pieceID)
+	}
+
+	// Pieces shared with other tenants stay stored until their last owner deletes them
+	releasing, err := p.owners.Owners(ctx, tenant.KindPiece, piece.ID)
+	if err != nil {
+		return err
+	}
+	remaining, err := p.owners.Release(ctx, tenant.KindPiece, piece.ID)
+	if err != nil {
+		return err
+	}
+	if piece.Status == "prepared" {
+		for _, owner := range releasing {
+			if err := p.capacity.Release(ctx, reservationRef(owner, piece.ID)); err != nil {
+				return fmt.Errorf("failed to release reserved space: %w", err)
+			}
+		}
+	}
+	if remaining > 0 {
+		log.Printf("Released tenant %s's ownership of piece %s, %d owners remain", tenant.FromContext(ctx), piece.ID, remaining)
+		return nil
 	}
 
-	// Find next power of 2
-	nextPowerOfTwo := 1
-	for nextPowerOfTwo < size {
-		nextPowerOfTwo <<= 1
+	err = p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(piece).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("piece_id = ?", piece.ID).Delete(&models.CarRoot{}).Error; err != nil {
+			return err
+		}
+		// The block index is keyed by piece CID, which other pieces may still share
+		var sharing int64
+		if err := tx.Model(&models.Piece{}).Where("piece_cid = ?", piece.PieceCID).Count(&sharing).Error; err != nil {
+			return err
+		}
+		if sharing == 0 && piece.PieceCID != "" {
+			return tx.Where("piece_cid = ?", piece.PieceCID).Delete(&models.CarBlock{}).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete piece: %w", err)
 	}
+	if piece.Status != "prepared" {
+		if err := p.refStore.Release(ctx, piece.PieceCID, blobstore.RefPiece, piece.ID); err != nil {
+			return fmt.Errorf("failed to release piece data: %w", err)
+		}
+	}
+
+	log.Printf("Deleted piece %s", piece.ID)
+	return nil
+}
 
-	// If already a power of 2, no padding needed
-	if nextPowerOfTwo == size {
-		return data
+// releaseRoot drops the reference held by a proof set root that failed to be added
+func (p *PieceService) releaseRoot(ctx context.Context, pieceCID, pieceID string, proofSetID int64) {
+	if err := p.refStore.Release(ctx, pieceCID, blobstore.RefRoot, rootRef(pieceID, proofSetID)); err != nil {
+		log.Printf("Warning: failed to release proof set %d reference to %s: %v", proofSetID, pieceCID, err)
 	}
+}
+
+// statusEvent is the data of a piece.status event
+type statusEvent struct {
+	Status          string `json:"status"`
+	PieceCID        string `json:"piece_cid,omitempty"`
+	TransactionHash string `json:"transaction_hash,omitempty"`
+	ErrorMessage    string `json:"error_message,omitempty"`
+}
+
+// publishStatus records a piece moving to its current status
+func (p *PieceService) publishStatus(ctx context.Context, piece *models.Piece) {
+	p.events.Publish(ctx, events.PieceStatus, piece.ID, 0, &statusEvent{
+		Status:   piece.Status,
+		PieceCID: piece.PieceCID,
+	})
+}
+
+// publishRootStatus records a piece's root in a proof set moving to its current status. The
+// event is about the proof set, so only the proof set's owners see it.
+func (p *PieceService) publishRootStatus(ctx context.Context, root *models.PieceRoot) {
+	p.events.Publish(ctx, events.PieceStatus, root.PieceID, root.ProofSetID, &statusEvent{
+		Status:          root.Status,
+		PieceCID:        root.PieceCID,
+		TransactionHash: root.TransactionHash,
+		ErrorMessage:    root.ErrorMessage,
+	})
+}
+
+// loadPiece fetches a piece record by piece ID or, failing that, by PieceCID
+func (p *PieceService) loadPiece(ctx context.Context, idOrCID string) (*models.Piece, error) {
+	return p.findPiece(p.db.WithContext(ctx), idOrCID)
+}
+
+// loadOwnedPiece is loadPiece limited to pieces the context's tenant owns; other tenants'
+// pieces are not found
+func (p *PieceService) loadOwnedPiece(ctx context.Context, idOrCID string) (*models.Piece, error) {
+	return p.findPiece(p.owners.Scope(ctx, p.db.WithContext(ctx), tenant.KindPiece, "id"), idOrCID)
+}
+
+// lockOwnedPiece is loadOwnedPiece holding the piece's lock, which is taken once the piece ID
+// is known and released by calling unlock
+func (p *PieceService) lockOwnedPiece(ctx context.Context, idOrCID string) (piece *models.Piece, unlock func(), err error) {
+	found, err := p.loadOwnedPiece(ctx, idOrCID)
+	if err != nil {
+		return nil, nil, err
+	}
+	lock := p.locks.Get(found.ID)
+	lock.Lock()
+	// Reloaded, as it may have changed while waiting for the lock
+	if piece, err = p.loadOwnedPiece(ctx, found.ID); err != nil {
+		lock.Unlock()
+		return nil, nil, err
+	}
+	return piece, lock.Unlock, nil
+}
+
+// findPiece looks a piece record up by piece ID or PieceCID within query
+func (p *PieceService) findPiece(query *gorm.DB, idOrCID string) (*models.Piece, error) {
+	var piece models.Piece
+	err := query.Session(&gorm.Session{}).Where("id = ?", strings.ToLower(idOrCID)).First(&piece).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = query.Session(&gorm.Session{}).
+			Where("piece_cid = ? AND status <> ?", idOrCID, "prepared").
+			Order("created_at ASC").
+			First(&piece).Error
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrPieceNotFound, idOrCID)
+		}
+		return nil, fmt.Errorf("failed to get piece: %w", err)
+	}
+	return &piece, nil
+}
+
+// info converts a piece record to its API representation with its roots in the proof sets
+// the context may access
+func (p *PieceService) info(ctx context.Context, piece *models.Piece) (*PieceInfo, error) {
+	roots, err := p.ownedRoots(ctx, piece.ID)
+	if err != nil {
+		return nil, err
+	}
+	return toPieceInfo(piece, roots[piece.ID]), nil
+}
+
+// scopeRoots queries the proof set roots in proof sets the context may access
+func (p *PieceService) scopeRoots(ctx context.Context) *gorm.DB {
+	return p.owners.Scope(ctx, p.db.WithContext(ctx).Model(&models.PieceRoot{}), tenant.KindProofSet, "proof_set_id")
+}
+
+// ownedRoots returns the roots of the given pieces in proof sets the context may access, by
+// piece ID, oldest transaction first
+func (p *PieceService) ownedRoots(ctx context.Context, pieceIDs ...string) (map[string][]models.PieceRoot, error) {
+	if len(pieceIDs) == 0 {
+		return nil, nil
+	}
+	var roots []models.PieceRoot
+	if err := p.scopeRoots(ctx).
+		Where("piece_id IN ?", pieceIDs).
+		Order("transaction_timestamp ASC, id ASC").
+		Find(&roots).Error; err != nil {
+		return nil, fmt.Errorf("failed to look up proof set roots: %w", err)
+	}
+	byPiece := make(map[string][]models.PieceRoot)
+	for _, root := range roots {
+		byPiece[root.PieceID] = append(byPiece[root.PieceID], root)
+	}
+	return byPiece, nil
+}
+
+// toPieceInfo converts a piece record and the roots the caller may see to its API
+// representation. The latest root's transaction is reported on the piece itself.
+func toPieceInfo(piece *models.Piece, roots []models.PieceRoot) *PieceInfo {
+	info := &PieceInfo{
+		ID:               piece.ID,
+		Size:             piece.Size,
+		CommP:            piece.CommP,
+		PieceCID:         piece.PieceCID,
+		DataCID:          piece.PieceCID,
+		Status:           piece.Status,
+		Check:            &Check{Name: "sha2-256", Hash: piece.ID, Size: piece.RawSize},
+		ExpectedPieceCID: piece.ExpectedPieceCID,
+		LastVerifiedAt:   piece.LastVerifiedAt,
+		Corrupt:          piece.Corrupt,
+	}
+	if piece.Status == "prepared" {
+		info.UploadURL = "/pieces/" + piece.ID
+	}
+	for _, root := range roots {
+		info.Roots = append(info.Roots, RootInfo{
+			ProofSetID:           root.ProofSetID,
+			RootCID:              root.RootCID,
+			Status:               root.Status,
+			TransactionHash:      root.TransactionHash,
+			TransactionTimestamp: root.TransactionTimestamp,
+			ErrorMessage:         root.ErrorMessage,
+		})
+	}
+	if len(info.Roots) > 0 {
+		latest := info.Roots[len(info.Roots)-1]
+		info.ProofSetID = latest.ProofSetID
+		info.Status = latest.Status
+		info.TransactionHash = latest.TransactionHash
+		info.TransactionTimestamp = latest.TransactionTimestamp
+		info.ErrorMessage = latest.ErrorMessage
+	}
+	return info
+}
+
+// normalizeCheck validates a client supplied check and lower-cases its digest
+func normalizeCheck(check *Check) (*Check, error) {
+	if check == nil {
+		return nil, nil
+	}
+	if check.Name != "" && check.Name != "sha2-256" {
+		return nil, fmt.Errorf("%w: unsupported check hash %q, only sha2-256 is supported", ErrInvalidPiece, check.Name)
+	}
+	hashBytes, err := hex.DecodeString(check.Hash)
+	if err != nil || len(hashBytes) != sha256.Size {
+		return nil, fmt.Errorf("%w: check hash must be a hex encoded sha2-256 digest", ErrInvalidPiece)
+	}
+	if check.Size <= 0 {
+		return nil, fmt.Errorf("%w: check size must be positive", ErrInvalidPiece)
+	}
+	return &Check{
+		Name: "sha2-256",
+		Hash: hex.EncodeToString(hashBytes),
+		Size: check.Size,
+	}, nil
+}
+
+// PieceIDFromDigest derives the piece ID from the full sha2-256 digest of the raw data
+func PieceIDFromDigest(digest []byte) string {
+	return hex.EncodeToString(digest)
+}
+
+// rootRef names the blob reference a proof set root holds. Pieces sharing a blob may be in
+// the same proof set, so each root holds its own.
+func rootRef(pieceID string, proofSetID int64) string {
+	return strconv.FormatInt(proofSetID, 10) + ":" + pieceID
+}
+
+// reservationRef names the capacity reservation a tenant holds for a piece
+func reservationRef(tenantID, pieceID string) string {
+	return "piece:" + tenantID + ":" + pieceID
+}
+
+// paddedSize returns the size data of rawSize bytes occupies once padded to a power of two
+func paddedSize(rawSize int64) int64 {
+	size := int64(1)
+	for size < rawSize {
+		size <<= 1
+	}
+	return size
+}
 
-	// Pad with zeros
-	padded := make([]byte, nextPowerOfTwo)
-	copy(padded, data)
+// zeros reads an endless run of zero bytes, the padding of a piece
+type zeros struct{}
 
-	return padded
+func (zeros) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
 }
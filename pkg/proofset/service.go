@@ -2,9 +2,13 @@ package proofset
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/Datazen-Protocol/pdp-server/pkg/listing"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
 	"github.com/ethereum/go-ethereum/common"
 	"gorm.io/gorm"
 
@@ -16,6 +20,7 @@ import (
 type ProofSetService struct {
 	piriService *service.PDPService
 	db          *gorm.DB
+	owners      *tenant.OwnerStore
 	address     common.Address
 }
 
@@ -37,11 +42,13 @@ type AddRootRequest struct {
 	SubrootCIDs []string `json:"subroot_cids" validate:"required,min=1"`
 }
 
-// NewProofSetService creates a new proof set service
-func NewProofSetService(piriService *service.PDPService, db *gorm.DB, address common.Address) *ProofSetService {
+// NewProofSetService creates a new proof set service. Proof sets are only visible to the
+// tenants recorded as their owners.
+func NewProofSetService(piriService *service.PDPService, db *gorm.DB, owners *tenant.OwnerStore, address common.Address) *ProofSetService {
 	return &ProofSetService{
 		piriService: piriService,
 		db:          db,
+		owners:      owners,
 		address:     address,
 	}
 }
@@ -54,7 +61,7 @@ func (p *ProofSetService) CreateProofSet(ctx context.Context) (*ProofSetInfo, er
 	// Use Piri's service to create the proof set
 	txHash, err := p.piriService.ProofSetCreate(ctx, recordKeeper)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create proof set: %w", err)
+		return nil, fmt.Errorf("%w: failed to create proof set: %v", ErrChainFailure, err)
 	}
 
 	// Get the created proof set from database
@@ -72,6 +79,9 @@ func (p *ProofSetService) CreateProofSet(ctx context.Context) (*ProofSetInfo, er
 		Where("create_message_hash = ?", txHash.Hex()).
 		First(&pdpProofSet).Error; err == nil {
 		proofSetID = pdpProofSet.ID
+		if err := p.owners.Claim(ctx, tenant.KindProofSet, strconv.FormatInt(proofSetID, 10)); err != nil {
+			return nil, err
+		}
 	}
 
 	return &ProofSetInfo{
@@ -83,41 +93,91 @@ func (p *ProofSetService) CreateProofSet(ctx context.Context) (*ProofSetInfo, er
 	}, nil
 }
 
-// ListProofSets lists all proof sets
-func (p *ProofSetService) ListProofSets(ctx context.Context) ([]*ProofSetInfo, error) {
-	var proofSets []models.PDPProofsetCreate
-	if err := p.db.WithContext(ctx).
-		Order("created_at DESC").
-		Find(&proofSets).Error; err != nil {
-		return nil, fmt.Errorf("failed to list proof sets: %w", err)
+// Listing describes how proof sets are paged, newest first by default
+var Listing = listing.Listing{
+	Fields: []listing.Field{
+		{Name: "created_at", Column: "pdp_proofset_creates.created_at", Kind: listing.Time},
+	},
+	Default:  "-created_at",
+	Tiebreak: listing.Field{Name: "create_message_hash", Column: "pdp_proofset_creates.create_message_hash", Kind: listing.String},
+}
+
+// RootListing describes how the roots of a proof set are paged, in the order they were added
+var RootListing = listing.Listing{
+	Fields: []listing.Field{
+		{Name: "add_message_hash", Column: "add_message_hash", Kind: listing.String},
+	},
+	Default:  "add_message_hash",
+	Tiebreak: listing.Field{Name: "subroot_offset", Column: "subroot_offset", Kind: listing.Int},
+}
+
+// ListProofSets returns a page of the proof sets the context's tenant owns and the cursor of
+// the next page, if any. Proof sets can be filtered by status ("pending" or "created"),
+// creation time and owning tenant.
+func (p *ProofSetService) ListProofSets(ctx context.Context, q listing.Query) ([]*ProofSetInfo, string, error) {
+	page, err := Listing.Page(q)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Proof sets are owned by their ID, which is only known once the creation is confirmed
+	query := p.db.WithContext(ctx).
+		Table("pdp_proofset_creates").
+		Select("pdp_proofset_creates.create_message_hash, pdp_proofset_creates.proofset_created, " +
+			"pdp_proofset_creates.created_at, pdp_proof_sets.id AS proof_set_id").
+		Joins("LEFT JOIN pdp_proof_sets ON pdp_proof_sets.create_message_hash = pdp_proofset_creates.create_message_hash")
+	query = p.owners.ScopeTenant(ctx, query, tenant.KindProofSet, "pdp_proof_sets.id", q.Tenant)
+	switch q.Status {
+	case "":
+	case "created":
+		query = query.Where("pdp_proofset_creates.proofset_created = ?", true)
+	case "pending":
+		query = query.Where("pdp_proofset_creates.proofset_created = ?", false)
+	default:
+		return []*ProofSetInfo{}, "", nil
+	}
+	if !q.CreatedAfter.IsZero() {
+		query = query.Where("pdp_proofset_creates.created_at > ?", q.CreatedAfter)
+	}
+
+	var rows []struct {
+		CreateMessageHash string
+		ProofsetCreated   bool
+		CreatedAt         time.Time
+		ProofSetID        *int64
+	}
+	if err := page.Apply(query).Scan(&rows).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to list proof sets: %w", err)
+	}
+
+	var next string
+	if len(rows) > page.Limit {
+		rows = rows[:page.Limit]
+		last := rows[len(rows)-1]
+		next = page.Next(last.CreatedAt, last.CreateMessageHash)
 	}
 
-	result := make([]*ProofSetInfo, len(proofSets))
-	for i, ps := range proofSets {
+	result := make([]*ProofSetInfo, 0, len(rows))
+	for _, ps := range rows {
 		status := "pending"
 		if ps.ProofsetCreated {
 			status = "created"
 		}
-
-		// Try to get the actual proof set ID from PDPProofSet table
-		var pdpProofSet models.PDPProofSet
-		var proofSetID int64 = 0
-		if err := p.db.WithContext(ctx).
-			Where("create_message_hash = ?", ps.CreateMessageHash).
-			First(&pdpProofSet).Error; err == nil {
-			proofSetID = pdpProofSet.ID
+		var proofSetID int64
+		if ps.ProofSetID != nil {
+			proofSetID = *ps.ProofSetID
 		}
 
-		result[i] = &ProofSetInfo{
+		result = append(result, &ProofSetInfo{
 			ID:                proofSetID,
 			CreateMessageHash: ps.CreateMessageHash,
 			CreatedAt:         ps.CreatedAt,
 			ProofSetCreated:   ps.ProofsetCreated,
 			Status:            status,
-		}
+		})
 	}
 
-	return result, nil
+	return result, next, nil
 }
 
 // GetProofSet gets a specific proof set by message hash
@@ -126,7 +186,10 @@ func (p *ProofSetService) GetProofSet(ctx context.Context, messageHash string) (
 	if err := p.db.WithContext(ctx).
 		Where("create_message_hash = ?", messageHash).
 		First(&proofSet).Error; err != nil {
-		return nil, fmt.Errorf("proof set not found: %w", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrProofSetNotFound, messageHash)
+		}
+		return nil, fmt.Errorf("failed to get proof set: %w", err)
 	}
 
 	// Get additional status information from PDPProofSet table
@@ -136,6 +199,9 @@ func (p *ProofSetService) GetProofSet(ctx context.Context, messageHash string) (
 		First(&pdpProofSet).Error; err != nil && err != gorm.ErrRecordNotFound {
 		return nil, fmt.Errorf("failed to get proof set status: %w", err)
 	}
+	if err := p.CheckOwner(ctx, pdpProofSet.ID); err != nil {
+		return nil, err
+	}
 
 	status := "pending"
 	if proofSet.ProofsetCreated {
@@ -156,12 +222,19 @@ func (p *ProofSetService) GetProofSet(ctx context.Context, messageHash string) (
 
 // GetProofSetByID gets a specific proof set by ID
 func (p *ProofSetService) GetProofSetByID(ctx context.Context, proofSetID int64) (*ProofSetInfo, error) {
+	if err := p.CheckOwner(ctx, proofSetID); err != nil {
+		return nil, err
+	}
+
 	// First try to get from PDPProofSet table
 	var pdpProofSet models.PDPProofSet
 	if err := p.db.WithContext(ctx).
 		Where("id = ?", proofSetID).
 		First(&pdpProofSet).Error; err != nil {
-		return nil, fmt.Errorf("proof set not found: %w", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %d", ErrProofSetNotFound, proofSetID)
+		}
+		return nil, fmt.Errorf("failed to get proof set: %w", err)
 	}
 
 	// Get the creation record from PDPProofsetCreate table
@@ -191,6 +264,10 @@ func (p *ProofSetService) GetProofSetByID(ctx context.Context, proofSetID int64)
 
 // AddRootsToProofSet adds roots to a proof set
 func (p *ProofSetService) AddRootsToProofSet(ctx context.Context, proofSetID int64, requests []AddRootRequest) error {
+	if err := p.CheckOwner(ctx, proofSetID); err != nil {
+		return err
+	}
+
 	// Convert our requests to Piri's format
 	piriRequests := make([]service.AddRootRequest, len(requests))
 	for i, req := range requests {
@@ -203,7 +280,7 @@ func (p *ProofSetService) AddRootsToProofSet(ctx context.Context, proofSetID int
 	// Use Piri's service to add roots
 	_, err := p.piriService.ProofSetAddRoot(ctx, proofSetID, piriRequests)
 	if err != nil {
-		return fmt.Errorf("failed to add roots to proof set: %w", err)
+		return fmt.Errorf("%w: failed to add roots to proof set: %v", ErrChainFailure, err)
 	}
 
 	return nil
@@ -211,6 +288,10 @@ func (p *ProofSetService) AddRootsToProofSet(ctx context.Context, proofSetID int
 
 // GetProofSetRoots gets the roots for a proof set
 func (p *ProofSetService) GetProofSetRoots(ctx context.Context, proofSetID int64) ([]map[string]interface{}, error) {
+	if err := p.CheckOwner(ctx, proofSetID); err != nil {
+		return nil, err
+	}
+
 	var rootAdds []models.PDPProofsetRootAdd
 	if err := p.db.WithContext(ctx).
 		Where("proofset_id = ?", proofSetID).
@@ -220,29 +301,86 @@ func (p *ProofSetService) GetProofSetRoots(ctx context.Context, proofSetID int64
 	}
 
 	result := make([]map[string]interface{}, len(rootAdds))
-	for i, rootAdd := range rootAdds {
-		result[i] = map[string]interface{}{
-			"proofset_id":       rootAdd.ProofsetID,
-			"root":              rootAdd.Root,
-			"subroot":           rootAdd.Subroot,
-			"subroot_offset":    rootAdd.SubrootOffset,
-			"subroot_size":      rootAdd.SubrootSize,
-			"add_message_hash":  rootAdd.AddMessageHash,
-			"add_message_index": rootAdd.AddMessageIndex,
-		}
+	for i := range rootAdds {
+		result[i] = rootInfo(&rootAdds[i])
 	}
 
 	return result, nil
 }
 
+// ListProofSetRoots returns a page of the roots for a proof set and the cursor of the next
+// page, if any. Roots can be filtered by the status of the transaction that added them:
+// "pending", "confirmed" or "failed".
+func (p *ProofSetService) ListProofSetRoots(ctx context.Context, proofSetID int64, q listing.Query) ([]map[string]interface{}, string, error) {
+	page, err := RootListing.Page(q)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := p.CheckOwner(ctx, proofSetID); err != nil {
+		return nil, "", err
+	}
+
+	query := p.db.WithContext(ctx).Where("proofset_id = ?", proofSetID)
+	switch q.Status {
+	case "":
+	case "pending":
+		query = query.Where("add_message_ok IS NULL")
+	case "confirmed":
+		query = query.Where("add_message_ok = ?", true)
+	case "failed":
+		query = query.Where("add_message_ok = ?", false)
+	default:
+		return []map[string]interface{}{}, "", nil
+	}
+
+	var rootAdds []models.PDPProofsetRootAdd
+	if err := page.Apply(query).Find(&rootAdds).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to get proof set roots: %w", err)
+	}
+
+	var next string
+	if len(rootAdds) > page.Limit {
+		rootAdds = rootAdds[:page.Limit]
+		last := rootAdds[len(rootAdds)-1]
+		next = page.Next(last.AddMessageHash, last.SubrootOffset)
+	}
+
+	result := make([]map[string]interface{}, len(rootAdds))
+	for i := range rootAdds {
+		result[i] = rootInfo(&rootAdds[i])
+	}
+
+	return result, next, nil
+}
+
+// rootInfo describes a root added to a proof set
+func rootInfo(rootAdd *models.PDPProofsetRootAdd) map[string]interface{} {
+	return map[string]interface{}{
+		"proofset_id":       rootAdd.ProofsetID,
+		"root":              rootAdd.Root,
+		"subroot":           rootAdd.Subroot,
+		"subroot_offset":    rootAdd.SubrootOffset,
+		"subroot_size":      rootAdd.SubrootSize,
+		"add_message_hash":  rootAdd.AddMessageHash,
+		"add_message_index": rootAdd.AddMessageIndex,
+	}
+}
+
 // GetProofSetStatus gets detailed status of a proof set
 func (p *ProofSetService) GetProofSetStatus(ctx context.Context, proofSetID int64) (map[string]interface{}, error) {
+	if err := p.CheckOwner(ctx, proofSetID); err != nil {
+		return nil, err
+	}
+
 	// Get proof set info from PDPProofSet table
 	var pdpProofSet models.PDPProofSet
 	if err := p.db.WithContext(ctx).
 		Where("id = ?", proofSetID).
 		First(&pdpProofSet).Error; err != nil {
-		return nil, fmt.Errorf("proof set not found: %w", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %d", ErrProofSetNotFound, proofSetID)
+		}
+		return nil, fmt.Errorf("failed to get proof set: %w", err)
 	}
 
 	// Get create info
@@ -285,3 +423,15 @@ func (p *ProofSetService) GetProofSetStatus(ctx context.Context, proofSetID int6
 		"tx_status": messageWait.TxStatus,
 	}, nil
 }
+
+// CheckOwner returns ErrProofSetNotFound unless the context's tenant owns the proof set
+func (p *ProofSetService) CheckOwner(ctx context.Context, proofSetID int64) error {
+	owned, err := p.owners.Owns(ctx, tenant.KindProofSet, strconv.FormatInt(proofSetID, 10))
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return fmt.Errorf("%w: %d", ErrProofSetNotFound, proofSetID)
+	}
+	return nil
+}
@@ -3,21 +3,33 @@ package proofset
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
 	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrProofSetNotFound is returned for unknown proof sets and those of other tenants
+	ErrProofSetNotFound = apperr.New(apperr.NotFound, "PROOF_SET_NOT_FOUND", "proof set not found")
+	// ErrChainFailure is returned when a proof set transaction could not be sent
+	ErrChainFailure = apperr.New(apperr.ChainError, "CHAIN_ERROR", "blockchain call failed")
+)
+
 // SimpleProofSetService provides basic proof set management for our isolated database
 type SimpleProofSetService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	owners *tenant.OwnerStore
 }
 
 // NewSimpleProofSetService creates a new simple proof set service
-func NewSimpleProofSetService(db *gorm.DB) *SimpleProofSetService {
+func NewSimpleProofSetService(db *gorm.DB, owners *tenant.OwnerStore) *SimpleProofSetService {
 	return &SimpleProofSetService{
-		db: db,
+		db:     db,
+		owners: owners,
 	}
 }
 
@@ -47,6 +59,9 @@ func (s *SimpleProofSetService) CreateProofSet(ctx context.Context, req *CreateP
 	if err := s.db.WithContext(ctx).Create(proofSet).Error; err != nil {
 		return nil, fmt.Errorf("failed to create proof set: %w", err)
 	}
+	if err := s.owners.Claim(ctx, tenant.KindProofSet, strconv.FormatUint(uint64(proofSet.ID), 10)); err != nil {
+		return nil, err
+	}
 
 	return &ProofSetResponse{
 		ID:          proofSet.ID,
@@ -60,9 +75,10 @@ func (s *SimpleProofSetService) CreateProofSet(ctx context.Context, req *CreateP
 // GetProofSet retrieves a proof set by ID
 func (s *SimpleProofSetService) GetProofSet(ctx context.Context, id uint) (*ProofSetResponse, error) {
 	var proofSet models.PDPProofSet
-	if err := s.db.WithContext(ctx).First(&proofSet, id).Error; err != nil {
+	query := s.owners.Scope(ctx, s.db.WithContext(ctx), tenant.KindProofSet, "id")
+	if err := query.First(&proofSet, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("proof set not found")
+			return nil, fmt.Errorf("%w: %d", ErrProofSetNotFound, id)
 		}
 		return nil, fmt.Errorf("failed to get proof set: %w", err)
 	}
@@ -76,10 +92,11 @@ func (s *SimpleProofSetService) GetProofSet(ctx context.Context, id uint) (*Proo
 	}, nil
 }
 
-// ListProofSets lists all proof sets
+// ListProofSets lists the proof sets the context's tenant owns
 func (s *SimpleProofSetService) ListProofSets(ctx context.Context) ([]*ProofSetResponse, error) {
 	var proofSets []models.PDPProofSet
-	if err := s.db.WithContext(ctx).Find(&proofSets).Error; err != nil {
+	query := s.owners.Scope(ctx, s.db.WithContext(ctx), tenant.KindProofSet, "id")
+	if err := query.Find(&proofSets).Error; err != nil {
 		return nil, fmt.Errorf("failed to list proof sets: %w", err)
 	}
 
@@ -0,0 +1,194 @@
+// Package ratelimit throttles API clients: how often they may call the API, how fast they may
+// upload and how many uploads they may run at once.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// readChunk bounds each throttled read, and so the bandwidth limiter burst
+const readChunk = 1 << 20
+
+// idleTimeout is how long a client's state is kept after its last request
+const idleTimeout = 10 * time.Minute
+
+// Options configures a Limiter. Zero values disable the corresponding limit.
+type Options struct {
+	RequestsPerSecond    float64          // Sustained request rate per client
+	Burst                int              // Requests a client may make at once
+	UploadBytesPerSecond int64            // Upload bandwidth per client
+	MaxConcurrentUploads int              // Uploads a client may run at once
+	MaxBodySize          int64            // Largest request body on routes that do not upload data
+	MaxUploadSize        int64            // Largest request body on routes that upload data
+	BodySizes            map[string]int64 // Per route overrides, keyed by "METHOD /path"
+}
+
+// Limiter tracks rate limits per client. Clients are identified by the caller, e.g. by API
+// token or by IP address.
+type Limiter struct {
+	opts      Options
+	mutex     sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+// client is the limiter state of one client
+type client struct {
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+	uploads  int // Uploads in progress
+	lastSeen time.Time
+}
+
+// Decision is the outcome of admitting a request
+type Decision struct {
+	Allowed    bool
+	Limit      int           // Requests a client may make at once; 0 when requests are not limited
+	Remaining  int           // Requests the client may make right now
+	Reset      time.Duration // Until the client may make Limit requests again
+	RetryAfter time.Duration // Until a refused request would be admitted
+}
+
+// NewLimiter creates a new limiter
+func NewLimiter(opts Options) *Limiter {
+	if opts.RequestsPerSecond > 0 && opts.Burst <= 0 {
+		opts.Burst = int(math.Max(1, math.Ceil(opts.RequestsPerSecond)))
+	}
+	return &Limiter{
+		opts:    opts,
+		clients: make(map[string]*client),
+	}
+}
+
+// Allow admits a request from a client if it is within its request rate
+func (l *Limiter) Allow(key string) Decision {
+	if l.opts.RequestsPerSecond <= 0 {
+		return Decision{Allowed: true}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	c := l.client(key, now)
+	decision := Decision{Allowed: true, Limit: l.opts.Burst}
+	reservation := c.requests.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		decision.Allowed = false
+		decision.RetryAfter = delay
+	}
+
+	tokens := c.requests.TokensAt(now)
+	decision.Remaining = int(math.Max(0, math.Floor(tokens)))
+	missing := float64(l.opts.Burst) - tokens
+	decision.Reset = time.Duration(missing / l.opts.RequestsPerSecond * float64(time.Second))
+	return decision
+}
+
+// AcquireUpload counts an upload against a client's concurrent uploads. It returns false when
+// the client already runs as many as it may; otherwise release must be called when the upload
+// is done.
+func (l *Limiter) AcquireUpload(key string) (release func(), ok bool) {
+	if l.opts.MaxConcurrentUploads <= 0 {
+		return func() {}, true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	c := l.client(key, time.Now())
+	if c.uploads >= l.opts.MaxConcurrentUploads {
+		return nil, false
+	}
+	c.uploads++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mutex.Lock()
+			c.uploads--
+			l.mutex.Unlock()
+		})
+	}, true
+}
+
+// Reader throttles r to the client's upload bandwidth, shared by all its uploads
+func (l *Limiter) Reader(ctx context.Context, key string, r io.Reader) io.Reader {
+	if l.opts.UploadBytesPerSecond <= 0 {
+		return r
+	}
+
+	l.mutex.Lock()
+	c := l.client(key, time.Now())
+	l.mutex.Unlock()
+	return &limitedReader{ctx: ctx, r: r, limiter: c.bytes}
+}
+
+// BodyLimit returns the largest request body a route accepts, or 0 for no limit
+func (l *Limiter) BodyLimit(route string, upload bool) int64 {
+	if size, ok := l.opts.BodySizes[route]; ok {
+		return size
+	}
+	if upload {
+		return l.opts.MaxUploadSize
+	}
+	return l.opts.MaxBodySize
+}
+
+// client returns the state of a client, creating it if needed, and forgets idle clients. The
+// caller must hold the mutex.
+func (l *Limiter) client(key string, now time.Time) *client {
+	if now.Sub(l.lastSweep) > idleTimeout {
+		for k, c := range l.clients {
+			if c.uploads == 0 && now.Sub(c.lastSeen) > idleTimeout {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{
+			requests: rate.NewLimiter(rate.Limit(l.opts.RequestsPerSecond), l.opts.Burst),
+			bytes:    rate.NewLimiter(rate.Inf, readChunk),
+		}
+		if l.opts.UploadBytesPerSecond > 0 {
+			burst := readChunk
+			if l.opts.UploadBytesPerSecond < int64(burst) {
+				burst = int(l.opts.UploadBytesPerSecond)
+			}
+			c.bytes = rate.NewLimiter(rate.Limit(l.opts.UploadBytesPerSecond), burst)
+		}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+	return c
+}
+
+// limitedReader reads in chunks no larger than the limiter burst, waiting for each
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > l.limiter.Burst() {
+		p = p[:l.limiter.Burst()]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if waitErr := l.limiter.WaitN(l.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
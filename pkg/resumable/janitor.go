@@ -0,0 +1,77 @@
+package resumable
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Janitor periodically expires stale upload sessions and cleans the tmp directory
+type Janitor struct {
+	svc      *ResumableService
+	interval time.Duration
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewJanitor creates a new upload session janitor
+func NewJanitor(svc *ResumableService, interval time.Duration) *Janitor {
+	return &Janitor{
+		svc:      svc,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start begins the cleanup loop
+func (j *Janitor) Start(ctx context.Context) error {
+	log.Printf("Starting upload session janitor...")
+
+	j.wg.Add(1)
+	go j.run(ctx)
+
+	return nil
+}
+
+// Stop stops the cleanup loop
+func (j *Janitor) Stop() error {
+	close(j.stopChan)
+	j.wg.Wait()
+	log.Printf("Upload session janitor stopped")
+	return nil
+}
+
+// run expires sessions on every tick until stopped
+func (j *Janitor) run(ctx context.Context) {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-j.stopChan:
+			return
+		case <-ticker.C:
+			j.sweep(ctx)
+		}
+	}
+}
+
+// sweep performs a single cleanup pass
+func (j *Janitor) sweep(ctx context.Context) {
+	expired, err := j.svc.ExpireStaleSessions(ctx)
+	if err != nil {
+		log.Printf("Error expiring upload sessions: %v", err)
+	}
+	removed, err := j.svc.CleanTmpDir(ctx)
+	if err != nil {
+		log.Printf("Error cleaning upload tmp directory: %v", err)
+	}
+	if expired > 0 || removed > 0 {
+		log.Printf("Upload janitor expired %d sessions and removed %d stale tmp files", expired, removed)
+	}
+}
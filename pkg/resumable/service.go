@@ -0,0 +1,347 @@
+package resumable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/capacity"
+	"github.com/Datazen-Protocol/pdp-server/pkg/keylock"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/piece"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrSessionNotFound is returned for unknown upload sessions
+	ErrSessionNotFound = apperr.New(apperr.NotFound, "SESSION_NOT_FOUND", "upload session not found")
+	// ErrSessionExpired is returned for sessions that were expired or already finalized
+	ErrSessionExpired = apperr.New(apperr.Gone, "SESSION_EXPIRED", "upload session is no longer active")
+	// ErrOffsetMismatch is returned when a chunk does not start at the current session offset
+	ErrOffsetMismatch = apperr.New(apperr.Conflict, "OFFSET_MISMATCH", "upload offset mismatch")
+	// ErrIncomplete is returned when finalizing a session that has not received all bytes
+	ErrIncomplete = apperr.New(apperr.InvalidState, "UPLOAD_INCOMPLETE", "upload is incomplete")
+	// ErrInvalidLength is returned for upload lengths that are not positive or too large
+	ErrInvalidLength = apperr.New(apperr.Invalid, "INVALID_UPLOAD_LENGTH", "invalid upload length")
+)
+
+// ResumableService manages resumable chunked uploads that finalize into pieces
+type ResumableService struct {
+	pieceSvc   *piece.PieceService
+	capacity   *capacity.Manager
+	owners     *tenant.OwnerStore
+	db         *gorm.DB
+	tmpDir     string
+	sessionTTL time.Duration
+	maxLength  int64
+	locks      keylock.Locks // Serializes writes per session ID
+}
+
+// SessionInfo describes the state of a resumable upload
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	Status    string    `json:"status"`
+	PieceID   string    `json:"piece_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewResumableService creates a new resumable upload service storing partial data under tmpDir.
+// Sessions are only visible to the tenant that created them.
+func NewResumableService(pieceSvc *piece.PieceService, capacityMgr *capacity.Manager, owners *tenant.OwnerStore, db *gorm.DB, tmpDir string, sessionTTL time.Duration, maxLength int64) (*ResumableService, error) {
+	if err := os.MkdirAll(filepath.Join(tmpDir, "uploads"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload tmp directory: %w", err)
+	}
+
+	return &ResumableService{
+		pieceSvc:   pieceSvc,
+		capacity:   capacityMgr,
+		owners:     owners,
+		db:         db,
+		tmpDir:     tmpDir,
+		sessionTTL: sessionTTL,
+		maxLength:  maxLength,
+	}, nil
+}
+
+// CreateSession opens a new upload session for length bytes
+func (s *ResumableService) CreateSession(ctx context.Context, length int64) (*SessionInfo, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("%w: must be positive", ErrInvalidLength)
+	}
+	if length > s.maxLength {
+		return nil, fmt.Errorf("%w: %d exceeds maximum of %d bytes", ErrInvalidLength, length, s.maxLength)
+	}
+
+	id := uuid.New().String()
+	tmpPath := filepath.Join(s.tmpDir, "uploads", id)
+
+	// Refuse sessions up front rather than after the client has sent everything
+	if err := s.capacity.Reserve(ctx, tenant.FromContext(ctx), reservationRef(id), length, 0); err != nil {
+		log.Printf("Rejected upload session for %d bytes: %v", length, err)
+		return nil, err
+	}
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		s.release(ctx, id)
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	file.Close()
+
+	session := &models.UploadSession{
+		ID:        id,
+		Length:    length,
+		TmpPath:   tmpPath,
+		Status:    "active",
+		ExpiresAt: time.Now().Add(s.sessionTTL),
+	}
+	if err := s.db.WithContext(ctx).Create(session).Error; err != nil {
+		os.Remove(tmpPath)
+		s.release(ctx, id)
+		return nil, fmt.Errorf("failed to create upload session: %w", err)
+	}
+	if err := s.owners.Claim(ctx, tenant.KindSession, id); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Created upload session %s for %d bytes", id, length)
+	return toSessionInfo(session), nil
+}
+
+// GetSession returns the current state of an upload session
+func (s *ResumableService) GetSession(ctx context.Context, id string) (*SessionInfo, error) {
+	session, err := s.loadSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return toSessionInfo(session), nil
+}
+
+// WriteChunk appends data to a session starting at offset and returns the new offset.
+// Bytes received before a dropped connection are kept so the client can resume from them.
+func (s *ResumableService) WriteChunk(ctx context.Context, id string, offset int64, data io.Reader) (*SessionInfo, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	session, err := s.loadSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status != "active" {
+		return nil, ErrSessionExpired
+	}
+	if offset != session.Offset {
+		return nil, fmt.Errorf("%w: expected %d, got %d", ErrOffsetMismatch, session.Offset, offset)
+	}
+
+	file, err := os.OpenFile(session.TmpPath, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek upload file: %w", err)
+	}
+
+	// Never accept more than the declared length
+	written, copyErr := io.Copy(file, io.LimitReader(data, session.Length-offset))
+	if err := file.Sync(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	session.Offset += written
+	session.ExpiresAt = time.Now().Add(s.sessionTTL)
+	if err := s.db.WithContext(ctx).
+		Model(session).
+		Updates(map[string]interface{}{
+			"offset":     session.Offset,
+			"expires_at": session.ExpiresAt,
+		}).Error; err != nil {
+		return nil, fmt.Errorf("failed to update upload session: %w", err)
+	}
+
+	if copyErr != nil {
+		log.Printf("Upload session %s interrupted at offset %d: %v", id, session.Offset, copyErr)
+		return toSessionInfo(session), fmt.Errorf("chunk interrupted: %w", copyErr)
+	}
+
+	return toSessionInfo(session), nil
+}
+
+// Finalize computes CommP over a completed upload and registers it as a piece
+func (s *ResumableService) Finalize(ctx context.Context, id string) (*piece.PieceInfo, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	session, err := s.loadSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status == "finalized" {
+		return s.pieceSvc.GetPiece(ctx, session.PieceID)
+	}
+	if session.Status != "active" {
+		return nil, ErrSessionExpired
+	}
+	if session.Offset != session.Length {
+		return nil, fmt.Errorf("%w: received %d of %d bytes", ErrIncomplete, session.Offset, session.Length)
+	}
+
+	file, err := os.Open(session.TmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+
+	// Streamed from the upload file, so pieces larger than memory can be finalized. The
+	// session keeps its space until the piece is stored, so a failed finalize can be retried.
+	pieceInfo, err := s.pieceSvc.UploadPieceFrom(ctx, "", file, session.Length)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to register piece: %w", err)
+	}
+	s.release(ctx, id)
+
+	if err := s.db.WithContext(ctx).
+		Model(session).
+		Updates(map[string]interface{}{
+			"status":   "finalized",
+			"piece_id": pieceInfo.ID,
+		}).Error; err != nil {
+		return nil, fmt.Errorf("failed to update upload session: %w", err)
+	}
+
+	if err := os.Remove(session.TmpPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to remove upload file %s: %v", session.TmpPath, err)
+	}
+
+	log.Printf("Finalized upload session %s as piece %s", id, pieceInfo.ID)
+	return pieceInfo, nil
+}
+
+// ExpireStaleSessions expires active sessions past their deadline and removes their data
+func (s *ResumableService) ExpireStaleSessions(ctx context.Context) (int, error) {
+	var stale []models.UploadSession
+	if err := s.db.WithContext(ctx).
+		Where("status = ? AND expires_at < ?", "active", time.Now()).
+		Find(&stale).Error; err != nil {
+		return 0, fmt.Errorf("failed to find stale sessions: %w", err)
+	}
+
+	expired := 0
+	for i := range stale {
+		session := &stale[i]
+		unlock := s.lock(session.ID)
+		// Only expire if the session was not extended or finalized meanwhile
+		result := s.db.WithContext(ctx).
+			Model(&models.UploadSession{}).
+			Where("id = ? AND status = ? AND expires_at < ?", session.ID, "active", time.Now()).
+			Update("status", "expired")
+		if result.Error == nil && result.RowsAffected > 0 {
+			if err := os.Remove(session.TmpPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Warning: failed to remove upload file %s: %v", session.TmpPath, err)
+			}
+			s.release(ctx, session.ID)
+			expired++
+		}
+		unlock()
+		if result.Error != nil {
+			return expired, fmt.Errorf("failed to expire session %s: %w", session.ID, result.Error)
+		}
+	}
+
+	return expired, nil
+}
+
+// CleanTmpDir removes files in the tmp directory older than the session TTL that no active session owns
+func (s *ResumableService) CleanTmpDir(ctx context.Context) (int, error) {
+	var active []models.UploadSession
+	if err := s.db.WithContext(ctx).
+		Where("status = ?", "active").
+		Find(&active).Error; err != nil {
+		return 0, fmt.Errorf("failed to list active sessions: %w", err)
+	}
+	owned := make(map[string]bool, len(active))
+	for _, session := range active {
+		owned[session.TmpPath] = true
+	}
+
+	cutoff := time.Now().Add(-s.sessionTTL)
+	removed := 0
+	err := filepath.Walk(s.tmpDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || owned[path] || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to remove stale tmp file %s: %v", path, err)
+			return nil
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to clean tmp directory: %w", err)
+	}
+
+	return removed, nil
+}
+
+// loadSession fetches a session record by ID; other tenants' sessions are not found
+func (s *ResumableService) loadSession(ctx context.Context, id string) (*models.UploadSession, error) {
+	var session models.UploadSession
+	query := s.owners.Scope(ctx, s.db.WithContext(ctx), tenant.KindSession, "id")
+	if err := query.Where("id = ?", id).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+	return &session, nil
+}
+
+// release frees the space reserved for a session
+func (s *ResumableService) release(ctx context.Context, id string) {
+	if err := s.capacity.Release(ctx, reservationRef(id)); err != nil {
+		log.Printf("Warning: failed to release reservation for upload session %s: %v", id, err)
+	}
+}
+
+// reservationRef names the capacity reservation held for a session
+func reservationRef(id string) string {
+	return "session:" + id
+}
+
+// lock serializes operations on a single session and returns the unlock function
+func (s *ResumableService) lock(id string) func() {
+	mu := s.locks.Get(id)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// toSessionInfo converts a session record to its API representation
+func toSessionInfo(session *models.UploadSession) *SessionInfo {
+	return &SessionInfo{
+		ID:        session.ID,
+		Length:    session.Length,
+		Offset:    session.Offset,
+		Status:    session.Status,
+		PieceID:   session.PieceID,
+		ExpiresAt: session.ExpiresAt,
+	}
+}
@@ -0,0 +1,124 @@
+package resumable_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/capacity"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/piece"
+	"github.com/Datazen-Protocol/pdp-server/pkg/resumable"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestService(t *testing.T) (*resumable.ResumableService, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.UploadSession{}, &models.Reservation{}, &models.Owner{}, &models.Blob{}, &models.BlobRef{},
+		&models.Piece{}, &models.PiecePreparation{}); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	store, err := blobstore.NewFileBlobstore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileBlobstore: %v", err)
+	}
+	tmpDir := t.TempDir()
+	owners := tenant.NewOwnerStore(db)
+	capacityMgr := capacity.NewManager(db, capacity.Options{TmpPath: tmpDir})
+	pieceSvc := piece.NewPieceService(nil, blobstore.NewRefStore(store, db), capacityMgr, owners, nil, time.Hour, db)
+	s, err := resumable.NewResumableService(pieceSvc, capacityMgr, owners, db, tmpDir, time.Hour, 1<<30)
+	if err != nil {
+		t.Fatalf("NewResumableService: %v", err)
+	}
+	return s, db
+}
+
+// openFiles counts the file descriptors the process holds
+func openFiles(t *testing.T) int {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("cannot count open files: %v", err)
+	}
+	return len(fds)
+}
+
+func TestWriteChunkClosesUploadFile(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), "acme")
+	s, _ := newTestService(t)
+
+	const chunks, chunkSize = 200, 512
+	session, err := s.CreateSession(ctx, chunks*chunkSize)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	before := openFiles(t)
+	chunk := bytes.Repeat([]byte{0xab}, chunkSize)
+	for i := 0; i < chunks; i++ {
+		info, err := s.WriteChunk(ctx, session.ID, int64(i*chunkSize), bytes.NewReader(chunk))
+		if err != nil {
+			t.Fatalf("WriteChunk %d: %v", i, err)
+		}
+		if info.Offset != int64((i+1)*chunkSize) {
+			t.Fatalf("offset after chunk %d = %d", i, info.Offset)
+		}
+	}
+
+	if after := openFiles(t); after > before+5 {
+		t.Fatalf("%d chunks left %d files open", chunks, after-before)
+	}
+}
+
+func TestFinalizeKeepsSpaceUntilThePieceIsStored(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), "acme")
+	s, db := newTestService(t)
+
+	// reserved reports whether the session still holds its space
+	reserved := func(id string) bool {
+		t.Helper()
+		var count int64
+		if err := db.Model(&models.Reservation{}).Where("ref = ?", "session:"+id).Count(&count).Error; err != nil {
+			t.Fatalf("count reservations: %v", err)
+		}
+		return count > 0
+	}
+	upload := func(data []byte) string {
+		t.Helper()
+		session, err := s.CreateSession(ctx, int64(len(data)))
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		if _, err := s.WriteChunk(ctx, session.ID, 0, bytes.NewReader(data)); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+		return session.ID
+	}
+
+	// Too short to have a piece commitment, so it cannot be stored
+	failed := upload([]byte("short"))
+	if _, err := s.Finalize(ctx, failed); err == nil {
+		t.Fatal("Finalize of a piece that cannot be stored succeeded")
+	}
+	if !reserved(failed) {
+		t.Fatal("failed Finalize released the session's space")
+	}
+
+	finalized := upload(bytes.Repeat([]byte("piece data"), 100))
+	if _, err := s.Finalize(ctx, finalized); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	if reserved(finalized) {
+		t.Fatal("Finalize kept the session's space after storing the piece")
+	}
+}
@@ -0,0 +1,353 @@
+package scrub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/events"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
+
+// ErrPassInProgress is returned when a pass is requested while another is still going
+var ErrPassInProgress = apperr.New(apperr.Conflict, "SCRUB_IN_PROGRESS", "a scrub pass is already in progress")
+
+// readChunk bounds each rate limited read, and so the limiter burst
+const readChunk = 1 << 20
+
+// Scrubber periodically re-reads stored pieces and checks they still hash to their PieceCID
+type Scrubber struct {
+	db            *gorm.DB
+	blobStore     blobstore.Blobstore
+	events        *events.Log // Receives a fault for every piece found corrupt
+	interval      time.Duration
+	reverifyAfter time.Duration // How long a successful verification is trusted
+	limiter       *rate.Limiter // Bounds the read rate in bytes per second
+	running       sync.Mutex
+	mutex         sync.RWMutex // Guards status
+	status        Status
+	cancel        context.CancelFunc
+	stopChan      chan struct{}
+	wg            sync.WaitGroup
+}
+
+// Status describes the scrubber's progress
+type Status struct {
+	Running         bool        `json:"running"`
+	CurrentPieceCID string      `json:"current_piece_cid,omitempty"`
+	PassStartedAt   *time.Time  `json:"pass_started_at,omitempty"`
+	PassFinishedAt  *time.Time  `json:"pass_finished_at,omitempty"`
+	PiecesVerified  int         `json:"pieces_verified"` // In the current or last pass
+	BytesVerified   int64       `json:"bytes_verified"`  // In the current or last pass
+	CorruptFound    int         `json:"corrupt_found"`   // In the current or last pass
+	TotalVerified   int64       `json:"total_verified"`
+	TotalCorrupt    int64       `json:"total_corrupt"`
+	PendingPieces   int64       `json:"pending_pieces"` // Never verified or due for re-verification
+	BytesPerSecond  int64       `json:"bytes_per_second"`
+	RecentAlerts    []AlertInfo `json:"recent_alerts"`
+}
+
+// AlertInfo describes a corruption alert
+type AlertInfo struct {
+	PieceCID  string    `json:"piece_cid"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewScrubber creates a scrubber reading at most bytesPerSecond from the blobstore; zero means unlimited
+func NewScrubber(db *gorm.DB, blobStore blobstore.Blobstore, eventLog *events.Log, interval, reverifyAfter time.Duration, bytesPerSecond int64) *Scrubber {
+	limit, burst := rate.Inf, readChunk
+	if bytesPerSecond > 0 {
+		limit = rate.Limit(bytesPerSecond)
+		if bytesPerSecond < int64(burst) {
+			burst = int(bytesPerSecond)
+		}
+	}
+	return &Scrubber{
+		db:            db,
+		blobStore:     blobStore,
+		events:        eventLog,
+		interval:      interval,
+		reverifyAfter: reverifyAfter,
+		limiter:       rate.NewLimiter(limit, burst),
+		stopChan:      make(chan struct{}),
+	}
+}
+
+// Start begins periodic scrubbing
+func (s *Scrubber) Start(ctx context.Context) error {
+	log.Printf("Starting integrity scrubber (interval %s, re-verify after %s)...", s.interval, s.reverifyAfter)
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(1)
+	go s.run(ctx)
+
+	return nil
+}
+
+// Stop stops scrubbing, abandoning any pass in progress
+func (s *Scrubber) Stop() error {
+	close(s.stopChan)
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	log.Printf("Integrity scrubber stopped")
+	return nil
+}
+
+// Trigger starts a pass in the background without waiting for the next tick
+func (s *Scrubber) Trigger() error {
+	if !s.running.TryLock() {
+		return ErrPassInProgress
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.running.Unlock()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-s.stopChan:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		s.pass(ctx)
+	}()
+
+	return nil
+}
+
+// GetStatus returns the scrubber's progress along with totals and recent alerts
+func (s *Scrubber) GetStatus(ctx context.Context) (*Status, error) {
+	s.mutex.RLock()
+	status := s.status
+	s.mutex.RUnlock()
+
+	db := s.db.WithContext(ctx)
+	if err := db.Model(&models.Piece{}).
+		Where("last_verified_at IS NOT NULL").
+		Count(&status.TotalVerified).Error; err != nil {
+		return nil, fmt.Errorf("failed to count verified pieces: %w", err)
+	}
+	if err := db.Model(&models.Piece{}).
+		Where("corrupt = ?", true).
+		Count(&status.TotalCorrupt).Error; err != nil {
+		return nil, fmt.Errorf("failed to count corrupt pieces: %w", err)
+	}
+	if err := s.due(db).Model(&models.Piece{}).Count(&status.PendingPieces).Error; err != nil {
+		return nil, fmt.Errorf("failed to count pending pieces: %w", err)
+	}
+
+	var alerts []models.ScrubAlert
+	if err := db.Order("created_at DESC").Limit(20).Find(&alerts).Error; err != nil {
+		return nil, fmt.Errorf("failed to load scrub alerts: %w", err)
+	}
+	status.RecentAlerts = make([]AlertInfo, len(alerts))
+	for i, alert := range alerts {
+		status.RecentAlerts[i] = AlertInfo{
+			PieceCID:  alert.PieceCID,
+			Message:   alert.Message,
+			CreatedAt: alert.CreatedAt,
+		}
+	}
+
+	if s.limiter.Limit() != rate.Inf {
+		status.BytesPerSecond = int64(s.limiter.Limit())
+	}
+	return &status, nil
+}
+
+// run starts a pass on every tick until stopped
+func (s *Scrubber) run(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			if !s.running.TryLock() {
+				continue
+			}
+			s.pass(ctx)
+			s.running.Unlock()
+		}
+	}
+}
+
+// due scopes a query to stored pieces that were never verified or whose verification is stale
+func (s *Scrubber) due(db *gorm.DB) *gorm.DB {
+	return db.Where("status <> ? AND corrupt = ? AND (last_verified_at IS NULL OR last_verified_at < ?)",
+		"prepared", false, time.Now().Add(-s.reverifyAfter))
+}
+
+// pass verifies every piece CID that is due, least recently verified first
+func (s *Scrubber) pass(ctx context.Context) {
+	var pieceCIDs []string
+	if err := s.due(s.db.WithContext(ctx)).
+		Model(&models.Piece{}).
+		Group("piece_cid").
+		Order("MIN(last_verified_at) ASC").
+		Pluck("piece_cid", &pieceCIDs).Error; err != nil {
+		log.Printf("Error listing pieces to scrub: %v", err)
+		return
+	}
+	if len(pieceCIDs) == 0 {
+		return
+	}
+
+	started := time.Now()
+	s.mutex.Lock()
+	s.status = Status{Running: true, PassStartedAt: &started}
+	s.mutex.Unlock()
+
+	for _, pieceCID := range pieceCIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		s.mutex.Lock()
+		s.status.CurrentPieceCID = pieceCID
+		s.mutex.Unlock()
+
+		size, err := s.verify(ctx, pieceCID)
+		if ctx.Err() != nil {
+			// Interrupted mid-read; this says nothing about the data
+			break
+		}
+		if err := s.record(ctx, pieceCID, err); err != nil {
+			log.Printf("Error recording scrub result for %s: %v", pieceCID, err)
+		}
+
+		s.mutex.Lock()
+		s.status.PiecesVerified++
+		s.status.BytesVerified += size
+		if err != nil {
+			s.status.CorruptFound++
+		}
+		s.mutex.Unlock()
+	}
+
+	finished := time.Now()
+	s.mutex.Lock()
+	s.status.Running = false
+	s.status.CurrentPieceCID = ""
+	s.status.PassFinishedAt = &finished
+	verified, corrupt := s.status.PiecesVerified, s.status.CorruptFound
+	s.mutex.Unlock()
+
+	log.Printf("Scrub pass verified %d pieces in %s, %d corrupt", verified, finished.Sub(started).Round(time.Second), corrupt)
+}
+
+// verify recomputes CommP over a stored blob and compares it with the PieceCID it is stored under
+func (s *Scrubber) verify(ctx context.Context, pieceCID string) (int64, error) {
+	obj, err := s.blobStore.Get(ctx, pieceCID)
+	if err != nil {
+		return 0, fmt.Errorf("stored data is unreadable: %v", err)
+	}
+	defer obj.Close()
+
+	cp := &commp.Calc{}
+	size, err := io.CopyBuffer(cp, &limitedReader{ctx: ctx, r: obj, limiter: s.limiter}, make([]byte, readChunk))
+	if err != nil {
+		return size, fmt.Errorf("failed to read stored data: %v", err)
+	}
+	digest, _, err := cp.Digest()
+	if err != nil {
+		return size, fmt.Errorf("failed to compute commp over %d stored bytes: %v", size, err)
+	}
+	computed, err := commcid.DataCommitmentV1ToCID(digest)
+	if err != nil {
+		return size, fmt.Errorf("failed to convert commp to piece CID: %v", err)
+	}
+	if computed.String() != pieceCID {
+		return size, fmt.Errorf("stored data hashes to %s", computed)
+	}
+	return size, nil
+}
+
+// faultEvent is the data of a piece.fault event
+type faultEvent struct {
+	PieceCID string `json:"piece_cid"`
+	Message  string `json:"message"`
+}
+
+// record stores the verification time for every piece sharing the piece CID, and marks them
+// corrupt and raises an alert when verification failed
+func (s *Scrubber) record(ctx context.Context, pieceCID string, verifyErr error) error {
+	now := time.Now()
+	updates := map[string]interface{}{"last_verified_at": now}
+	if verifyErr != nil {
+		updates["corrupt"] = true
+	}
+
+	var corrupt []models.Piece
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Piece{}).
+			Where("piece_cid = ?", pieceCID).
+			Updates(updates).Error; err != nil {
+			return err
+		}
+		if verifyErr == nil {
+			return nil
+		}
+
+		log.Printf("ALERT: piece %s is corrupt: %v", pieceCID, verifyErr)
+		if err := tx.Where("piece_cid = ?", pieceCID).Find(&corrupt).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.ScrubAlert{
+			PieceCID: pieceCID,
+			Message:  verifyErr.Error(),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// Every piece sharing the data is at fault, and may be owned by different tenants
+	for _, piece := range corrupt {
+		s.events.Publish(ctx, events.PieceFault, piece.ID, 0, &faultEvent{
+			PieceCID: pieceCID,
+			Message:  verifyErr.Error(),
+		})
+	}
+	return nil
+}
+
+// limitedReader reads in chunks no larger than the limiter burst, waiting for each
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > l.limiter.Burst() {
+		p = p[:l.limiter.Burst()]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if waitErr := l.limiter.WaitN(l.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,159 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of owned resources
+const (
+	KindPiece    = "piece"
+	KindFile     = "file"
+	KindProofSet = "proofset"
+	KindSession  = "session"
+)
+
+// OwnerStore records which tenants own which resources
+type OwnerStore struct {
+	db *gorm.DB
+}
+
+// NewOwnerStore creates a new owner store
+func NewOwnerStore(db *gorm.DB) *OwnerStore {
+	return &OwnerStore{db: db}
+}
+
+// Claim makes the context's tenant an owner of a resource; claiming twice is a no-op
+func (o *OwnerStore) Claim(ctx context.Context, kind, ref string) error {
+	owner := &models.Owner{Kind: kind, RefID: ref, Tenant: FromContext(ctx)}
+	if err := o.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(owner).Error; err != nil {
+		return fmt.Errorf("failed to record %s owner: %w", kind, err)
+	}
+	return nil
+}
+
+// Release gives up the context's ownership of a resource, or every tenant's ownership when
+// the context sees all tenants, and returns how many owners remain
+func (o *OwnerStore) Release(ctx context.Context, kind, ref string) (int64, error) {
+	db := o.db.WithContext(ctx)
+	query := db.Where("kind = ? AND ref_id = ?", kind, ref)
+	if !SeesAll(ctx) {
+		query = query.Where("tenant = ?", FromContext(ctx))
+	}
+	if err := query.Delete(&models.Owner{}).Error; err != nil {
+		return 0, fmt.Errorf("failed to release %s: %w", kind, err)
+	}
+
+	var remaining int64
+	if err := db.Model(&models.Owner{}).Where("kind = ? AND ref_id = ?", kind, ref).Count(&remaining).Error; err != nil {
+		return 0, fmt.Errorf("failed to count %s owners: %w", kind, err)
+	}
+	return remaining, nil
+}
+
+// Owners lists the tenants whose ownership of a resource Release would give up
+func (o *OwnerStore) Owners(ctx context.Context, kind, ref string) ([]string, error) {
+	query := o.db.WithContext(ctx).Model(&models.Owner{}).Where("kind = ? AND ref_id = ?", kind, ref)
+	if !SeesAll(ctx) {
+		query = query.Where("tenant = ?", FromContext(ctx))
+	}
+	var tenants []string
+	if err := query.Pluck("tenant", &tenants).Error; err != nil {
+		return nil, fmt.Errorf("failed to list %s owners: %w", kind, err)
+	}
+	return tenants, nil
+}
+
+// Owns reports whether the context may access a resource: its tenant owns it, or it sees
+// all tenants
+func (o *OwnerStore) Owns(ctx context.Context, kind, ref string) (bool, error) {
+	if SeesAll(ctx) {
+		return true, nil
+	}
+	var count int64
+	if err := o.db.WithContext(ctx).
+		Model(&models.Owner{}).
+		Where("kind = ? AND ref_id = ? AND tenant = ?", kind, ref, FromContext(ctx)).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check %s owner: %w", kind, err)
+	}
+	return count > 0, nil
+}
+
+// Scope restricts a query to rows whose column holds the ID of a resource the context may
+// access
+func (o *OwnerStore) Scope(ctx context.Context, db *gorm.DB, kind, column string) *gorm.DB {
+	if SeesAll(ctx) {
+		return db
+	}
+	return db.Where(column+" IN (?)", o.owned(ctx, kind, FromContext(ctx)))
+}
+
+// ScopeTenant restricts a query like Scope, and further to resources the given tenant owns.
+// An empty tenant adds no restriction.
+func (o *OwnerStore) ScopeTenant(ctx context.Context, db *gorm.DB, kind, column, id string) *gorm.DB {
+	db = o.Scope(ctx, db, kind, column)
+	if id == "" {
+		return db
+	}
+	return db.Where(column+" IN (?)", o.owned(ctx, kind, id))
+}
+
+// Owned returns the IDs of the resources of a kind a tenant owns
+func (o *OwnerStore) Owned(ctx context.Context, kind, id string) (map[string]bool, error) {
+	var refs []string
+	if err := o.owned(ctx, kind, id).Pluck("ref_id", &refs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list %s owners: %w", kind, err)
+	}
+	owned := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		owned[ref] = true
+	}
+	return owned, nil
+}
+
+// owned selects the IDs of the resources of a kind a tenant owns
+func (o *OwnerStore) owned(ctx context.Context, kind, id string) *gorm.DB {
+	return o.db.WithContext(ctx).
+		Model(&models.Owner{}).
+		Select("ref_id").
+		Where("kind = ? AND tenant = ?", kind, id)
+}
+
+// Adopt gives the default tenant any of the given resources that have no owner, so data
+// stored before tenants existed stays reachable
+func (o *OwnerStore) Adopt(ctx context.Context, kind string, refs []string) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	var owned []string
+	if err := o.db.WithContext(ctx).
+		Model(&models.Owner{}).
+		Distinct("ref_id").
+		Where("kind = ?", kind).
+		Pluck("ref_id", &owned).Error; err != nil {
+		return fmt.Errorf("failed to list %s owners: %w", kind, err)
+	}
+	known := make(map[string]bool, len(owned))
+	for _, ref := range owned {
+		known[ref] = true
+	}
+
+	var orphans []models.Owner
+	for _, ref := range refs {
+		if !known[ref] {
+			orphans = append(orphans, models.Owner{Kind: kind, RefID: ref, Tenant: Default})
+		}
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+	if err := o.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(orphans, 500).Error; err != nil {
+		return fmt.Errorf("failed to adopt %s records: %w", kind, err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+// Package tenant carries the client a request acts for through request contexts, so services
+// can attribute, limit and isolate what they store without threading it through every call.
+package tenant
+
+import "context"
+
+// Default is the tenant of requests that do not identify one, and owns everything stored
+// before tenants existed
+const Default = "default"
+
+type contextKey struct{}
+
+type scope struct {
+	id  string
+	all bool // May see every tenant's resources
+}
+
+// WithTenant returns a context acting for the given tenant and restricted to its resources
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, scope{id: id})
+}
+
+// WithAllTenants returns a context acting for the given tenant that may also see and manage
+// every other tenant's resources, as operators can
+func WithAllTenants(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, scope{id: id, all: true})
+}
+
+// FromContext returns the tenant a context acts for, or Default
+func FromContext(ctx context.Context) string {
+	if s, ok := ctx.Value(contextKey{}).(scope); ok && s.id != "" {
+		return s.id
+	}
+	return Default
+}
+
+// SeesAll reports whether a context may see every tenant's resources. Contexts that never
+// named a tenant belong to the server's own background work, and do.
+func SeesAll(ctx context.Context) bool {
+	s, ok := ctx.Value(contextKey{}).(scope)
+	return !ok || s.all
+}
@@ -1,26 +1,37 @@
 package upload
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/listing"
+	"github.com/Datazen-Protocol/pdp-server/pkg/piece"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
 	"github.com/multiformats/go-multibase"
 	"github.com/multiformats/go-multihash"
-	"github.com/storacha/piri/pkg/store/blobstore"
 )
 
+// ErrFileNotFound is returned for unknown upload CIDs and those of other tenants
+var ErrFileNotFound = apperr.New(apperr.NotFound, "FILE_NOT_FOUND", "file not found")
+
 // UploadService handles file uploads and storage
 type UploadService struct {
-	blobStore    blobstore.Blobstore
+	pieceSvc     *piece.PieceService
+	refStore     *blobstore.RefStore
+	owners       *tenant.OwnerStore
 	uploads      map[string]*UploadResult // Track uploaded files by CID
 	metadataFile string                  // Path to persistent metadata file
 	mutex        sync.RWMutex            // Protect concurrent access
@@ -33,24 +44,68 @@ type UploadResult struct {
 	Size       int64     `json:"size"`
 	UploadedAt time.Time `json:"uploaded_at"`
 	PieceID    string    `json:"piece_id,omitempty"`
+	PieceCID   string    `json:"piece_cid,omitempty"`
 }
 
-// NewUploadService creates a new upload service
-func NewUploadService(blobStore blobstore.Blobstore, dataDir string) *UploadService {
+// NewUploadService creates a new upload service. Files are stored as pieces in the shared
+// content-addressed store, and each upload holds its own reference to the data. Files are
+// only visible to the tenants that uploaded them; files uploaded before tenants existed
+// belong to the default tenant.
+func NewUploadService(pieceSvc *piece.PieceService, refStore *blobstore.RefStore, owners *tenant.OwnerStore, dataDir string) *UploadService {
 	metadataFile := filepath.Join(dataDir, "uploads.json")
 	
 	service := &UploadService{
-		blobStore:    blobStore,
+		pieceSvc:     pieceSvc,
+		refStore:     refStore,
+		owners:       owners,
 		uploads:      make(map[string]*UploadResult),
 		metadataFile: metadataFile,
 	}
 	
 	// Load existing metadata
 	service.loadMetadata()
+	cids := make([]string, 0, len(service.uploads))
+	for cidStr := range service.uploads {
+		cids = append(cids, cidStr)
+	}
+	if err := owners.Adopt(context.Background(), tenant.KindFile, cids); err != nil {
+		fmt.Printf("Warning: failed to assign existing files to the default tenant: %v\n", err)
+	}
+	service.adoptLegacyFiles(context.Background())
 	
 	return service
 }
 
+// adoptLegacyFiles gives files uploaded before pieces were shared their own reference to the
+// data, which was adopted at startup with a legacy reference, so deleting the file frees it
+func (s *UploadService) adoptLegacyFiles(ctx context.Context) {
+	for cidStr, result := range s.uploads {
+		if result.PieceCID != "" {
+			continue
+		}
+		key := legacyBlobKey(cidStr)
+		if err := s.refStore.AddRef(ctx, key, blobstore.RefUpload, cidStr); err != nil {
+			if !errors.Is(err, blobstore.ErrBlobNotFound) {
+				fmt.Printf("Warning: failed to reference data of file %s: %v\n", cidStr, err)
+			}
+			continue
+		}
+		if err := s.refStore.Release(ctx, key, blobstore.RefLegacy, key); err != nil {
+			fmt.Printf("Warning: failed to release legacy reference to file %s: %v\n", cidStr, err)
+		}
+	}
+}
+
+// legacyBlobKey returns the key files uploaded before pieces were shared are stored under: their
+// CID split into path segments of two characters, as the blobstore they were written to did
+func legacyBlobKey(cidStr string) string {
+	var parts []string
+	for i := 0; i < len(cidStr); i += 2 {
+		parts = append(parts, cidStr[i:min(i+2, len(cidStr))])
+	}
+	return strings.Join(parts, "/")
+}
+
 // loadMetadata loads existing upload metadata from file
 func (s *UploadService) loadMetadata() error {
 	s.mutex.Lock()
@@ -86,7 +141,11 @@ func (s *UploadService) loadMetadata() error {
 func (s *UploadService) saveMetadata() error {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	
+	return s.saveMetadataLocked()
+}
+
+// saveMetadataLocked saves upload metadata to file; the caller must hold the mutex
+func (s *UploadService) saveMetadataLocked() error {
 	// Convert map to slice
 	uploads := make([]*UploadResult, 0, len(s.uploads))
 	for _, upload := range s.uploads {
@@ -133,23 +192,31 @@ func (s *UploadService) UploadFile(ctx context.Context, file *multipart.FileHead
 		return nil, fmt.Errorf("failed to create multihash: %w", err)
 	}
 
-	// Store file in blob store
-	err = s.blobStore.Put(ctx, digest, uint64(len(data)), io.NopCloser(bytes.NewReader(data)))
-	if err != nil {
-		return nil, fmt.Errorf("failed to store file in blob store: %w", err)
-	}
-
 	// Create result with actual CID
 	cidStr, err := multibase.Encode(multibase.Base58BTC, digest)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode CID: %w", err)
 	}
 
+	// Store file as a piece so identical content uploaded through /pieces is kept once
+	pieceInfo, err := s.pieceSvc.UploadPiece(ctx, "", data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store file in blob store: %w", err)
+	}
+	if err := s.refStore.AddRef(ctx, pieceInfo.PieceCID, blobstore.RefUpload, cidStr); err != nil {
+		return nil, fmt.Errorf("failed to reference stored file: %w", err)
+	}
+	if err := s.owners.Claim(ctx, tenant.KindFile, cidStr); err != nil {
+		return nil, err
+	}
+
 	result := &UploadResult{
 		CID:        cidStr,
 		Filename:   file.Filename,
 		Size:       file.Size,
 		UploadedAt: time.Now(),
+		PieceID:    pieceInfo.ID,
+		PieceCID:   pieceInfo.PieceCID,
 	}
 
 	// Track the uploaded file (with persistence)
@@ -166,20 +233,129 @@ func (s *UploadService) UploadFile(ctx context.Context, file *multipart.FileHead
 	return result, nil
 }
 
+// DeleteFile forgets an uploaded file for the context's tenant, deleting the tenant's piece
+// of it along with it. The stored data is freed once no file, piece or proof set root
+// references it.
+func (s *UploadService) DeleteFile(ctx context.Context, cidStr string) error {
+	owned, err := s.owners.Owns(ctx, tenant.KindFile, cidStr)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return ErrFileNotFound
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result, exists := s.uploads[cidStr]
+	if !exists {
+		return ErrFileNotFound
+	}
+	// Uploading the file made the tenant an owner of its piece too
+	if result.PieceID != "" {
+		if err := s.pieceSvc.DeletePiece(ctx, result.PieceID); err != nil && !errors.Is(err, piece.ErrPieceNotFound) {
+			return fmt.Errorf("failed to delete file piece: %w", err)
+		}
+	}
+	remaining, err := s.owners.Release(ctx, tenant.KindFile, cidStr)
+	if err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return nil
+	}
+	delete(s.uploads, cidStr)
+
+	if err := s.saveMetadataLocked(); err != nil {
+		fmt.Printf("Warning: failed to save metadata: %v\n", err)
+	}
+
+	// Files uploaded before pieces were shared are stored under their own key
+	key := result.PieceCID
+	if key == "" {
+		key = legacyBlobKey(cidStr)
+	}
+	if err := s.refStore.Release(ctx, key, blobstore.RefUpload, cidStr); err != nil {
+		return fmt.Errorf("failed to release stored file: %w", err)
+	}
+
+	return nil
+}
+
 // GetFile retrieves a file by CID
 func (s *UploadService) GetFile(ctx context.Context, cidStr string) (io.ReadCloser, error) {
 	// TODO: Implement file retrieval from Piri blob store
 	return nil, fmt.Errorf("not implemented yet")
 }
 
-// ListFiles lists all uploaded files
-func (s *UploadService) ListFiles(ctx context.Context) ([]*UploadResult, error) {
+// Listing describes how files are paged: by upload time by default, or by name or size
+var Listing = listing.Listing{
+	Fields: []listing.Field{
+		{Name: "uploaded_at", Kind: listing.Time},
+		{Name: "filename", Kind: listing.String},
+		{Name: "size", Kind: listing.Int},
+	},
+	Default:  "uploaded_at",
+	Tiebreak: listing.Field{Name: "cid", Kind: listing.String},
+}
+
+// ListFiles returns a page of the files the context's tenant uploaded and the cursor of the
+// next page, if any. Files can be filtered by upload time and owning tenant.
+func (s *UploadService) ListFiles(ctx context.Context, q listing.Query) ([]*UploadResult, string, error) {
+	page, err := Listing.Page(q)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Nil sets do not restrict the listing
+	var visible, owned map[string]bool
+	if !tenant.SeesAll(ctx) {
+		if visible, err = s.owners.Owned(ctx, tenant.KindFile, tenant.FromContext(ctx)); err != nil {
+			return nil, "", err
+		}
+	}
+	if q.Tenant != "" {
+		if owned, err = s.owners.Owned(ctx, tenant.KindFile, q.Tenant); err != nil {
+			return nil, "", err
+		}
+	}
+
 	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
 	files := make([]*UploadResult, 0, len(s.uploads))
 	for _, file := range s.uploads {
-		files = append(files, file)
+		switch {
+		case visible != nil && !visible[file.CID]:
+		case owned != nil && !owned[file.CID]:
+		case !q.CreatedAfter.IsZero() && !file.UploadedAt.After(q.CreatedAfter):
+		case !page.Follows(fileSortValue(file, page.Field.Name), file.CID):
+		default:
+			files = append(files, file)
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(files, func(i, j int) bool {
+		return page.Less(fileSortValue(files[i], page.Field.Name), files[i].CID,
+			fileSortValue(files[j], page.Field.Name), files[j].CID)
+	})
+
+	var next string
+	if len(files) > page.Limit {
+		files = files[:page.Limit]
+		last := files[len(files)-1]
+		next = page.Next(fileSortValue(last, page.Field.Name), last.CID)
+	}
+	return files, next, nil
+}
+
+// fileSortValue returns the value of the Listing field a file is sorted by
+func fileSortValue(file *UploadResult, field string) interface{} {
+	switch field {
+	case "filename":
+		return file.Filename
+	case "size":
+		return file.Size
+	default:
+		return file.UploadedAt
 	}
-	return files, nil
 }
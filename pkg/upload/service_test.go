@@ -0,0 +1,80 @@
+package upload_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/blobstore"
+	"github.com/Datazen-Protocol/pdp-server/pkg/capacity"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/piece"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"github.com/Datazen-Protocol/pdp-server/pkg/upload"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// fileHeader builds the multipart header of a form file holding data
+func fileHeader(t *testing.T, name string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	part.Write(data)
+	w.Close()
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("ReadForm: %v", err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["file"][0]
+}
+
+func TestDeleteFileFreesStoredData(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), "acme")
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Piece{}, &models.PieceRoot{}, &models.PiecePreparation{}, &models.CarRoot{}, &models.CarBlock{}, &models.Reservation{},
+		&models.Owner{}, &models.Blob{}, &models.BlobRef{}); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	store, err := blobstore.NewFileBlobstore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileBlobstore: %v", err)
+	}
+	refs := blobstore.NewRefStore(store, db)
+	owners := tenant.NewOwnerStore(db)
+	capacityMgr := capacity.NewManager(db, capacity.Options{TmpPath: t.TempDir()})
+	pieceSvc := piece.NewPieceService(nil, refs, capacityMgr, owners, nil, time.Hour, db)
+	s := upload.NewUploadService(pieceSvc, refs, owners, t.TempDir())
+
+	result, err := s.UploadFile(ctx, fileHeader(t, "hello.txt", bytes.Repeat([]byte("hello, world\n"), 100)))
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if _, err := store.Stat(ctx, result.PieceCID); err != nil {
+		t.Fatalf("Stat after upload: %v", err)
+	}
+
+	if err := s.DeleteFile(ctx, result.CID); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	var notFound *blobstore.NotFoundError
+	if _, err := store.Stat(ctx, result.PieceCID); !errors.As(err, &notFound) {
+		t.Fatalf("Stat after delete: err = %v, want not found", err)
+	}
+	if _, err := pieceSvc.GetPiece(ctx, result.PieceID); !errors.Is(err, piece.ErrPieceNotFound) {
+		t.Fatalf("GetPiece after delete: err = %v, want ErrPieceNotFound", err)
+	}
+}
@@ -9,24 +9,35 @@ import (
 
 	"gorm.io/gorm"
 
+	"github.com/Datazen-Protocol/pdp-server/pkg/events"
 	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	pirimodels "github.com/storacha/piri/pkg/pdp/service/models"
 )
 
+// proveTaskName is the name of Piri's task proving possession of a proof set's data
+const proveTaskName = "PDPProve"
+
 // TransactionWatcher monitors blockchain transactions and updates our isolated database
 type TransactionWatcher struct {
 	db       *gorm.DB
-	piriDB   *gorm.DB // Piri's database for checking transaction status
+	piriDB   *gorm.DB    // Piri's database for checking transaction status
+	events   *events.Log // Receives transaction confirmations and proof results
 	stopChan chan struct{}
 	wg       sync.WaitGroup
 	mutex    sync.RWMutex
+
+	proveTasks  map[int64]int64 // Proof set of each running prove task, by task ID
+	lastProveID int64           // Newest prove task result already reported
 }
 
 // NewTransactionWatcher creates a new transaction watcher
-func NewTransactionWatcher(db *gorm.DB, piriDB *gorm.DB) *TransactionWatcher {
+func NewTransactionWatcher(db *gorm.DB, piriDB *gorm.DB, eventLog *events.Log) *TransactionWatcher {
 	return &TransactionWatcher{
-		db:       db,
-		piriDB:   piriDB,
-		stopChan: make(chan struct{}),
+		db:         db,
+		piriDB:     piriDB,
+		events:     eventLog,
+		stopChan:   make(chan struct{}),
+		proveTasks: make(map[int64]int64),
 	}
 }
 
@@ -34,6 +45,15 @@ func NewTransactionWatcher(db *gorm.DB, piriDB *gorm.DB) *TransactionWatcher {
 func (tw *TransactionWatcher) Start(ctx context.Context) error {
 	log.Printf("Starting transaction watcher...")
 
+	// Only proofs finishing from now on are reported
+	if err := tw.piriDB.WithContext(ctx).
+		Model(&pirimodels.TaskHistory{}).
+		Select("COALESCE(MAX(id), 0)").
+		Where("name = ?", proveTaskName).
+		Scan(&tw.lastProveID).Error; err != nil {
+		return fmt.Errorf("failed to read prove task history: %w", err)
+	}
+
 	tw.wg.Add(1)
 	go tw.watchTransactions(ctx)
 
@@ -67,6 +87,9 @@ func (tw *TransactionWatcher) watchTransactions(ctx context.Context) {
 			if err := tw.processPendingTransactions(ctx); err != nil {
 				log.Printf("Error processing pending transactions: %v", err)
 			}
+			if err := tw.processProofs(ctx); err != nil {
+				log.Printf("Error processing proof results: %v", err)
+			}
 		}
 	}
 }
@@ -140,6 +163,10 @@ func (tw *TransactionWatcher) checkTransactionStatus(ctx context.Context, tx *mo
 			return fmt.Errorf("failed to update transaction status: %w", err)
 		}
 
+		if piriTx.TxStatus == "confirmed" {
+			tw.publishTransaction(ctx, &piriTx)
+		}
+
 		// Handle specific transaction types
 		if piriTx.TxStatus == "confirmed" && piriTx.TxSuccess != nil && *piriTx.TxSuccess {
 			if err := tw.handleConfirmedTransaction(ctx, tx, &piriTx); err != nil {
@@ -185,6 +212,90 @@ func (tw *TransactionWatcher) updatePieceStatusForConfirmedTx(ctx context.Contex
 	return nil
 }
 
+// transactionEvent is the data of transaction.confirmed and transaction.failed events
+type transactionEvent struct {
+	TransactionHash string `json:"transaction_hash"`
+	BlockNumber     *int64 `json:"block_number,omitempty"`
+}
+
+// publishTransaction records a confirmed transaction, once for every proof set root it added
+func (tw *TransactionWatcher) publishTransaction(ctx context.Context, piriTx *models.MessageWaitsEth) {
+	typ := events.TransactionConfirmed
+	if piriTx.TxSuccess == nil || !*piriTx.TxSuccess {
+		typ = events.TransactionFailed
+	}
+	data := &transactionEvent{TransactionHash: piriTx.SignedTxHash, BlockNumber: piriTx.ConfirmedBlockNumber}
+
+	var roots []models.PieceRoot
+	if err := tw.db.WithContext(ctx).
+		Where("transaction_hash = ?", piriTx.SignedTxHash).
+		Find(&roots).Error; err != nil {
+		log.Printf("Warning: failed to find roots added by transaction %s: %v", piriTx.SignedTxHash, err)
+	}
+	if len(roots) == 0 {
+		tw.events.Publish(ctx, typ, "", 0, data)
+		return
+	}
+	for _, root := range roots {
+		tw.events.Publish(ctx, typ, root.PieceID, root.ProofSetID, data)
+	}
+}
+
+// proofEvent is the data of proof.succeeded and proof.failed events
+type proofEvent struct {
+	TaskID     int64     `json:"task_id"`
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// processProofs reports the result of every prove task attempt finished since the last check.
+// Piri forgets which proof set a task proved once it finishes, so the proof sets of running
+// tasks are remembered between checks; tasks starting and finishing in between go unreported.
+func (tw *TransactionWatcher) processProofs(ctx context.Context) error {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	var tasks []pirimodels.PDPProveTask
+	if err := tw.piriDB.WithContext(ctx).Find(&tasks).Error; err != nil {
+		return fmt.Errorf("failed to get prove tasks: %w", err)
+	}
+	running := make(map[int64]bool, len(tasks))
+	for _, task := range tasks {
+		running[task.TaskID] = true
+		tw.proveTasks[task.TaskID] = task.ProofsetID
+	}
+
+	var results []pirimodels.TaskHistory
+	if err := tw.piriDB.WithContext(ctx).
+		Where("name = ? AND id > ?", proveTaskName, tw.lastProveID).
+		Order("id ASC").
+		Find(&results).Error; err != nil {
+		return fmt.Errorf("failed to get prove task results: %w", err)
+	}
+	for _, result := range results {
+		tw.lastProveID = result.ID
+		proofSetID, ok := tw.proveTasks[result.TaskID]
+		if !ok {
+			continue
+		}
+		data := &proofEvent{TaskID: result.TaskID, FinishedAt: result.WorkEnd}
+		if result.Result {
+			tw.events.Publish(ctx, events.ProofSucceeded, "", proofSetID, data)
+		} else {
+			data.Error = result.Err
+			tw.events.Publish(ctx, events.ProofFailed, "", proofSetID, data)
+		}
+	}
+
+	// Finished tasks were reported above
+	for taskID := range tw.proveTasks {
+		if !running[taskID] {
+			delete(tw.proveTasks, taskID)
+		}
+	}
+	return nil
+}
+
 // MonitorTransaction adds a transaction to be monitored
 func (tw *TransactionWatcher) MonitorTransaction(ctx context.Context, txHash string, txType string) error {
 	tw.mutex.Lock()
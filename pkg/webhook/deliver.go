@@ -0,0 +1,381 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/events"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	pollInterval    = 5 * time.Second // How often due retries are looked for when no event arrives
+	pruneInterval   = time.Hour       // How often finished deliveries past the retention are deleted
+	eventBatch      = 100             // Events read from the log at once for a subscription
+	deliveryBatch   = 100             // Due deliveries attempted per round
+	deliveryWorkers = 8               // Deliveries attempted at once
+	maxErrorLength  = 512             // Longest error or response excerpt kept for an attempt
+)
+
+// errAddressNotAllowed is returned when a subscriber URL resolves to a non-public address
+// outside the allowed networks
+var errAddressNotAllowed = errors.New("subscriber address is not public")
+
+// reservedNetworks are not reachable from the public internet, besides the loopback, private,
+// link-local and multicast networks
+var reservedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "This" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // Reserved
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, which may translate to any IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),  // Documentation
+}
+
+// Start begins delivering events to subscriptions. Deliveries left pending by a previous run
+// are attempted again, so subscribers may receive an event more than once.
+func (s *WebhookService) Start(ctx context.Context) error {
+	s.wg.Add(1)
+	go s.run(ctx)
+	return nil
+}
+
+// Stop stops delivering events, waiting for attempts in progress
+func (s *WebhookService) Stop() error {
+	close(s.stopChan)
+	s.wg.Wait()
+	return nil
+}
+
+// run queues and attempts deliveries whenever events are published, and retries them on
+// every tick, until stopped
+func (s *WebhookService) run(ctx context.Context) {
+	defer s.wg.Done()
+
+	notify, cancel := s.events.Subscribe()
+	defer cancel()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+
+	for {
+		if err := s.queue(ctx); err != nil {
+			log.Printf("Error queueing webhook deliveries: %v", err)
+		}
+		if err := s.deliverDue(ctx); err != nil {
+			log.Printf("Error delivering webhooks: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopChan:
+			return
+		case <-notify:
+		case <-ticker.C:
+		case <-prune.C:
+			result := s.db.WithContext(ctx).
+				Where("status <> ? AND updated_at < ?", "pending", time.Now().Add(-s.opts.Retention)).
+				Delete(&models.WebhookDelivery{})
+			if result.Error != nil {
+				log.Printf("Error deleting old webhook deliveries: %v", result.Error)
+			} else if result.RowsAffected > 0 {
+				log.Printf("Deleted %d webhook deliveries older than %s", result.RowsAffected, s.opts.Retention)
+			}
+		}
+	}
+}
+
+// queue creates a pending delivery for every event published since each active subscription
+// last looked, among the events its tenant may see
+func (s *WebhookService) queue(ctx context.Context) error {
+	var subscriptions []models.WebhookSubscription
+	if err := s.db.WithContext(ctx).Where("active = ?", true).Find(&subscriptions).Error; err != nil {
+		return fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	for i := range subscriptions {
+		if err := s.queueFor(ctx, &subscriptions[i]); err != nil {
+			log.Printf("Error queueing deliveries for webhook subscription %s: %v", subscriptions[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// queueFor creates the pending deliveries of one subscription
+func (s *WebhookService) queueFor(ctx context.Context, subscription *models.WebhookSubscription) error {
+	scoped := tenant.WithTenant(ctx, subscription.Tenant)
+	if subscription.AllTenants {
+		scoped = tenant.WithAllTenants(ctx, subscription.Tenant)
+	}
+	var filter events.Filter
+	if err := json.Unmarshal(subscription.EventTypes, &filter.Types); err != nil {
+		return fmt.Errorf("unreadable event types: %w", err)
+	}
+
+	for {
+		batch, err := s.events.Since(scoped, subscription.LastEventID, filter, eventBatch)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		deliveries := make([]models.WebhookDelivery, len(batch))
+		for i, event := range batch {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				return fmt.Errorf("failed to encode event %d: %w", event.ID, err)
+			}
+			deliveries[i] = models.WebhookDelivery{
+				SubscriptionID: subscription.ID,
+				EventID:        event.ID,
+				EventType:      event.Type,
+				Payload:        payload,
+				Status:         "pending",
+				NextAttemptAt:  now,
+			}
+		}
+		last := batch[len(batch)-1].ID
+		err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error; err != nil {
+				return err
+			}
+			return tx.Model(subscription).Update("last_event_id", last).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to save deliveries: %w", err)
+		}
+		subscription.LastEventID = last
+
+		if len(batch) < eventBatch {
+			return nil
+		}
+	}
+}
+
+// deliverDue attempts the pending deliveries whose next attempt is due, oldest first.
+// Deliveries of paused subscriptions wait until they are reactivated.
+func (s *WebhookService) deliverDue(ctx context.Context) error {
+	active := s.db.WithContext(ctx).
+		Model(&models.WebhookSubscription{}).
+		Select("id").
+		Where("active = ?", true)
+	for {
+		var due []models.WebhookDelivery
+		if err := s.db.WithContext(ctx).
+			Where("status = ? AND next_attempt_at <= ? AND subscription_id IN (?)", "pending", time.Now(), active).
+			Order("next_attempt_at ASC").
+			Limit(deliveryBatch).
+			Find(&due).Error; err != nil {
+			return fmt.Errorf("failed to list due webhook deliveries: %w", err)
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		var ids []string
+		for _, delivery := range due {
+			ids = append(ids, delivery.SubscriptionID)
+		}
+		var records []models.WebhookSubscription
+		if err := s.db.WithContext(ctx).Where("id IN ?", ids).Find(&records).Error; err != nil {
+			return fmt.Errorf("failed to load webhook subscriptions: %w", err)
+		}
+		subscriptions := make(map[string]*models.WebhookSubscription, len(records))
+		for i := range records {
+			subscriptions[records[i].ID] = &records[i]
+		}
+
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, deliveryWorkers)
+		for i := range due {
+			subscription := subscriptions[due[i].SubscriptionID]
+			if subscription == nil {
+				// Deleted meanwhile, along with its deliveries
+				continue
+			}
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(delivery *models.WebhookDelivery) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				s.attempt(ctx, subscription, delivery)
+			}(&due[i])
+		}
+		wg.Wait()
+
+		if len(due) < deliveryBatch || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// attempt sends a delivery once and records the outcome: delivered on a 2xx response,
+// otherwise a retry after the backoff, or a dead letter once the attempts are used up
+func (s *WebhookService) attempt(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery) {
+	status, err := s.send(ctx, subscription, delivery)
+	if ctx.Err() != nil {
+		// Interrupted by shutdown; the delivery is attempted again on the next start
+		return
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = "delivered"
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= s.opts.MaxAttempts:
+		delivery.Status = "dead"
+		delivery.LastError = truncate(err.Error())
+		log.Printf("Webhook delivery %d to %s failed %d times, moved to dead letters: %v", delivery.ID, subscription.URL, delivery.Attempts, err)
+	default:
+		delivery.LastError = truncate(err.Error())
+		delivery.NextAttemptAt = now.Add(s.backoff(delivery.Attempts))
+	}
+
+	// Outcomes are recorded even when shutdown starts meanwhile
+	err = s.db.WithContext(context.WithoutCancel(ctx)).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.WebhookDelivery{}).
+			Where("id = ?", delivery.ID).
+			Updates(map[string]interface{}{
+				"status":          delivery.Status,
+				"attempts":        delivery.Attempts,
+				"response_status": delivery.ResponseStatus,
+				"last_error":      delivery.LastError,
+				"next_attempt_at": delivery.NextAttemptAt,
+				"delivered_at":    delivery.DeliveredAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		// Deliveries go away with their subscription, which was deleted meanwhile
+		if result.RowsAffected == 0 || delivery.Status != "dead" {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.WebhookDeadLetter{
+			DeliveryID:     delivery.ID,
+			SubscriptionID: subscription.ID,
+			URL:            subscription.URL,
+			EventID:        delivery.EventID,
+			EventType:      delivery.EventType,
+			Payload:        delivery.Payload,
+			Attempts:       delivery.Attempts,
+			LastError:      delivery.LastError,
+		}).Error
+	})
+	if err != nil {
+		log.Printf("Error recording webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// checkAddress refuses connections to subscribers at non-public addresses unless they are in
+// an allowed network. It runs for every address a subscriber URL resolves to, so DNS cannot
+// point a public name at an internal service.
+func (s *WebhookService) checkAddress(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errAddressNotAllowed, address)
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, allowed := range s.opts.AllowedNetworks {
+		if allowed.Contains(addr) {
+			return nil
+		}
+	}
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return fmt.Errorf("%w: %s", errAddressNotAllowed, addr)
+	}
+	for _, reserved := range reservedNetworks {
+		if reserved.Contains(addr) {
+			return fmt.Errorf("%w: %s", errAddressNotAllowed, addr)
+		}
+	}
+	return nil
+}
+
+// send posts a delivery's event to the subscription URL and returns the response status, or
+// zero when no response arrived. Only subscriptions of operators get an excerpt of the
+// response or the refused address in the error, so tenants cannot read internal services
+// through the delivery log.
+func (s *WebhookService) send(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pdp-server-webhooks")
+	req.Header.Set("Webhook-Id", strconv.FormatUint(delivery.ID, 10))
+	req.Header.Set("Webhook-Event", delivery.EventType)
+	req.Header.Set("Webhook-Timestamp", timestamp)
+	req.Header.Set("Webhook-Signature", "sha256="+Sign(subscription.Secret, timestamp, delivery.Payload))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		if errors.Is(err, errAddressNotAllowed) && !subscription.AllTenants {
+			return 0, errAddressNotAllowed
+		}
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res.StatusCode, nil
+	}
+	if !subscription.AllTenants {
+		return res.StatusCode, fmt.Errorf("subscriber responded %s", res.Status)
+	}
+	excerpt, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorLength))
+	return res.StatusCode, fmt.Errorf("subscriber responded %s: %s", res.Status, bytes.TrimSpace(excerpt))
+}
+
+// Sign returns the hex HMAC-SHA256, keyed with a subscription's secret, of a delivery's
+// timestamp and body joined by a dot. Subscribers compute the same to check the
+// Webhook-Signature header.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the wait after the given number of failed attempts
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	wait := s.opts.InitialBackoff
+	for i := 1; i < attempts && wait < s.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, s.opts.MaxBackoff)
+}
+
+// truncate bounds the errors kept for an attempt
+func truncate(message string) string {
+	if len(message) > maxErrorLength {
+		return message[:maxErrorLength]
+	}
+	return message
+}
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+)
+
+func TestCheckAddress(t *testing.T) {
+	s := NewWebhookService(nil, nil, Options{
+		AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("10.20.0.0/16")},
+	})
+
+	addresses := map[string]bool{
+		"93.184.216.34:443":         true,
+		"[2606:2800:220:1::1]:8443": true,
+		"10.20.1.2:80":              true, // Allowed network
+		"127.0.0.1:80":              false,
+		"[::1]:80":                  false,
+		"[::ffff:127.0.0.1]:80":     false,
+		"0.0.0.0:80":                false,
+		"[::]:80":                   false,
+		"10.0.0.1:80":               false,
+		"172.16.5.4:80":             false,
+		"192.168.1.1:80":            false,
+		"[fd00::1]:80":              false,
+		"169.254.169.254:80":        false, // Cloud metadata
+		"[fe80::1]:80":              false,
+		"224.0.0.1:80":              false,
+		"[ff02::1]:80":              false,
+		"255.255.255.255:80":        false,
+		"100.64.0.1:80":             false,
+		"192.0.0.8:80":              false,
+		"198.18.0.1:80":             false,
+		"240.0.0.1:80":              false,
+		"[64:ff9b::a00:1]:80":       false,
+		"[64:ff9b:1::a00:1]:80":     false,
+		"[2001:db8::1]:80":          false,
+		"not an address":            false,
+	}
+	for address, allowed := range addresses {
+		err := s.checkAddress("tcp", address, nil)
+		if allowed && err != nil {
+			t.Errorf("%s refused: %v", address, err)
+		}
+		if !allowed && !errors.Is(err, errAddressNotAllowed) {
+			t.Errorf("%s allowed, want errAddressNotAllowed", address)
+		}
+	}
+}
+
+func TestSendRefusesInternalSubscribers(t *testing.T) {
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal secret", http.StatusForbidden)
+	}))
+	defer subscriber.Close()
+
+	ctx := context.Background()
+	delivery := &models.WebhookDelivery{ID: 1, EventType: "piece.status", Payload: []byte(`{}`)}
+	tenantSubscription := &models.WebhookSubscription{URL: subscriber.URL, Secret: "secret"}
+	operatorSubscription := &models.WebhookSubscription{URL: subscriber.URL, Secret: "secret", AllTenants: true}
+
+	s := NewWebhookService(nil, nil, Options{Timeout: 5 * time.Second})
+	for _, subscription := range []*models.WebhookSubscription{tenantSubscription, operatorSubscription} {
+		if _, err := s.send(ctx, subscription, delivery); !errors.Is(err, errAddressNotAllowed) {
+			t.Fatalf("send to a loopback subscriber: err = %v, want errAddressNotAllowed", err)
+		}
+	}
+	if _, err := s.send(ctx, tenantSubscription, delivery); strings.Contains(err.Error(), "127.0.0.1") {
+		t.Fatalf("tenant's delivery error names the refused address: %v", err)
+	}
+
+	s = NewWebhookService(nil, nil, Options{
+		Timeout:         5 * time.Second,
+		AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+	})
+	status, err := s.send(ctx, tenantSubscription, delivery)
+	if status != http.StatusForbidden || err == nil {
+		t.Fatalf("send to an allowed subscriber: status %d, err = %v", status, err)
+	}
+	if strings.Contains(err.Error(), "internal secret") {
+		t.Fatalf("tenant's delivery error carries the response body: %v", err)
+	}
+	if _, err := s.send(ctx, operatorSubscription, delivery); err == nil || !strings.Contains(err.Error(), "internal secret") {
+		t.Fatalf("operator's delivery error lacks the response excerpt: %v", err)
+	}
+}
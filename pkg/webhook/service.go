@@ -0,0 +1,400 @@
+// Package webhook delivers lifecycle events to the URLs of webhook subscriptions. Events are
+// taken from the event log, so every state change streamed from GET /events, from the piece
+// service, the transaction watcher and the scrubber alike, can also be sent to a webhook.
+// Deliveries are signed, retried with exponential backoff and end up in a dead-letter table
+// when every attempt failed.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Datazen-Protocol/pdp-server/pkg/apperr"
+	"github.com/Datazen-Protocol/pdp-server/pkg/events"
+	"github.com/Datazen-Protocol/pdp-server/pkg/listing"
+	"github.com/Datazen-Protocol/pdp-server/pkg/models"
+	"github.com/Datazen-Protocol/pdp-server/pkg/tenant"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// secretPrefix marks generated signing secrets, so leaked ones are easy to spot
+const secretPrefix = "whsec_"
+
+var (
+	// ErrSubscriptionNotFound is returned for unknown subscription IDs and other tenants' subscriptions
+	ErrSubscriptionNotFound = apperr.New(apperr.NotFound, "WEBHOOK_NOT_FOUND", "webhook subscription not found")
+	// ErrInvalidSubscription is returned when a subscription is incompletely or wrongly described
+	ErrInvalidSubscription = apperr.New(apperr.Invalid, "INVALID_WEBHOOK", "invalid webhook subscription")
+)
+
+// Options tune how deliveries are attempted
+type Options struct {
+	MaxAttempts    int           // Attempts before a delivery becomes a dead letter
+	InitialBackoff time.Duration // Wait after the first failed attempt, doubled after each further one
+	MaxBackoff     time.Duration // Longest wait between attempts
+	Timeout        time.Duration // How long a subscriber may take to respond
+	Retention      time.Duration // How long finished deliveries are kept
+	// AllowedNetworks are non-public networks subscribers may be reached in, e.g. for webhooks
+	// on the operator's own network; subscribers are otherwise only reached at public addresses
+	AllowedNetworks []netip.Prefix
+}
+
+// WebhookService manages webhook subscriptions and delivers events to them
+type WebhookService struct {
+	db       *gorm.DB
+	events   *events.Log
+	opts     Options
+	client   *http.Client
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// SubscriptionRequest describes a subscription to create
+type SubscriptionRequest struct {
+	URL        string   `json:"url" validate:"required"`
+	Secret     string   `json:"secret,omitempty"`      // Generated when omitted
+	EventTypes []string `json:"event_types,omitempty"` // Omit for every type
+}
+
+// UpdateRequest changes the fields of a subscription it sets
+type UpdateRequest struct {
+	URL        *string   `json:"url,omitempty"`
+	Secret     *string   `json:"secret,omitempty"`
+	EventTypes *[]string `json:"event_types,omitempty"` // Empty for every type
+	Active     *bool     `json:"active,omitempty"`
+}
+
+// SubscriptionInfo describes a subscription. Secret is only set in the response to creating
+// the subscription.
+type SubscriptionInfo struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	Tenant     string    `json:"tenant"`
+	EventTypes []string  `json:"event_types"` // Empty for every type
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// DeliveryInfo describes an event sent, or still to be sent, to a subscription
+type DeliveryInfo struct {
+	ID             uint64     `json:"id"` // Sent as the Webhook-Id header
+	EventID        uint64     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"` // "pending", "delivered", "dead"
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"` // Set while pending
+	ResponseStatus int        `json:"response_status,omitempty"` // Of the last attempt
+	LastError      string     `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// DeliveryListing describes how the delivery log of a subscription is paged, newest first
+var DeliveryListing = listing.Listing{
+	Fields: []listing.Field{
+		{Name: "created_at", Column: "created_at", Kind: listing.Time},
+	},
+	Default:  "-created_at",
+	Tiebreak: listing.Field{Name: "id", Column: "id", Kind: listing.Int},
+}
+
+// NewWebhookService creates a webhook service delivering the events of eventLog
+func NewWebhookService(db *gorm.DB, eventLog *events.Log, opts Options) *WebhookService {
+	s := &WebhookService{
+		db:       db,
+		events:   eventLog,
+		opts:     opts,
+		stopChan: make(chan struct{}),
+	}
+	// Subscriber URLs are chosen by clients, so connections are checked once resolved, and
+	// never go through a proxy that could reach addresses the check refused
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   s.checkAddress,
+	}).DialContext
+	s.client = &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		// A redirect is answered like any other non-2xx response
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return s
+}
+
+// Create subscribes the context's tenant to events. Subscriptions created by operators, whose
+// context sees every tenant, receive events about every tenant's resources. Only events
+// published from now on are delivered.
+func (s *WebhookService) Create(ctx context.Context, req *SubscriptionRequest) (*SubscriptionInfo, error) {
+	if err := checkURL(req.URL); err != nil {
+		return nil, err
+	}
+	types, err := checkEventTypes(req.EventTypes)
+	if err != nil {
+		return nil, err
+	}
+	secret := req.Secret
+	if secret == "" {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, fmt.Errorf("failed to generate secret: %w", err)
+		}
+		secret = secretPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	}
+	latest, err := s.events.Latest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.WebhookSubscription{
+		ID:          uuid.New().String(),
+		Tenant:      tenant.FromContext(ctx),
+		AllTenants:  tenant.SeesAll(ctx),
+		URL:         req.URL,
+		Secret:      secret,
+		EventTypes:  types,
+		Active:      true,
+		LastEventID: latest,
+	}
+	if err := s.db.WithContext(ctx).Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to save webhook subscription: %w", err)
+	}
+
+	log.Printf("Created webhook subscription %s for tenant %s to %s", record.ID, record.Tenant, record.URL)
+	info := toSubscriptionInfo(record)
+	info.Secret = secret
+	return info, nil
+}
+
+// List returns the subscriptions the context may access, newest first, without their secrets
+func (s *WebhookService) List(ctx context.Context) ([]*SubscriptionInfo, error) {
+	var records []models.WebhookSubscription
+	if err := s.scope(ctx).Order("created_at DESC").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	subscriptions := make([]*SubscriptionInfo, len(records))
+	for i := range records {
+		subscriptions[i] = toSubscriptionInfo(&records[i])
+	}
+	return subscriptions, nil
+}
+
+// Get returns a subscription without its secret
+func (s *WebhookService) Get(ctx context.Context, id string) (*SubscriptionInfo, error) {
+	record, err := s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return toSubscriptionInfo(record), nil
+}
+
+// Update changes a subscription. Reactivated subscriptions receive events published from then
+// on; those published while they were inactive are not delivered.
+func (s *WebhookService) Update(ctx context.Context, id string, req *UpdateRequest) (*SubscriptionInfo, error) {
+	record, err := s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make(map[string]interface{})
+	if req.URL != nil {
+		if err := checkURL(*req.URL); err != nil {
+			return nil, err
+		}
+		updates["url"] = *req.URL
+	}
+	if req.Secret != nil {
+		if *req.Secret == "" {
+			return nil, fmt.Errorf("%w: secret must not be empty", ErrInvalidSubscription)
+		}
+		updates["secret"] = *req.Secret
+	}
+	if req.EventTypes != nil {
+		types, err := checkEventTypes(*req.EventTypes)
+		if err != nil {
+			return nil, err
+		}
+		updates["event_types"] = types
+	}
+	if req.Active != nil && *req.Active != record.Active {
+		updates["active"] = *req.Active
+		if *req.Active {
+			latest, err := s.events.Latest(ctx)
+			if err != nil {
+				return nil, err
+			}
+			updates["last_event_id"] = latest
+		}
+	}
+	if len(updates) == 0 {
+		return toSubscriptionInfo(record), nil
+	}
+
+	if err := s.db.WithContext(ctx).Model(record).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update webhook subscription: %w", err)
+	}
+	return s.Get(ctx, id)
+}
+
+// Delete removes a subscription and its delivery log. Its dead letters are kept.
+func (s *WebhookService) Delete(ctx context.Context, id string) error {
+	record, err := s.load(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("subscription_id = ?", record.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(record).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+
+	log.Printf("Deleted webhook subscription %s", record.ID)
+	return nil
+}
+
+// ListDeliveries returns a page of a subscription's delivery log and the cursor of the next
+// page, if any. Deliveries can be filtered by status ("pending", "delivered" or "dead") and
+// creation time.
+func (s *WebhookService) ListDeliveries(ctx context.Context, id string, q listing.Query) ([]*DeliveryInfo, string, error) {
+	page, err := DeliveryListing.Page(q)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := s.load(ctx, id); err != nil {
+		return nil, "", err
+	}
+
+	query := s.db.WithContext(ctx).Where("subscription_id = ?", id)
+	if q.Status != "" {
+		query = query.Where("status = ?", q.Status)
+	}
+	if !q.CreatedAfter.IsZero() {
+		query = query.Where("created_at > ?", q.CreatedAfter)
+	}
+
+	var records []models.WebhookDelivery
+	if err := page.Apply(query).Find(&records).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+
+	var next string
+	if len(records) > page.Limit {
+		records = records[:page.Limit]
+		last := &records[len(records)-1]
+		next = page.Next(last.CreatedAt, int64(last.ID))
+	}
+
+	deliveries := make([]*DeliveryInfo, len(records))
+	for i := range records {
+		deliveries[i] = toDeliveryInfo(&records[i])
+	}
+	return deliveries, next, nil
+}
+
+// scope restricts a query to the subscriptions the context may access
+func (s *WebhookService) scope(ctx context.Context) *gorm.DB {
+	db := s.db.WithContext(ctx)
+	if tenant.SeesAll(ctx) {
+		return db
+	}
+	return db.Where("tenant = ?", tenant.FromContext(ctx))
+}
+
+// load fetches a subscription the context may access; other tenants' subscriptions are not found
+func (s *WebhookService) load(ctx context.Context, id string) (*models.WebhookSubscription, error) {
+	var record models.WebhookSubscription
+	if err := s.scope(ctx).Where("id = ?", id).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrSubscriptionNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	return &record, nil
+}
+
+// checkURL accepts absolute http and https URLs
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidSubscription)
+	}
+	return nil
+}
+
+// checkEventTypes checks and encodes the event types of a subscription
+func checkEventTypes(types []string) (datatypes.JSON, error) {
+	for _, typ := range types {
+		if !slices.Contains(events.Types, typ) {
+			return nil, fmt.Errorf("%w: unknown event type %q, expected one of %s", ErrInvalidSubscription, typ, strings.Join(events.Types, ", "))
+		}
+	}
+	if types == nil {
+		types = []string{}
+	}
+	encoded, err := json.Marshal(types)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event types: %w", err)
+	}
+	return datatypes.JSON(encoded), nil
+}
+
+// toSubscriptionInfo converts a subscription record to its API representation
+func toSubscriptionInfo(record *models.WebhookSubscription) *SubscriptionInfo {
+	info := &SubscriptionInfo{
+		ID:        record.ID,
+		URL:       record.URL,
+		Tenant:    record.Tenant,
+		Active:    record.Active,
+		CreatedAt: record.CreatedAt,
+		UpdatedAt: record.UpdatedAt,
+	}
+	if err := json.Unmarshal(record.EventTypes, &info.EventTypes); err != nil {
+		log.Printf("Warning: webhook subscription %s has unreadable event types: %v", record.ID, err)
+	}
+	return info
+}
+
+// toDeliveryInfo converts a delivery record to its API representation
+func toDeliveryInfo(record *models.WebhookDelivery) *DeliveryInfo {
+	info := &DeliveryInfo{
+		ID:             record.ID,
+		EventID:        record.EventID,
+		EventType:      record.EventType,
+		Status:         record.Status,
+		Attempts:       record.Attempts,
+		ResponseStatus: record.ResponseStatus,
+		LastError:      record.LastError,
+		DeliveredAt:    record.DeliveredAt,
+		CreatedAt:      record.CreatedAt,
+	}
+	if record.Status == "pending" {
+		next := record.NextAttemptAt
+		info.NextAttemptAt = &next
+	}
+	return info
+}
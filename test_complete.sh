@@ -80,7 +80,8 @@ print_info "Testing piece uploads with different sizes..."
 # Test 1: Small file (will be padded to 128 bytes)
 echo "Test data 1" > small.txt
 print_info "Uploading small file ($(wc -c < small.txt) bytes)..."
-RESPONSE1=$(curl -s -X PUT -F "file=@small.txt" "$SERVER_URL/pieces/test-small")
+RESPONSE1=$(curl -s -X PUT -F "file=@small.txt" "$SERVER_URL/pieces")
+PIECE1_ID=$(echo "$RESPONSE1" | jq -r .id)
 SIZE1=$(echo "$RESPONSE1" | jq -r .size)
 CID1=$(echo "$RESPONSE1" | jq -r .piece_cid)
 if [ "$SIZE1" = "128" ]; then
@@ -92,7 +93,8 @@ fi
 # Test 2: Medium file (will be padded to 512 bytes)
 head -c 300 /dev/urandom > medium.dat
 print_info "Uploading medium file ($(wc -c < medium.dat) bytes)..."
-RESPONSE2=$(curl -s -X PUT -F "file=@medium.dat" "$SERVER_URL/pieces/test-medium")
+RESPONSE2=$(curl -s -X PUT -F "file=@medium.dat" "$SERVER_URL/pieces")
+PIECE2_ID=$(echo "$RESPONSE2" | jq -r .id)
 SIZE2=$(echo "$RESPONSE2" | jq -r .size)
 CID2=$(echo "$RESPONSE2" | jq -r .piece_cid)
 if [ "$SIZE2" = "512" ]; then
@@ -104,7 +106,8 @@ fi
 # Test 3: Large file (will be padded to 1024 bytes)
 head -c 700 /dev/urandom > large.dat
 print_info "Uploading large file ($(wc -c < large.dat) bytes)..."
-RESPONSE3=$(curl -s -X PUT -F "file=@large.dat" "$SERVER_URL/pieces/test-large")
+RESPONSE3=$(curl -s -X PUT -F "file=@large.dat" "$SERVER_URL/pieces")
+PIECE3_ID=$(echo "$RESPONSE3" | jq -r .id)
 SIZE3=$(echo "$RESPONSE3" | jq -r .size)
 CID3=$(echo "$RESPONSE3" | jq -r .piece_cid)
 if [ "$SIZE3" = "1024" ]; then
@@ -158,7 +161,7 @@ print_info "Testing piece assignment to proof sets..."
 # Add pieces to proof set 1
 print_info "Adding pieces to proof set $PS1_ID..."
 
-ADD1_RESPONSE=$(curl -s -X POST "$SERVER_URL/pieces/test-small/proofset/$PS1_ID")
+ADD1_RESPONSE=$(curl -s -X POST "$SERVER_URL/pieces/$PIECE1_ID/proofset/$PS1_ID")
 ADD1_MSG=$(echo "$ADD1_RESPONSE" | jq -r .message)
 if [[ "$ADD1_MSG" == *"successfully"* ]]; then
     print_success "Small piece added to proof set"
@@ -166,7 +169,7 @@ else
     print_error "Failed to add small piece: $ADD1_RESPONSE"
 fi
 
-ADD2_RESPONSE=$(curl -s -X POST "$SERVER_URL/pieces/test-medium/proofset/$PS1_ID")
+ADD2_RESPONSE=$(curl -s -X POST "$SERVER_URL/pieces/$PIECE2_ID/proofset/$PS1_ID")
 ADD2_MSG=$(echo "$ADD2_RESPONSE" | jq -r .message)
 if [[ "$ADD2_MSG" == *"successfully"* ]]; then
     print_success "Medium piece added to proof set"
@@ -175,7 +178,7 @@ else
 fi
 
 # Add large piece to proof set 2
-ADD3_RESPONSE=$(curl -s -X POST "$SERVER_URL/pieces/test-large/proofset/$PS2_ID")
+ADD3_RESPONSE=$(curl -s -X POST "$SERVER_URL/pieces/$PIECE3_ID/proofset/$PS2_ID")
 ADD3_MSG=$(echo "$ADD3_RESPONSE" | jq -r .message)
 if [[ "$ADD3_MSG" == *"successfully"* ]]; then
     print_success "Large piece added to proof set 2"
@@ -191,8 +194,8 @@ echo "=========================================="
 print_info "Verifying system state..."
 
 # Check piece statuses after assignment
-PIECE1_STATUS=$(curl -s "$SERVER_URL/pieces/test-small" | jq -r .status)
-PIECE1_PS=$(curl -s "$SERVER_URL/pieces/test-small" | jq -r .proof_set_id)
+PIECE1_STATUS=$(curl -s "$SERVER_URL/pieces/$PIECE1_ID" | jq -r .status)
+PIECE1_PS=$(curl -s "$SERVER_URL/pieces/$PIECE1_ID" | jq -r .proof_set_id)
 
 if [ "$PIECE1_STATUS" = "pending_confirmation" ]; then
     print_success "Piece status updated to pending_confirmation"